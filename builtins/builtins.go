@@ -48,11 +48,12 @@ func LoadAllBuiltins() {
 	vm.FuncAdd("path", UrlPath)
 	vm.FuncAdd("qs", Qs)
 
-	vm.FuncAdd("count", CountFunc)
-
 }
 
-// Count
+// Count is 1 for a non-nil value.
+//
+// Deprecated: count is an aggregate of the vm, over all the rows of a group
+// in sql, or the single row of an expression.  CountFunc is not registered.
 func CountFunc(s *vm.State, val vm.Value) (vm.IntValue, bool) {
 	if val.Err() || val.Nil() {
 		return vm.NewIntValue(0), false
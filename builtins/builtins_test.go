@@ -37,6 +37,7 @@ var (
 var builtinTests = []testBuiltins{
 
 	{`count(nonfield)`, vm.ErrValue},
+	{`count(event)`, vm.NewIntValue(1)},
 
 	{`eq(5,5)`, vm.BoolValueTrue},
 	{`eq('hello', event)`, vm.BoolValueTrue},
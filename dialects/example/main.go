@@ -25,17 +25,19 @@ var (
 		{Token: ql.TokenWhere, Lexer: ql.LexColumns, Optional: true},
 	}}
 	ourDialect = &ql.Dialect{
-		"Subscribe To", []*ql.Statement{pubsub},
+		Name:       "Subscribe To",
+		Statements: []*ql.Statement{pubsub},
+		// allow a dash in identity for this dialect only
+		IdentityChars: "_./-",
 	}
 )
 
 func init() {
-	// inject any new tokens into QLBridge.Lex describing the custom tokens we created
-	ql.TokenNameMap[TokenSubscribeTo] = &ql.TokenInfo{Description: "subscribeto"}
-
-	// OverRide the Identity Characters in lexer to allow a dash in identity
-	ql.IDENTITY_CHARS = "_./-"
-	ql.LoadTokenInfo()
+	// register any new tokens with our dialect describing the custom tokens we created
+	err := ourDialect.RegisterToken(TokenSubscribeTo, &ql.TokenInfo{Description: "subscribeto"})
+	if err != nil {
+		panic(err)
+	}
 
 	ourDialect.Init()
 }
@@ -73,7 +75,7 @@ func LexMaybe(l *ql.Lexer) ql.StateFn {
 	return ql.LexExpressionOrIdentity
 }
 
-func Tok(tok ql.TokenType, val string) ql.Token { return ql.Token{tok, val, 0} }
+func Tok(tok ql.TokenType, val string) ql.Token { return ql.Token{T: tok, V: val} }
 
 func main() {
 
@@ -23,18 +23,27 @@ var selectQl = []*ql.Clause{
 }
 
 var InfluxQlDialect *ql.Dialect = &ql.Dialect{
+	Name: "InfluxQL",
+	// Allow dashes in identities, without effecting other dialects
+	IdentityChars: "_./-",
 	Statements: []*ql.Statement{
 		&ql.Statement{ql.TokenSelect, selectQl},
 	},
 }
 
 func init() {
-	ql.TokenNameMap[TokenShortDesc] = &ql.TokenInfo{Description: "SHORTDESC"}
-	ql.TokenNameMap[TokenLongDesc] = &ql.TokenInfo{Description: "LONGDESC"}
-	ql.TokenNameMap[TokenKind] = &ql.TokenInfo{Description: "kind"}
-	// OverRide the Identity Characters in QLparse
-	ql.IDENTITY_CHARS = "_./-"
-	ql.LoadTokenInfo()
+	// register the influx specific tokens on our dialect, these do not
+	// effect any other dialect
+	tokens := map[ql.TokenType]*ql.TokenInfo{
+		TokenShortDesc: {Description: "SHORTDESC"},
+		TokenLongDesc:  {Description: "LONGDESC"},
+		TokenKind:      {Description: "kind"},
+	}
+	for tok, ti := range tokens {
+		if err := InfluxQlDialect.RegisterToken(tok, ti); err != nil {
+			panic(err)
+		}
+	}
 	InfluxQlDialect.Init()
 }
 
@@ -1,21 +1,166 @@
 package lex
 
 import (
+	"fmt"
 	u "github.com/araddon/gou"
 	"strings"
 )
 
 var _ = u.EMPTY
 
+// Dialect is a Language made up of multiple Statements
+//
+//   SQL
+//   CQL
+//   INFLUXQL   etc
+//
+// Each Dialect owns its own token table, which inherits the base tokens
+// in TokenNameMap, so custom dialects may register their own tokens
+// without colliding with other dialects loaded in the same process.
+//
+// Identity character rules are also per Dialect, if empty the package
+// defaults IDENTITY_CHARS, IDENTITY_LAX_CHARS, IDENTITY_FIRST_CHARS,
+// IDENTITY_QUOTE_CHARS are used.  As is the escaping of string values,
+// and how they are concatenated and compared.
 type Dialect struct {
 	Name       string
 	Statements []*Statement
+
+	// Non letter/digit characters allowed in an identity, ie "_./-"
+	IdentityChars string
+	// Characters allowed in an escaped identity such as `my field`
+	IdentityLaxChars string
+	// Non letter characters an identity may start with, ie "@" for @@variables
+	IdentityFirstChars string
+	// Opening quote marks for quoted identities, ie "`[" for mysql/tsql
+	// or "\"" for ansi.  Closing quote is the same mark, or ] for [
+	IdentityQuoting string
+	// How string values escape characters, backslash (default) or ansi
+	StringEscaping EscapeMode
+	// Which operator concatenates strings, none (default), || or +
+	Concat ConcatMode
+	// How strings are compared, byte wise (default) or ignoring case
+	Collation Collation
+
+	tokens   map[TokenType]*TokenInfo // tokens registered specifically for this dialect
+	keywords map[string]TokenType     // lower-case keyword to dialect token
 }
 
+// ConcatMode is which operator of a Dialect concatenates strings
+type ConcatMode uint8
+
+const (
+	// No operator concatenates, || is a logical or (mysql style)
+	ConcatNone ConcatMode = iota
+	// || concatenates, binding tighter than comparisons (ansi style)
+	//
+	//    first_name || ' ' || last_name
+	ConcatPipes
+	// + concatenates if either operand is a string (tsql style)
+	//
+	//    first_name + ' ' + last_name
+	ConcatPlus
+)
+
+// Collation is how a Dialect compares strings, for = != < > <= >=, IN,
+// BETWEEN, CASE and ORDER BY
+type Collation uint8
+
+const (
+	// Byte wise, so case sensitive, lexicographic order
+	CollateBinary Collation = iota
+	// Lexicographic order ignoring case
+	CollateNoCase
+)
+
 func (m *Dialect) Init() {
 	for _, s := range m.Statements {
-		s.init()
+		s.init(m)
+	}
+}
+
+// RegisterToken adds a custom token to this dialect.  It is an error to
+// register a TokenType or keyword which already exists in either the base
+// tokens or this dialect.
+//
+//    err := myDialect.RegisterToken(TokenSubscribeTo, &lex.TokenInfo{Description: "subscribeto"})
+//
+func (m *Dialect) RegisterToken(tok TokenType, ti *TokenInfo) error {
+	if ti == nil {
+		return fmt.Errorf("dialect %q: nil TokenInfo for token %d", m.Name, tok)
+	}
+	if existing, ok := TokenNameMap[tok]; ok {
+		return fmt.Errorf("dialect %q: token %d collides with base token %q", m.Name, tok, existing.Kw)
+	}
+	if existing, ok := m.tokens[tok]; ok {
+		return fmt.Errorf("dialect %q: token %d already registered as %q", m.Name, tok, existing.Kw)
+	}
+	// validate before loading, so a rejected TokenInfo is left unchanged
+	kw := ti.Kw
+	if kw == "" {
+		kw = ti.Description
+	}
+	kw = strings.ToLower(kw)
+	if existing, ok := baseKeywords[kw]; ok {
+		return fmt.Errorf("dialect %q: keyword %q collides with base token %d", m.Name, kw, existing)
+	}
+	if existing, ok := m.keywords[kw]; ok {
+		return fmt.Errorf("dialect %q: keyword %q already registered as token %d", m.Name, kw, existing)
+	}
+	ti.load(tok)
+	if m.tokens == nil {
+		m.tokens = make(map[TokenType]*TokenInfo)
+		m.keywords = make(map[string]TokenType)
 	}
+	m.tokens[tok] = ti
+	m.keywords[kw] = tok
+	return nil
+}
+
+// TokenInfo finds the info for this token, first looking at tokens
+// registered to this dialect, then the base tokens
+func (m *Dialect) TokenInfo(tok TokenType) (*TokenInfo, bool) {
+	if m != nil {
+		if ti, ok := m.tokens[tok]; ok {
+			return ti, true
+		}
+	}
+	ti, ok := TokenNameMap[tok]
+	return ti, ok
+}
+
+// TokenString is the keyword for this token in this dialect
+func (m *Dialect) TokenString(tok TokenType) string {
+	if ti, ok := m.TokenInfo(tok); ok {
+		return ti.Kw
+	}
+	return "not implemented"
+}
+
+// the quote marks for quoted identities in this dialect
+func (m *Dialect) identityQuoting() string {
+	if m == nil || m.IdentityQuoting == "" {
+		return IDENTITY_QUOTE_CHARS
+	}
+	return m.IdentityQuoting
+}
+
+// the identity character rules for this dialect, falling back to package defaults
+func (m *Dialect) identityChars() (chars, laxChars, firstChars string) {
+	chars, laxChars, firstChars = IDENTITY_CHARS, IDENTITY_LAX_CHARS, IDENTITY_FIRST_CHARS
+	if m == nil {
+		return
+	}
+	if m.IdentityChars != "" {
+		chars = m.IdentityChars
+	}
+	if m.IdentityLaxChars != "" {
+		laxChars = m.IdentityLaxChars
+	}
+	if m.IdentityFirstChars != "" {
+		firstChars = m.IdentityFirstChars
+	}
+	return
 }
 
 type Statement struct {
@@ -23,9 +168,9 @@ type Statement struct {
 	Clauses []*Clause
 }
 
-func (m *Statement) init() {
+func (m *Statement) init(d *Dialect) {
 	for _, clause := range m.Clauses {
-		clause.init()
+		clause.init(d)
 	}
 }
 
@@ -38,10 +183,15 @@ type Clause struct {
 	Clauses   []*Clause
 }
 
-func (c *Clause) init() {
-	c.keyword = strings.ToLower(c.Token.MatchString())
-	c.multiWord = c.Token.MultiWord()
+func (c *Clause) init(d *Dialect) {
+	if ti, ok := d.TokenInfo(c.Token); ok {
+		c.keyword = strings.ToLower(ti.matchString())
+		c.multiWord = ti.HasSpaces
+	} else {
+		c.keyword = "not implemented"
+		c.multiWord = false
+	}
 	for _, clause := range c.Clauses {
-		clause.init()
+		clause.init(d)
 	}
 }
@@ -4,10 +4,10 @@ import ()
 
 var SqlSelect = []*Clause{
 	{Token: TokenSelect, Lexer: LexColumns},
-	{Token: TokenFrom, Lexer: LexExpressionOrIdentity, Optional: true},
+	{Token: TokenFrom, Lexer: LexTableReferences, Optional: true},
 	{Token: TokenWhere, Lexer: LexColumns, Optional: true},
-	{Token: TokenHaving, Lexer: LexColumns, Optional: true},
 	{Token: TokenGroupBy, Lexer: LexColumns, Optional: true},
+	{Token: TokenHaving, Lexer: LexColumns, Optional: true},
 	{Token: TokenOrderBy, Lexer: LexOrderByColumn, Optional: true},
 	{Token: TokenLimit, Lexer: LexNumber, Optional: true},
 }
@@ -22,6 +22,20 @@ var SqlUpdate = []*Clause{
 var SqlInsert = []*Clause{
 	{Token: TokenInsert, Lexer: nil},
 	{Token: TokenInto, Lexer: LexTableNameColumns},
+	// insert into t (a, b) select ...
+	{Token: TokenSelect, Lexer: LexColumns, Optional: true},
+	{Token: TokenFrom, Lexer: LexTableReferences, Optional: true},
+	{Token: TokenWhere, Lexer: LexColumns, Optional: true},
+	{Token: TokenGroupBy, Lexer: LexColumns, Optional: true},
+	{Token: TokenHaving, Lexer: LexColumns, Optional: true},
+	{Token: TokenOrderBy, Lexer: LexOrderByColumn, Optional: true},
+	{Token: TokenLimit, Lexer: LexNumber, Optional: true},
+	{Token: TokenDuplicateKeyUpdate, Lexer: LexColumns, Optional: true},
+}
+
+var SqlUpsert = []*Clause{
+	{Token: TokenUpsert, Lexer: nil},
+	{Token: TokenInto, Lexer: LexTableNameColumns},
 }
 
 var SqlDelete = []*Clause{
@@ -34,8 +48,17 @@ var SqlDelete = []*Clause{
 
 var SqlAlter = []*Clause{
 	{Token: TokenAlter, Lexer: nil},
+	{Token: TokenTable, Lexer: LexAlterTable},
+}
+
+var SqlCreate = []*Clause{
+	{Token: TokenCreate, Lexer: nil},
+	{Token: TokenTable, Lexer: LexTableDefinition},
+}
+
+var SqlDrop = []*Clause{
+	{Token: TokenDrop, Lexer: nil},
 	{Token: TokenTable, Lexer: LexIdentifier},
-	{Token: TokenChange, Lexer: LexDdlColumn},
 }
 
 var SqlDescribe = []*Clause{
@@ -44,6 +67,7 @@ var SqlDescribe = []*Clause{
 
 var SqlShow = []*Clause{
 	{Token: TokenShow, Lexer: LexColumns},
+	{Token: TokenFrom, Lexer: LexIdentifier, Optional: true},
 }
 
 // SqlDialect is a SQL like dialect
@@ -51,22 +75,25 @@ var SqlShow = []*Clause{
 //    SELECT
 //    UPDATE
 //    INSERT
+//    UPSERT
 //    DELETE
 //
 //    SHOW idenity;
 //    DESCRIBE identity;
 // ddl
 //    ALTER
-//
-//  TODO:
-//      CREATE
+//    CREATE TABLE
+//    DROP TABLE
 var SqlDialect *Dialect = &Dialect{
 	Statements: []*Statement{
 		&Statement{TokenSelect, SqlSelect},
 		&Statement{TokenUpdate, SqlUpdate},
 		&Statement{TokenInsert, SqlInsert},
+		&Statement{TokenUpsert, SqlUpsert},
 		&Statement{TokenDelete, SqlDelete},
 		&Statement{TokenAlter, SqlAlter},
+		&Statement{TokenCreate, SqlCreate},
+		&Statement{TokenDrop, SqlDrop},
 		&Statement{TokenDescribe, SqlDescribe},
 		&Statement{TokenShow, SqlShow},
 	},
@@ -0,0 +1,93 @@
+package lex
+
+import (
+	"github.com/bmizerany/assert"
+	"testing"
+)
+
+func TestDialectRegisterToken(t *testing.T) {
+	// two dialects in same process may each use the same custom TokenType
+	var tokenCustom TokenType = 1000
+
+	subStatement := &Statement{tokenCustom, []*Clause{
+		{Token: tokenCustom, Lexer: LexColumns},
+		{Token: TokenFrom, Lexer: LexIdentifier},
+	}}
+	subDialect := &Dialect{Name: "sub", Statements: []*Statement{subStatement}}
+	err := subDialect.RegisterToken(tokenCustom, &TokenInfo{Description: "subscribeto"})
+	assert.Tf(t, err == nil, "should register %v", err)
+	subDialect.Init()
+
+	pubStatement := &Statement{tokenCustom, []*Clause{
+		{Token: tokenCustom, Lexer: LexColumns},
+	}}
+	pubDialect := &Dialect{Name: "pub", Statements: []*Statement{pubStatement}}
+	err = pubDialect.RegisterToken(tokenCustom, &TokenInfo{Description: "publish"})
+	assert.Tf(t, err == nil, "should register %v", err)
+	pubDialect.Init()
+
+	verifyLexerTokens(t, NewLexer(`SUBSCRIBETO x FROM stream`, subDialect),
+		[]Token{
+			tv(tokenCustom, "SUBSCRIBETO"),
+			tv(TokenIdentity, "x"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "stream"),
+		})
+	verifyLexerTokens(t, NewLexer(`PUBLISH x`, pubDialect),
+		[]Token{
+			tv(tokenCustom, "PUBLISH"),
+			tv(TokenIdentity, "x"),
+		})
+
+	// base tokens are not effected
+	assert.Tf(t, tokenCustom.String() == "not implemented", "should not be in base %v", tokenCustom.String())
+	assert.Tf(t, subDialect.TokenString(TokenSelect) == "select", "should inherit base tokens")
+	assert.Tf(t, pubDialect.TokenString(tokenCustom) == "publish", "has %v", pubDialect.TokenString(tokenCustom))
+
+	// collisions
+	err = subDialect.RegisterToken(tokenCustom, &TokenInfo{Description: "other"})
+	assert.Tf(t, err != nil, "should not allow duplicate token")
+	err = subDialect.RegisterToken(1001, &TokenInfo{Description: "SubscribeTo"})
+	assert.Tf(t, err != nil, "should not allow duplicate keyword")
+	err = subDialect.RegisterToken(TokenSelect, &TokenInfo{Description: "choose"})
+	assert.Tf(t, err != nil, "should not allow over-writing base token")
+	err = subDialect.RegisterToken(1002, &TokenInfo{Description: "select"})
+	assert.Tf(t, err != nil, "should not allow base keyword")
+
+	// a rejected registration leaves the TokenInfo unchanged
+	rejected := &TokenInfo{Description: "subscribeto"}
+	err = subDialect.RegisterToken(1003, rejected)
+	assert.Tf(t, err != nil && rejected.T == 0 && rejected.Kw == "", "should not load rejected %#v", rejected)
+}
+
+func TestDialectIdentityChars(t *testing.T) {
+	dashStatement := &Statement{TokenSelect, []*Clause{
+		{Token: TokenSelect, Lexer: LexColumns},
+		{Token: TokenFrom, Lexer: LexIdentifier},
+	}}
+	dashDialect := &Dialect{
+		Name:          "dash",
+		Statements:    []*Statement{dashStatement},
+		IdentityChars: "_./-",
+	}
+	dashDialect.Init()
+
+	verifyLexerTokens(t, NewLexer(`SELECT a-b FROM my-stream`, dashDialect),
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "a-b"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "my-stream"),
+		})
+
+	// sql dialect is not effected by dash dialect
+	verifyLexerTokens(t, NewSqlLexer(`SELECT a-b FROM mystream`),
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "a"),
+			tv(TokenMinus, "-"),
+			tv(TokenIdentity, "b"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "mystream"),
+		})
+}
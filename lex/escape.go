@@ -0,0 +1,124 @@
+package lex
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// EscapeMode is how a Dialect escapes characters inside of
+// quoted string values
+type EscapeMode uint8
+
+const (
+	// Backslash escapes as well as doubled quote marks (mysql style)
+	//
+	//    'item\'s'   'item''s'   "line\nbreak"   "caf\u00e9"
+	EscapeBackslash EscapeMode = iota
+	// Only doubled quote marks escape, backslash is a literal (ansi style)
+	//
+	//    'item''s'   'c:\temp'
+	EscapeAnsi
+)
+
+// UnescapeString decodes the escapes in the contents of a string value
+// (without surrounding quotes) that was quoted with the given quote mark.
+//
+//   \n \t \r \b \0 \Z    control characters
+//   \\ \' \"             literal character
+//   \uXXXX               unicode code point, ie \u00e9
+//   \% \_                left as is, they are LIKE pattern escapes
+//   ''                   doubled quote mark is a single quote mark
+//
+// In EscapeAnsi mode only the doubled quote mark is an escape.
+func UnescapeString(s string, quote rune, mode EscapeMode) (string, error) {
+	var buf bytes.Buffer
+	quoteStr := string(quote)
+	for i := 0; i < len(s); {
+		r, w := utf8.DecodeRuneInString(s[i:])
+		i += w
+		switch {
+		case r == quote && strings.HasPrefix(s[i:], quoteStr):
+			buf.WriteRune(quote)
+			i += len(quoteStr)
+		case r == '\\' && mode == EscapeBackslash:
+			if i >= len(s) {
+				return "", fmt.Errorf("trailing backslash in %q", s)
+			}
+			e, ew := utf8.DecodeRuneInString(s[i:])
+			i += ew
+			switch e {
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			case 'r':
+				buf.WriteByte('\r')
+			case 'b':
+				buf.WriteByte('\b')
+			case '0':
+				buf.WriteByte(0)
+			case 'Z':
+				buf.WriteByte('\x1a')
+			case '%', '_':
+				buf.WriteByte('\\')
+				buf.WriteRune(e)
+			case 'u':
+				if len(s)-i < 4 {
+					return "", fmt.Errorf("invalid unicode escape in %q", s)
+				}
+				cp, err := strconv.ParseUint(s[i:i+4], 16, 32)
+				if err != nil {
+					return "", fmt.Errorf("invalid unicode escape \\u%s", s[i:i+4])
+				}
+				buf.WriteRune(rune(cp))
+				i += 4
+			default:
+				// \\ \' \" and any un-recognized escape are the character itself
+				buf.WriteRune(e)
+			}
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String(), nil
+}
+
+// QuoteString is the inverse of UnescapeString, it quotes and escapes the
+// string so it will lex back to the same value
+//
+//   QuoteString(`item's`, '\'', EscapeBackslash)  =>  'item''s'
+//
+func QuoteString(s string, quote rune, mode EscapeMode) string {
+	var buf bytes.Buffer
+	buf.WriteRune(quote)
+	for _, r := range s {
+		switch {
+		case r == quote:
+			buf.WriteRune(quote)
+			buf.WriteRune(quote)
+		case mode != EscapeBackslash:
+			buf.WriteRune(r)
+		case r == '\\':
+			buf.WriteString(`\\`)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r == '\b':
+			buf.WriteString(`\b`)
+		case r == 0:
+			buf.WriteString(`\0`)
+		case r == '\x1a':
+			buf.WriteString(`\Z`)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteRune(quote)
+	return buf.String()
+}
@@ -18,7 +18,8 @@ var (
 const (
 	eof       = -1
 	decDigits = "0123456789"
-	hexDigits = "0123456789ABCDEF"
+	hexDigits = "0123456789ABCDEFabcdef"
+	binDigits = "01"
 )
 
 // StateFn represents the state of the lexer as a function that returns the
@@ -30,17 +31,49 @@ type NamedStateFn struct {
 	StateFn StateFn
 }
 
+// SyntaxError describes a failure to lex the input, with the location
+// of the problem and (when known) the set of tokens that would have been
+// valid at that position
+type SyntaxError struct {
+	Msg      string      // description of what went wrong
+	Pos      int         // byte offset of the offending token in the input
+	Line     int         // 1 based line number of Pos
+	Column   int         // 1 based column number of Pos
+	Token    Token       // the offending token
+	Expected []TokenType // tokens that would have been valid, may be empty
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at line %d col %d: %s%s", e.Line, e.Column, e.Msg, expectedString(e.Expected))
+}
+
+// describe a list of expected tokens for an error message
+func expectedString(expected []TokenType) string {
+	if len(expected) == 0 {
+		return ""
+	}
+	names := make([]string, len(expected))
+	for i, tt := range expected {
+		names[i] = tt.String()
+	}
+	return fmt.Sprintf(" expected one of [%s]", strings.Join(names, ", "))
+}
+
 // Creates a new lexer for the input string
 //
 func NewLexer(input string, dialect *Dialect) *Lexer {
-	// Two tokens of buffering is sufficient for all state functions.
 	l := &Lexer{
 		input:   input,
 		state:   LexDialectForStatement,
-		tokens:  make(chan Token, 1),
+		tokens:  make([]Token, 0, 4),
 		stack:   make([]NamedStateFn, 0, 10),
 		dialect: dialect,
 	}
+	l.identChars, l.identLaxChars, l.identFirstChars = dialect.identityChars()
+	l.identQuotes = dialect.identityQuoting()
+	if dialect != nil {
+		l.escaping = dialect.StringEscaping
+	}
 	return l
 }
 
@@ -48,65 +81,118 @@ func NewLexer(input string, dialect *Dialect) *Lexer {
 //  this is sql(ish) compatible parser
 //
 func NewSqlLexer(input string) *Lexer {
-	// Two tokens of buffering is sufficient for all state functions.
-	l := &Lexer{
-		input:   input,
-		state:   LexDialectForStatement,
-		tokens:  make(chan Token, 1),
-		stack:   make([]NamedStateFn, 0, 10),
-		dialect: SqlDialect,
-	}
-	return l
+	return NewLexer(input, SqlDialect)
 }
 
 // lexer holds the state of the lexical scanning.
 //
 // Based on the lexer from the "text/template" package.
 // See http://www.youtube.com/watch?v=HxaD_trXwRE
+//
+// Rather than a channel, scanned tokens are appended to an internal
+// buffer that is drained by NextToken(), and re-used once empty.  A Lexer
+// is not safe for concurrent use, but may be re-used via Reset()
 type Lexer struct {
-	input         string     // the string being scanned.
-	state         StateFn    // the next lexing function to enter
-	entryStateFn  StateFn    // The current clause top level StateFn
-	pos           int        // current position in the input
-	start         int        // start position of this token
-	width         int        // width of last rune read from input
-	lastToken     Token      // last token we emitted
-	tokens        chan Token // channel of scanned tokens.
-	doubleDelim   bool       // flag for tags starting with double braces
+	input         string  // the string being scanned.
+	state         StateFn // the next lexing function to enter
+	entryStateFn  StateFn // The current clause top level StateFn
+	pos           int     // current position in the input
+	start         int     // start position of this token
+	width         int     // width of last rune read from input
+	lastToken     Token   // last token we emitted
+	tokens        []Token // buffer of scanned tokens not yet returned
+	tokenPos      int     // position of next token in tokens buffer to return
+	doubleDelim   bool    // flag for tags starting with double braces
 	dialect       *Dialect
 	statement     *Statement
 	statementPos  int
 	peekedWordPos int
 	peekedWord    string
 
+	// the error which terminated the scan, if any
+	err *SyntaxError
+
+	// identity rules, from the dialect
+	identChars      string // non letter/digit runes allowed in identity
+	identLaxChars   string // runes allowed in escaped identity
+	identFirstChars string // non letter runes allowed as first rune of identity
+	identQuotes     string // opening quote marks of quoted identity
+
+	// how string values escape characters, from the dialect
+	escaping EscapeMode
+
 	// Due to nested Expressions and evaluation this allows us to descend/ascend
 	// during lex, using push/pop to add and remove states needing evaluation
 	stack []NamedStateFn
 }
 
+// Reset the lexer to scan a new input string using the same dialect,
+// re-using the already allocated token buffer and state stack
+//
+//    l := lex.NewSqlLexer("")
+//    for _, sql := range queries {
+//        l.Reset(sql)
+//        tokens := l.Tokens()
+//    }
+//
+func (l *Lexer) Reset(input string) {
+	l.input = input
+	l.state = LexDialectForStatement
+	l.entryStateFn = nil
+	l.pos = 0
+	l.start = 0
+	l.width = 0
+	l.lastToken = Token{}
+	l.tokens = l.tokens[:0]
+	l.tokenPos = 0
+	l.doubleDelim = false
+	l.statement = nil
+	l.statementPos = 0
+	l.peekedWordPos = 0
+	l.peekedWord = ""
+	l.stack = l.stack[:0]
+	l.err = nil
+}
+
 // returns the next token from the input.
 func (l *Lexer) NextToken() Token {
 
 	for {
 		//u.Debugf("token: start=%v  pos=%v  peek5=%s", l.start, l.pos, l.peekX(5))
-		select {
-		case token := <-l.tokens:
-			return token
-		default:
-			if l.state == nil && len(l.stack) > 0 {
-				l.state = l.pop()
-			} else if l.state == nil {
-				//u.Error("no state? ")
-				//panic("no state?")
-				return Token{T: TokenEOF, V: ""}
+		if l.tokenPos < len(l.tokens) {
+			token := l.tokens[l.tokenPos]
+			l.tokenPos++
+			if l.tokenPos == len(l.tokens) {
+				// buffer is drained, so re-use it from the start
+				l.tokens = l.tokens[:0]
+				l.tokenPos = 0
 			}
-			//u.Debugf("calling l.state()")
-			l.state = l.state(l)
+			return token
 		}
+		if l.state == nil && len(l.stack) > 0 {
+			l.state = l.pop()
+		} else if l.state == nil {
+			return Token{T: TokenEOF, V: "", Pos: l.pos}
+		}
+		l.state = l.state(l)
 	}
 	panic("not reached")
 }
 
+// Tokens lexes all of the remaining input and returns the tokens as a
+// batch.  The last token is always the TokenEOF or TokenError that ended
+// the scan.
+func (l *Lexer) Tokens() []Token {
+	tokens := make([]Token, 0, 16)
+	for {
+		tok := l.NextToken()
+		tokens = append(tokens, tok)
+		if tok.T == TokenEOF || tok.T == TokenError {
+			return tokens
+		}
+	}
+}
+
 func (l *Lexer) Push(name string, state StateFn) {
 	//u.LogTracef(u.INFO, "pushed item onto stack: %v", len(l.stack))
 	//u.Infof("pushed item onto stack: %v  %v", name, len(l.stack))
@@ -170,7 +256,7 @@ func (l *Lexer) PeekWord2() string {
 	word := ""
 	for i := skipWs; i < len(l.input)-l.pos; i++ {
 		r, _ := utf8.DecodeRuneInString(l.input[l.pos+i:])
-		if unicode.IsSpace(r) || !isIdentifierRune(r) {
+		if unicode.IsSpace(r) || !l.isIdentifierRune(r) {
 			u.Infof("hm:   '%v' word='%s' %v", l.input[l.pos:l.pos+i], word, l.input[l.pos:l.pos+i] == word)
 			return word
 		} else {
@@ -202,14 +288,15 @@ func (l *Lexer) PeekWord() string {
 		if ri != 1 {
 			//i += (ri - 1)
 		}
-		if unicode.IsSpace(r) || !isIdentifierRune(r) {
+		if unicode.IsSpace(r) || !l.isIdentifierRune(r) {
 			if i > 0 {
 				//u.Infof("hm:   '%v'", l.input[l.pos+skipWs:l.pos+i])
 				return l.input[l.pos+skipWs : l.pos+i]
 			}
 		}
 	}
-	return ""
+	// the word runs to end of input
+	return l.input[l.pos+skipWs:]
 }
 
 // peek word, but using laxIdentifier characters
@@ -217,7 +304,7 @@ func (l *Lexer) peekLaxWord() string {
 	word := ""
 	for i := 0; i < len(l.input)-l.pos; i++ {
 		r, _ := utf8.DecodeRuneInString(l.input[l.pos+i:])
-		if !isLaxIdentifierRune(r) {
+		if !l.isLaxIdentifierRune(r) {
 			return word
 		} else {
 			word = word + string(r)
@@ -243,7 +330,15 @@ func (l *Lexer) isEnd() bool {
 func (l *Lexer) Emit(t TokenType) {
 	//u.Debugf("emit: %s  '%s'", t, l.input[l.start:l.pos])
 	l.lastToken = Token{T: t, V: l.input[l.start:l.pos], Pos: l.start}
-	l.tokens <- l.lastToken
+	l.tokens = append(l.tokens, l.lastToken)
+	l.start = l.pos
+}
+
+// emitQuoted passes a quoted token back to the client, with the un-escaped
+// value which may differ from the raw input
+func (l *Lexer) emitQuoted(t TokenType, val string, quote rune) {
+	l.lastToken = Token{T: t, V: val, Pos: l.start, Quote: byte(quote)}
+	l.tokens = append(l.tokens, l.lastToken)
 	l.start = l.pos
 }
 
@@ -267,6 +362,26 @@ func (l *Lexer) accept(valid string) bool {
 	return false
 }
 
+// acceptDigits consumes a run of digits from the valid set, allowing a
+// single underscore to separate digits:   1_000_000
+func (l *Lexer) acceptDigits(valid string) bool {
+	if !l.accept(valid) {
+		return false
+	}
+	for {
+		if l.accept(valid) {
+			continue
+		}
+		// underscore must be followed by another digit
+		if l.pos+1 < len(l.input) && l.input[l.pos] == '_' &&
+			strings.IndexByte(valid, l.input[l.pos+1]) >= 0 {
+			l.Next()
+			continue
+		}
+		return true
+	}
+}
+
 // acceptRun consumes a run of runes from the valid set.
 func (l *Lexer) acceptRun(valid string) bool {
 	pos := l.pos
@@ -294,25 +409,84 @@ func (l *Lexer) ConsumeWord(word string) {
 	l.pos += len(word)
 }
 
+// non-consuming check of whether the word is followed by a parenthesized
+// select, ie   exists (select ...)
+func (l *Lexer) isSubQueryAfter(word string) bool {
+	rest := strings.TrimLeftFunc(l.input[l.pos+len(word):], unicode.IsSpace)
+	if !strings.HasPrefix(rest, "(") {
+		return false
+	}
+	rest = strings.TrimLeftFunc(rest[1:], unicode.IsSpace)
+	return len(rest) >= 6 && strings.EqualFold(rest[:6], "select")
+}
+
 // lineNumber reports which line we're on. Doing it this way
 // means we don't have to worry about peek double counting.
 func (l *Lexer) lineNumber() int {
-	return 1 + strings.Count(l.input[:l.pos], "\n")
+	line, _ := l.LineColumn(l.pos)
+	return line
 }
 
 // columnNumber reports which column in the current line we're on.
 func (l *Lexer) columnNumber() int {
-	n := strings.LastIndex(l.input[:l.pos], "\n")
-	if n == -1 {
-		n = 0
+	_, col := l.LineColumn(l.pos)
+	return col
+}
+
+// LineColumn reports the 1 based line and column of the given byte
+// offset in the input, suitable for pointing users at a token
+func (l *Lexer) LineColumn(pos int) (line, column int) {
+	if pos > len(l.input) {
+		pos = len(l.input)
+	} else if pos < 0 {
+		pos = 0
 	}
-	return l.pos - n
+	line = 1 + strings.Count(l.input[:pos], "\n")
+	// n is -1 on the first line, so column is always 1 based
+	n := strings.LastIndex(l.input[:pos], "\n")
+	return line, pos - n
+}
+
+// Dialect this lexer is using
+func (l *Lexer) Dialect() *Dialect {
+	return l.dialect
+}
+
+// Input is the original text being lexed
+func (l *Lexer) Input() string {
+	return l.input
+}
+
+// Err returns the SyntaxError that terminated the scan if there was one
+func (l *Lexer) Err() error {
+	if l.err == nil {
+		return nil
+	}
+	return l.err
 }
 
 // error returns an error token and terminates the scan by passing
 // back a nil pointer that will be the next state, terminating l.nextToken.
 func (l *Lexer) errorf(format string, args ...interface{}) StateFn {
-	l.tokens <- Token{T: TokenError, V: fmt.Sprintf(format, args...)}
+	msg := fmt.Sprintf(format, args...)
+	return l.syntaxError(Token{T: TokenError, V: msg, Pos: l.start}, msg, nil)
+}
+
+// syntaxError records the SyntaxError for this error token, emits it, and
+// terminates the scan by returning a nil next state
+func (l *Lexer) syntaxError(tok Token, msg string, expected []TokenType) StateFn {
+	line, col := l.LineColumn(tok.Pos)
+	l.err = &SyntaxError{
+		Msg:      msg,
+		Pos:      tok.Pos,
+		Line:     line,
+		Column:   col,
+		Token:    tok,
+		Expected: expected,
+	}
+	l.lastToken = tok
+	l.tokens = append(l.tokens, tok)
+	l.start = l.pos
 	return nil
 }
 
@@ -380,9 +554,14 @@ func (l *Lexer) tryMatch(matchTo string) bool {
 // by passing back a nil ponter that will be the next state
 // terminating lexer.next function
 func (l *Lexer) errorToken(format string, args ...interface{}) StateFn {
-	//fmt.Sprintf(format, args...)
-	l.Emit(TokenError)
-	return nil
+	return l.errorExpected(nil, format, args...)
+}
+
+// Emits an error token, noting which tokens would have been valid at
+// this position, and terminates the scan
+func (l *Lexer) errorExpected(expected []TokenType, format string, args ...interface{}) StateFn {
+	tok := Token{T: TokenError, V: l.input[l.start:l.pos], Pos: l.start}
+	return l.syntaxError(tok, fmt.Sprintf(format, args...), expected)
 }
 
 // non-consuming isExpression, expressions are defined by
@@ -428,12 +607,17 @@ func (l *Lexer) isNextKeyword(peekWord string) bool {
 		return false
 	}
 	kwMaybe := strings.ToLower(peekWord)
+	if l.isJoinKeyword(kwMaybe) {
+		return true
+	}
 	//u.Debugf("isNextKeyword?  '%s'   pos:%v len:%v", kwMaybe, l.statementPos, len(l.statement.Clauses))
 	var clause *Clause
 	for i := l.statementPos; i < len(l.statement.Clauses); i++ {
 		clause = l.statement.Clauses[i]
 		//u.Debugf("clause next keyword?    peek=%s  keyword=%v multi?%v", kwMaybe, clause.keyword, clause.multiWord)
-		if clause.keyword == kwMaybe || (clause.multiWord && strings.ToLower(l.peekX(len(clause.keyword))) == clause.keyword) {
+		// multi word keywords match on their first word, as a whole word
+		//  so that a column or table such as orders is not order by
+		if clause.keyword == kwMaybe {
 			return true
 		}
 		if !clause.Optional {
@@ -444,25 +628,79 @@ func (l *Lexer) isNextKeyword(peekWord string) bool {
 	return false
 }
 
+// the words of a join, which end the ON expression of a previous join
+var joinKeywords = map[string]TokenType{
+	"join":  TokenJoin,
+	"inner": TokenInner,
+	"left":  TokenLeft,
+	"outer": TokenOuter,
+	"cross": TokenCross,
+}
+
+// the words within an expression, other than IN and LIKE
+//
+//    x NOT IN (1,2)   x BETWEEN 1 AND 5   x IS NOT NULL   x LIKE "a!%" ESCAPE "!"
+//    CASE WHEN x > 1 THEN "many" ELSE "one" END
+//
+var exprKeywords = map[string]TokenType{
+	"not":     TokenNegate,
+	"between": TokenBetween,
+	"is":      TokenIs,
+	"null":    TokenNull,
+	"escape":  TokenEscape,
+	"case":    TokenCase,
+	"when":    TokenWhen,
+	"then":    TokenThen,
+	"else":    TokenElse,
+	"end":     TokenEnd,
+}
+
+// non-consuming check for the start of a join, only within a from
+//  clause, and not a func of the same name such as left(name, 2)
+func (l *Lexer) isJoinKeyword(word string) bool {
+	if _, ok := joinKeywords[word]; !ok || l.statementPos == 0 || l.statementPos > len(l.statement.Clauses) {
+		return false
+	}
+	return l.statement.Clauses[l.statementPos-1].Token == TokenFrom && !l.isExpr()
+}
+
 // non-consuming isIdentity
 //  Identities are non-numeric string values that are not quoted
 func (l *Lexer) isIdentity() bool {
 	// Identity are strings not values
 	r := l.Peek()
-	return isIdentifierFirstRune(r)
+	return l.isIdentifierFirstRune(r)
+}
+
+// non-consuming isParam
+//  Params are bind parameter placeholders   ?  $1  :name
+func (l *Lexer) isParam() bool {
+	next := l.peekX(2)
+	if len(next) == 0 {
+		return false
+	}
+	switch next[0] {
+	case '?':
+		return true
+	case '$':
+		return len(next) > 1 && isDigit(rune(next[1]))
+	case ':':
+		return len(next) > 1 && isAlpha(rune(next[1]))
+	}
+	return false
 }
 
 // matches expected tokentype emitting the token on success
 // and returning passed state function.
 func (l *Lexer) LexMatchSkip(tok TokenType, skip int, fn StateFn) StateFn {
 	//u.Debugf("lexMatch   t=%s peek=%s", tok, l.PeekWord())
-	if l.match(tok.String(), skip) {
+	if l.match(l.dialect.TokenString(tok), skip) {
 		//u.Debugf("found match: %s   %v", tok, fn)
 		l.Emit(tok)
 		return fn
 	}
 	u.Error("unexpected token", tok)
-	return l.errorToken("Unexpected token:" + l.current())
+	return l.errorExpected([]TokenType{tok}, "Unexpected token: %q", l.input[l.start:l.pos])
 }
 
 // lexer to match expected value returns with args of
@@ -470,7 +708,7 @@ func (l *Lexer) LexMatchSkip(tok TokenType, skip int, fn StateFn) StateFn {
 //   if no match, return nil
 func (l *Lexer) lexIfMatch(tok TokenType, matchState StateFn) StateFn {
 	l.SkipWhiteSpaces()
-	if l.tryMatch(tok.String()) {
+	if l.tryMatch(l.dialect.TokenString(tok)) {
 		l.Emit(tok)
 		return matchState
 	}
@@ -482,13 +720,13 @@ func (l *Lexer) lexIfMatch(tok TokenType, matchState StateFn) StateFn {
 func LexMatchClosure(tok TokenType, nextFn StateFn) StateFn {
 	return func(l *Lexer) StateFn {
 		//u.Debugf("lexMatch   t=%s peek=%s", tok, l.PeekWord())
-		if l.match(tok.String(), 0) {
+		if l.match(l.dialect.TokenString(tok), 0) {
 			//u.Debugf("found match: %s   %v", tok, fn)
 			l.Emit(tok)
 			return nextFn
 		}
 		u.Error("unexpected token", tok)
-		return l.errorToken("Unexpected token:" + l.current())
+		return l.errorExpected([]TokenType{tok}, "Unexpected token: %q", l.input[l.start:l.pos])
 	}
 }
 
@@ -499,6 +737,10 @@ func LexMatchClosure(tok TokenType, nextFn StateFn) StateFn {
 func LexDialectForStatement(l *Lexer) StateFn {
 
 	l.SkipWhiteSpaces()
+	if l.isEnd() {
+		// empty, or only comments after the last statement
+		return nil
+	}
 
 	r := l.Peek()
 
@@ -507,6 +749,11 @@ func LexDialectForStatement(l *Lexer) StateFn {
 		// ensure we have consumed all comments
 		l.Push("LexDialectForStatement", LexDialectForStatement)
 		return LexComment(l)
+	case ';':
+		// empty statement
+		l.Next()
+		l.Emit(TokenEOS)
+		return LexDialectForStatement
 	default:
 		peekWord := strings.ToLower(l.PeekWord())
 		for _, stmt := range l.dialect.Statements {
@@ -514,7 +761,7 @@ func LexDialectForStatement(l *Lexer) StateFn {
 				break
 			}
 			//u.Debugf("stmt lexer?  peek=%s  keyword=%v ", peekWord, stmt.Keyword.String())
-			if stmt.Keyword.String() == peekWord {
+			if l.dialect.TokenString(stmt.Keyword) == peekWord {
 				// We aren't actually going to consume anything here, just find
 				// the correct statement
 				l.statement = stmt
@@ -530,11 +777,15 @@ func LexDialectForStatement(l *Lexer) StateFn {
 			}
 
 		}
-		return l.errorToken("un recognized keyword token:" + peekWord)
+		expected := make([]TokenType, 0, len(l.dialect.Statements))
+		for _, stmt := range l.dialect.Statements {
+			expected = append(expected, stmt.Keyword)
+		}
+		return l.errorExpected(expected, "un recognized keyword token: %q", peekWord)
 
 	}
 
-	return l.errorToken("could not lex statement" + l.remainder())
+	return l.errorToken("could not lex statement: %q", l.remainder())
 }
 
 // LexStatement is the main entrypoint to lex Grammars primarily associated with QL type
@@ -638,10 +889,8 @@ func LexValue(l *Lexer) StateFn {
 	rune := l.Next()
 	typ := TokenValue
 	if rune == ')' {
-		// Whoops
-		u.Warnf("why did we get paren? ")
-		panic("should not have paren")
-		return nil
+		// Whoops, ie a missing value (1, 2 +)
+		return l.errorToken("expected value but got )")
 	}
 	if rune == '*' {
 		u.LogTracef(u.WARN, "why are we having a star here? %v", l.peekX(10))
@@ -649,44 +898,46 @@ func LexValue(l *Lexer) StateFn {
 
 	//u.Debugf("in LexValue: %v", string(rune))
 
-	// quoted string
+	// quoted string, the emitted value has escapes decoded
 	if rune == '\'' || rune == '"' {
-		firstRune := rune
+		quote := rune
 		l.ignore() // consume the quote mark
-		previousEscaped := rune == '\\'
+		escaped := false
 		for rune = l.Next(); ; rune = l.Next() {
 
-			//u.Debugf("LexValue rune=%v  end?%v  prevEscape?%v", string(rune), rune == eof, previousEscaped)
-			if (rune == '\'' || rune == '"') && rune == firstRune && !previousEscaped {
-				if !l.isEnd() {
-					rune = l.Next()
-					// check for '''
-					if rune == '\'' || rune == '"' {
-						typ = TokenValueWithSingleQuote
-					} else {
-						// since we read lookahead after single quote that ends the string
-						// for lookahead
-						l.backup()
-						// for single quote which is not part of the value
-						l.backup()
-						l.Emit(typ)
-						// now ignore that single quote
-						l.Next()
-						l.ignore()
-						return nil
-					}
-				} else {
-					// at the very end
-					l.backup()
-					l.Emit(typ)
+			//u.Debugf("LexValue rune=%v  end?%v  escaped?%v", string(rune), rune == eof, escaped)
+			switch {
+			case rune == eof:
+				return l.errorToken("string value was not delimited")
+			case rune == '\\' && l.escaping == EscapeBackslash:
+				// skip over the escaped rune, so an escaped quote doesn't end value
+				if l.Next() == eof {
+					return l.errorToken("string value was not delimited")
+				}
+				escaped = true
+			case rune == quote:
+				if strings.HasPrefix(l.input[l.pos:], string(quote)) {
+					// doubled quote mark is an escaped quote:   'item''s'
 					l.Next()
-					return nil
+					escaped = true
+					continue
 				}
+				// the closing quote is not part of the value
+				l.backup()
+				val := l.input[l.start:l.pos]
+				if escaped {
+					decoded, err := UnescapeString(val, quote, l.escaping)
+					if err != nil {
+						return l.errorToken("invalid string value: %v", err)
+					}
+					val = decoded
+				}
+				l.emitQuoted(typ, val, quote)
+				// now ignore that closing quote
+				l.Next()
+				l.ignore()
+				return nil
 			}
-			if rune == 0 {
-				return l.errorToken("string value was not delimited")
-			}
-			previousEscaped = rune == '\\'
 		}
 	} else {
 		// Non-Quoted String?   Should this be a numeric?   or date or what?  duration?  what kinds are valid?
@@ -703,6 +954,36 @@ func LexValue(l *Lexer) StateFn {
 	return nil
 }
 
+// LexParam scans a bind parameter placeholder, which are
+//  positional or named
+//
+//    ?
+//    $1
+//    :name
+//
+func LexParam(l *Lexer) StateFn {
+	switch r := l.Next(); r {
+	case '?':
+		// positional, numbered in order of appearance
+	case '$':
+		if !l.acceptRun(decDigits) {
+			return l.errorToken("invalid param %q", l.input[l.start:l.pos])
+		}
+	case ':':
+		if !isAlpha(l.Peek()) {
+			return l.errorToken("invalid param %q", l.input[l.start:l.pos])
+		}
+		for r = l.Next(); l.isIdentifierRune(r); r = l.Next() {
+		}
+		l.backup()
+	default:
+		l.backup()
+		return l.errorToken("expected param but got %q", string(r))
+	}
+	l.Emit(TokenParam)
+	return nil
+}
+
 // lex a regex:   first character must be a /
 //
 //  /^stats\./i
@@ -764,6 +1045,22 @@ func LexExpressionOrIdentity(l *Lexer) StateFn {
 	l.SkipWhiteSpaces()
 
 	//u.Debugf("LexExpressionOrIdentity identity?%v expr?%v %v peek5='%v'", l.isIdentity(), l.isExpr(), string(l.Peek()), string(l.peekX(5)))
+	// Bind parameters:    ?   $1   :name
+	if l.isParam() {
+		return LexParam(l)
+	}
+	// Quoted Identities:    `order date`
+	if l.isIdentityQuote(l.Peek()) {
+		return LexIdentifier(l)
+	}
+	// Subqueries:    (SELECT max(ct) FROM t)
+	if l.Peek() == '(' {
+		l.Next()
+		if l.isSubQuery() {
+			return l.lexSubQuery(nil)
+		}
+		l.backup()
+	}
 	// Expressions end in Parens:     LOWER(item)
 	if l.isExpr() {
 		return lexExpressionIdentifier(l)
@@ -792,7 +1089,7 @@ func LexExpressionParens(l *Lexer) StateFn {
 	//u.Debugf("LexExpressionParens:  %v", string(firstChar))
 	if firstChar != '(' {
 		u.Errorf("bad expression? %v", string(firstChar))
-		return l.errorToken("expression must begin with a paren: ( " + l.current())
+		return l.errorExpected([]TokenType{TokenLeftParenthesis}, "expression must begin with a paren: ( %q", l.input[l.start:l.pos])
 	}
 	l.Emit(TokenLeftParenthesis)
 	//u.Infof("LexExpressionParens:   %v", string(firstChar))
@@ -817,10 +1114,10 @@ func lexExpressionIdentifier(l *Lexer) StateFn {
 	}
 	if !unicode.IsLetter(firstChar) {
 		//u.Warnf("lexExpressionIdentifier couldnt find expression idenity?  %v stack=%v", string(firstChar), len(l.stack))
-		return l.errorToken("identifier must begin with a letter " + string(l.input[l.start:l.pos]))
+		return l.errorToken("identifier must begin with a letter %q", l.input[l.start:l.pos])
 	}
 	// Now look for run of runes, where run is ended by first non-identifier character
-	for rune := l.Next(); isIdentifierRune(rune); rune = l.Next() {
+	for rune := l.Next(); l.isIdentifierRune(rune); rune = l.Next() {
 		// iterate until we find non-identifer character
 	}
 	// TODO:  validate identity vs next keyword?, ie ensure it is not a keyword/reserved word
@@ -854,6 +1151,10 @@ func LexListOfArgs(l *Lexer) StateFn {
 		l.Emit(TokenRightParenthesis)
 		return nil // Send signal to pop
 	case '(':
+		if l.isSubQuery() {
+			// in (select ...)
+			return l.lexSubQuery(nil)
+		}
 		l.Emit(TokenLeftParenthesis)
 		return LexListOfArgs
 	case ',':
@@ -899,8 +1200,6 @@ func LexListOfArgs(l *Lexer) StateFn {
 // LexIdentifier scans and finds named things (tables, columns)
 //  and specifies them as TokenIdentity, uses LexIdentifierType
 //
-//  TODO: dialect controls escaping/quoting techniques
-//
 //  [name]         select [first name] from usertable;
 //  'name'         select 'user' from usertable;
 //  first_name     select first_name from usertable;
@@ -910,13 +1209,13 @@ func LexListOfArgs(l *Lexer) StateFn {
 var LexIdentifier = LexIdentifierOfType(TokenIdentity)
 
 // LexIdentifierOfType scans and finds named things (tables, columns)
-//  supports quoted, bracket, or raw identifiers
-//
-//  TODO: dialect controls escaping/quoting techniques
+//  supports quoted, bracket, or raw identifiers, the Dialect controls
+//  which quote marks are allowed
 //
 //  [name]         select [first name] from usertable;
 //  'name'         select 'user' from usertable;
 //  `user`         select first_name from `user`;
+//  "user-id"      select "user-id" from usertable;  (ansi)
 //  first_name     select first_name from usertable;
 //  usertable      select first_name AS fname from usertable;
 //  _name          select _name AS name from stuff;
@@ -926,66 +1225,81 @@ func LexIdentifierOfType(forToken TokenType) StateFn {
 	return func(l *Lexer) StateFn {
 		l.SkipWhiteSpaces()
 
-		wasQouted := false
 		// first rune has to be valid unicode letter
 		firstChar := l.Next()
 		//u.Debugf("LexIdentifierOfType:   %s is='? %v", string(firstChar), firstChar == '\'')
 		//u.LogTracef(u.INFO, "LexIdentifierOfType: %v", string(firstChar))
-		switch firstChar {
-		case '[', '\'', '`':
-			// Fields can be bracket or single quote escaped
+		if firstChar == '\'' || l.isIdentityQuote(firstChar) {
+			// Fields can be quoted by the dialects quote marks, or single quote
 			//  [user]
-			//  [email]
 			//  'email'
 			//  `user`
-			l.ignore()
-			nextChar := l.Next()
-			if !unicode.IsLetter(nextChar) {
-				u.Warnf("aborting LexIdentifierOfType: %v", string(nextChar))
-				return l.errorToken("identifier must begin with a letter " + l.input[l.start:l.pos])
-			}
-			// Since we escaped this with a quote we allow laxIdentifier characters
-			for nextChar = l.Next(); isLaxIdentifierRune(nextChar); nextChar = l.Next() {
-
-			}
-			// iterate until we find non-identifier, then make sure it is valid/end
-			if firstChar == '[' && nextChar == ']' {
-				// valid
-			} else if firstChar == '\'' && nextChar == '\'' {
-				// also valid
-			} else if firstChar == '`' && nextChar == '`' {
-				// also valid
-			} else {
-				u.Errorf("unexpected character in identifier?  %v", string(nextChar))
-				return l.errorToken("unexpected character in identifier:  " + string(nextChar))
-			}
-			wasQouted = true
-			l.backup()
-			//u.Debugf("quoted?:   %v  ", l.input[l.start:l.pos])
-		default:
-			if !isIdentifierFirstRune(firstChar) {
-				//u.Warnf("aborting LexIdentifier: '%v'", string(firstChar))
-				return l.errorToken("identifier must begin with a letter " + string(l.input[l.start:l.pos]))
-			}
-			for rune := l.Next(); isIdentifierRune(rune); rune = l.Next() {
-				// iterate until we find non-identifer character
-			}
-			l.backup()
+			return l.lexQuotedIdentifier(forToken, firstChar)
+		}
+		if !l.isIdentifierFirstRune(firstChar) {
+			//u.Warnf("aborting LexIdentifier: '%v'", string(firstChar))
+			return l.errorToken("identifier must begin with a letter %q", l.input[l.start:l.pos])
+		}
+		for rune := l.Next(); l.isIdentifierRune(rune); rune = l.Next() {
+			// iterate until we find non-identifer character
 		}
+		l.backup()
 
 		//u.Debugf("about to emit: %v", forToken)
 		l.Emit(forToken)
-		if wasQouted {
-			// need to skip last character bc it was quoted
-			l.Next()
-			l.ignore()
-		}
 
 		//u.Debugf("about to return:  %v", nextFn)
 		return nil // pop up to parent
 	}
 }
 
+// lexQuotedIdentifier scans the remainder of a quoted identity whose opening
+// quote mark has already been consumed.  Any character is allowed inside
+// the quotes, a doubled closing quote mark is an escaped quote mark.
+//
+//  `order date`     -> order date
+//  [user-id]        -> user-id
+//  `from`           -> from
+//  `my``col`        -> my`col
+//
+func (l *Lexer) lexQuotedIdentifier(forToken TokenType, quote rune) StateFn {
+	closeQuote := quote
+	if quote == '[' {
+		closeQuote = ']'
+	}
+	l.ignore() // consume the opening quote
+	escaped := false
+	for {
+		r := l.Next()
+		if r == eof {
+			return l.errorToken("quoted identifier was not delimited: %q", l.input[l.start:l.pos])
+		}
+		if r != closeQuote {
+			continue
+		}
+		if strings.HasPrefix(l.input[l.pos:], string(closeQuote)) {
+			// doubled quote mark is an escaped quote
+			l.Next()
+			escaped = true
+			continue
+		}
+		break
+	}
+	l.backup() // the closing quote is not part of the value
+	if l.start == l.pos {
+		return l.errorToken("quoted identifier may not be empty")
+	}
+	val := l.input[l.start:l.pos]
+	if escaped {
+		val = strings.Replace(val, string(closeQuote)+string(closeQuote), string(closeQuote), -1)
+	}
+	l.emitQuoted(forToken, val, quote)
+	// now ignore the closing quote
+	l.Next()
+	l.ignore()
+	return nil // pop up to parent
+}
+
 // Look for end of statement defined by either a semicolon or end of file
 func LexEndOfStatement(l *Lexer) StateFn {
 	l.SkipWhiteSpaces()
@@ -993,13 +1307,29 @@ func LexEndOfStatement(l *Lexer) StateFn {
 	//u.Debugf("sqlend of statement  '%s' r=%d", string(r), r)
 	if r == ';' {
 		l.Emit(TokenEOS)
+	} else {
+		l.backup()
 	}
 	l.SkipWhiteSpaces()
 	if l.isEnd() {
 		return nil
 	}
+	if r == ';' {
+		// scripts may have many statements, so lex the next
+		// one with fresh statement state
+		l.statement = nil
+		l.statementPos = 0
+		l.entryStateFn = nil
+		return LexDialectForStatement
+	}
 	u.Warnf("error looking for end of statement: '%v'", l.remainder())
-	return l.errorToken("Unexpected token:" + l.current())
+	// consume the offending word so it is included in the error token
+	for r = l.Next(); r != eof && !unicode.IsSpace(r); r = l.Next() {
+	}
+	if r != eof {
+		l.backup()
+	}
+	return l.errorExpected([]TokenType{TokenEOS, TokenEOF}, "Unexpected token: %q", l.input[l.start:l.pos])
 }
 
 // Handle logical columns/expressions which may be nested
@@ -1043,7 +1373,7 @@ func LexColumns(l *Lexer) StateFn {
 
 	// Cover the logic and grouping
 	switch r {
-	case '!', '=', '>', '<', '(', ')', ',', ';', '-', '*', '+', '%', '/':
+	case '!', '=', '>', '<', '(', ')', ',', ';', '-', '*', '+', '%', '/', '|':
 		foundLogical := false
 		foundOperator := false
 		switch r {
@@ -1062,6 +1392,9 @@ func LexColumns(l *Lexer) StateFn {
 			return nil
 		case '(': // this is a logical Grouping/Ordering
 			//l.Push("LexParenEnd", LexParenEnd)
+			if l.isSubQuery() {
+				return l.lexSubQuery(l.entryStateFn)
+			}
 			l.Emit(TokenLeftParenthesis)
 			return l.entryStateFn
 		case ')': // this is a logical Grouping/Ordering
@@ -1122,6 +1455,9 @@ func LexColumns(l *Lexer) StateFn {
 				l.Next()
 				l.Emit(TokenNE)
 				foundOperator = true
+			} else {
+				l.Emit(TokenLT)
+				foundLogical = true
 			}
 		case '+':
 			if r2 := l.Peek(); r2 == '=' {
@@ -1142,6 +1478,12 @@ func LexColumns(l *Lexer) StateFn {
 		case '/':
 			l.Emit(TokenDivide)
 			foundOperator = true
+		case '|': //  ||  a logical or, or concatenation in some dialects
+			if r2 := l.Peek(); r2 == '|' {
+				l.Next()
+				l.Emit(TokenOr)
+				foundOperator = true
+			}
 		}
 		if foundLogical == true {
 			//u.Debugf("found LexColumns = '%v'", string(r))
@@ -1176,6 +1518,17 @@ func LexColumns(l *Lexer) StateFn {
 		l.Push("LexColumns", l.entryStateFn)
 		//l.Push("LexExpression", LexExpression)
 		return nil
+	case "exists":
+		// exists (select ...), otherwise it is the exists(field) func
+		if l.isSubQueryAfter(op) {
+			l.ConsumeWord(op)
+			l.Emit(TokenExists)
+			return LexColumns
+		}
+	case "not", "between", "is", "null", "escape", "case", "when", "then", "else", "end":
+		l.ConsumeWord(op)
+		l.Emit(exprKeywords[op])
+		return LexColumns
 	case "in", "like": // what is complete list here?
 		switch op {
 		case "in": // IN
@@ -1339,6 +1692,9 @@ func LexExpression(l *Lexer) StateFn {
 				l.Next()
 				l.Emit(TokenNE)
 				foundOperator = true
+			} else {
+				l.Emit(TokenLT)
+				foundLogical = true
 			}
 		case '*':
 			l.Emit(TokenMultiply)
@@ -1397,6 +1753,10 @@ func LexExpression(l *Lexer) StateFn {
 			l.Push("LexExpressionOrIdentity", LexExpressionOrIdentity)
 			return nil
 		}
+	case "not", "between", "is", "null", "escape", "case", "when", "then", "else", "end":
+		l.ConsumeWord(op)
+		l.Emit(exprKeywords[op])
+		return LexExpression
 	case "and", "or":
 		// this marks beginning of new related column
 		switch op {
@@ -1439,9 +1799,10 @@ func LexExpression(l *Lexer) StateFn {
 	return LexExpressionOrIdentity
 }
 
-// Handle columnar identies with keyword appendate (ASC, DESC)
+// Handle columnar identies with keyword appendate (ASC, DESC, NULLS FIRST|LAST)
 //
 //     [ORDER BY] abc, def ASC
+//     [ORDER BY] toint(abc) * 2 DESC NULLS LAST, def
 //
 func LexOrderByColumn(l *Lexer) StateFn {
 
@@ -1459,8 +1820,15 @@ func LexOrderByColumn(l *Lexer) StateFn {
 	case ',':
 		l.Next()
 		l.Emit(TokenComma)
-		l.Push("LexOrderByColumn", LexOrderByColumn)
-		return LexExpressionOrIdentity
+		return LexOrderByColumn
+	case '*', '/', '%', '+', '-', '=', '!', '>', '<', '|', '&':
+		// operator, the rest of the expression for this column
+		if r == '-' && l.peekX(2) == "--" {
+			l.Push("LexOrderByColumn", LexOrderByColumn)
+			return LexInlineComment
+		}
+		l.entryStateFn = LexOrderByColumn
+		return LexExpression
 	}
 
 	op := strings.ToLower(l.PeekWord())
@@ -1469,13 +1837,30 @@ func LexOrderByColumn(l *Lexer) StateFn {
 	case "asc":
 		l.ConsumeWord("asc")
 		l.Emit(TokenAsc)
-		return nil
+		return LexOrderByColumn
 	case "desc":
 		l.ConsumeWord("desc")
 		l.Emit(TokenDesc)
-		return nil
+		return LexOrderByColumn
+	case "nulls":
+		for _, tok := range []TokenType{TokenNullsFirst, TokenNullsLast} {
+			if l.tryMatch(l.dialect.TokenString(tok)) {
+				l.Emit(tok)
+				return LexOrderByColumn
+			}
+		}
+		return l.errorExpected([]TokenType{TokenNullsFirst, TokenNullsLast}, "expected NULLS FIRST or NULLS LAST")
 	default:
-		if len(l.stack) < 2 {
+		if tok, ok := exprKeywords[op]; ok {
+			//  ORDER BY CASE WHEN ct > 1 THEN 1 ELSE 2 END
+			l.ConsumeWord(op)
+			l.Emit(tok)
+			return LexOrderByColumn
+		}
+		if l.isNextKeyword(op) {
+			return nil
+		}
+		if len(l.stack) < 100 {
 			l.Push("LexOrderByColumn", LexOrderByColumn)
 			return LexExpressionOrIdentity
 		} else {
@@ -1497,6 +1882,9 @@ func LexOrderByColumn(l *Lexer) StateFn {
 func LexDdlColumn(l *Lexer) StateFn {
 
 	l.SkipWhiteSpaces()
+	if l.isEnd() {
+		return nil
+	}
 	r := l.Next()
 
 	//u.Debugf("LexDdlColumn  r= '%v'", string(r))
@@ -1517,6 +1905,10 @@ func LexDdlColumn(l *Lexer) StateFn {
 	case ',':
 		l.Emit(TokenComma)
 		return l.entryStateFn
+	case ')':
+		// end of create table column definitions
+		l.Emit(TokenRightParenthesis)
+		return nil
 	}
 
 	l.backup()
@@ -1531,6 +1923,14 @@ func LexDdlColumn(l *Lexer) StateFn {
 		l.ConsumeWord(word)
 		l.Emit(TokenAdd)
 		return LexDdlColumn
+	case "drop":
+		l.ConsumeWord(word)
+		l.Emit(TokenDrop)
+		return LexDdlColumn
+	case "column":
+		l.ConsumeWord(word)
+		l.Emit(TokenColumn)
+		return LexDdlColumn
 	case "after":
 		l.ConsumeWord(word)
 		l.Emit(TokenAfter)
@@ -1539,6 +1939,26 @@ func LexDdlColumn(l *Lexer) StateFn {
 		l.ConsumeWord(word)
 		l.Emit(TokenFirst)
 		return LexDdlColumn
+	case "not": // not null
+		nn := strings.ToLower(l.peekX(len("not null")))
+		if nn == "not null" {
+			l.ConsumeWord(nn)
+			l.Emit(TokenNotNull)
+			return l.entryStateFn
+		}
+	case "default":
+		l.ConsumeWord(word)
+		l.Emit(TokenDefault)
+		l.Push("LexDdlColumn", l.entryStateFn)
+		return LexExpressionOrIdentity
+	case "primary": // primary key (col1, col2)
+		pk := strings.ToLower(l.peekX(len("primary key")))
+		if pk == "primary key" {
+			l.ConsumeWord(pk)
+			l.Emit(TokenPrimaryKey)
+			l.Push("LexDdlColumn", l.entryStateFn)
+			return LexListOfArgs
+		}
 
 	// Character set is end of ddl column
 	case "character": // character set
@@ -1589,6 +2009,172 @@ func LexDdlColumn(l *Lexer) StateFn {
 	return LexExpressionOrIdentity
 }
 
+// LexAlterTable lexes the table name, and the column changes of an
+//  alter table
+//
+//   ALTER TABLE users
+//       ADD email VARCHAR(255) AFTER name,
+//       CHANGE bio biography TEXT,
+//       DROP COLUMN ct
+//
+func LexAlterTable(l *Lexer) StateFn {
+	l.entryStateFn = LexDdlColumn
+	l.Push("LexDdlColumn", LexDdlColumn)
+	return LexIdentifier
+}
+
+// LexTableDefinition lexes the table name, and parenthesized column
+//  definitions of a create table
+//
+//   CREATE TABLE users (
+//       user_id BIGINT NOT NULL,
+//       name VARCHAR(255) DEFAULT "anon",
+//       bio TEXT,
+//       PRIMARY KEY (user_id)
+//   )
+//
+func LexTableDefinition(l *Lexer) StateFn {
+
+	l.SkipWhiteSpaces()
+	if l.Peek() == '(' {
+		l.Next()
+		l.Emit(TokenLeftParenthesis)
+		l.entryStateFn = LexDdlColumn
+		return LexDdlColumn
+	}
+	// table name
+	l.Push("LexTableDefinition", LexTableDefinition)
+	return LexIdentifier
+}
+
+// LexTableReferences lexes the tables of a from, with their aliases,
+//  and the joins between them, where the ON expression of each join ends
+//  at the next join
+//
+//   FROM users AS u
+//       INNER JOIN orders o ON u.user_id = o.user_id
+//       LEFT OUTER JOIN refunds r ON o.order_id = r.order_id
+//       CROSS JOIN countries
+//
+func LexTableReferences(l *Lexer) StateFn {
+
+	l.SkipWhiteSpaces()
+	if l.isEnd() {
+		return nil
+	}
+	switch l.Peek() {
+	case ';', '/', '-', '#':
+		// end of statement, or comments which LexStatement consumes
+		return nil
+	case '(':
+		// derived table, from (select ...) AS t
+		l.Next()
+		if !l.isSubQuery() {
+			return l.errorToken("expected table or (SELECT ...)")
+		}
+		return l.lexSubQuery(LexTableReferences)
+	}
+
+	word := strings.ToLower(l.PeekWord())
+	if tok, ok := joinKeywords[word]; ok {
+		l.ConsumeWord(word)
+		l.Emit(tok)
+		return LexTableReferences
+	}
+	switch word {
+	case "as":
+		l.ConsumeWord(word)
+		l.Emit(TokenAs)
+		return LexTableReferences
+	case "on":
+		l.ConsumeWord(word)
+		l.Emit(TokenOn)
+		l.entryStateFn = LexColumns
+		l.Push("LexTableReferences", LexTableReferences)
+		return LexColumns
+	}
+	if l.isNextKeyword(word) {
+		return nil
+	}
+	// table name, or alias
+	l.Push("LexTableReferences", LexTableReferences)
+	return LexExpressionOrIdentity
+}
+
+// is the ( just consumed the start of a subquery?
+func (l *Lexer) isSubQuery() bool {
+	return strings.ToLower(l.PeekWord()) == "select"
+}
+
+// lexSubQuery lexes the select of a subquery, whose opening paren has just
+//  been consumed, with a lexer of its own up to the matching closing paren,
+//  as the clauses of the select are not those of the enclosing statement,
+//  then continues with next
+//
+//   WHERE user_id IN (SELECT user_id FROM orders WHERE total > 10)
+//   FROM (SELECT user_id, count(*) AS ct FROM orders GROUP BY user_id) AS t
+//
+func (l *Lexer) lexSubQuery(next StateFn) StateFn {
+	l.Emit(TokenLeftParenthesis)
+	end := l.closingParen()
+	if end < 0 {
+		return l.errorToken("subquery was not closed with )")
+	}
+	sub := NewLexer(l.input[l.pos:end], l.dialect)
+	for _, tok := range sub.Tokens() {
+		tok.Pos += l.pos
+		switch tok.T {
+		case TokenEOF:
+		case TokenError:
+			return l.syntaxError(tok, sub.err.Msg, sub.err.Expected)
+		default:
+			l.lastToken = tok
+			l.tokens = append(l.tokens, tok)
+		}
+	}
+	l.pos = end
+	l.ignore()
+	l.Next()
+	l.Emit(TokenRightParenthesis)
+	return next
+}
+
+// the position of the ) which closes a ( already consumed, skipping
+// over quoted values and identities, or -1 if it is not closed
+func (l *Lexer) closingParen() int {
+	depth := 1
+	var quote rune
+	identity := false
+	for i := l.pos; i < len(l.input); {
+		r, w := utf8.DecodeRuneInString(l.input[i:])
+		switch {
+		case quote != 0 && !identity && r == '\\' && l.escaping == EscapeBackslash:
+			// skip the escaped rune
+			_, ew := utf8.DecodeRuneInString(l.input[i+w:])
+			i += ew
+		case quote != 0:
+			if r == quote {
+				// a doubled quote mark re-opens the quote
+				quote = 0
+			}
+		case r == '\'' || r == '"' || l.isIdentityQuote(r):
+			quote, identity = r, l.isIdentityQuote(r)
+			if r == '[' {
+				quote = ']'
+			}
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+		i += w
+	}
+	return -1
+}
+
 // LexComment looks for valid comments which are any of the following
 //   including the in-line comment blocks
 //
@@ -1736,22 +2322,27 @@ func LexTableColumns(l *Lexer) StateFn {
 // LexNumber floats, integers, hex, exponential, signed
 //
 //  1.23
+//  .5
 //  100
 //  -827
-//  6.02e23
-//  0X1A2B,  0x1a2b, 0x1A2B.2B
+//  1_000_000
+//  6.02e23,  1.5E-3
+//  0X1A2B,  0x1a2b
+//  0b1010
 //
 // Floats must be in decimal and must either:
 //
-//     - Have digits both before and after the decimal point (both can be
-//       a single 0), e.g. 0.5, -100.0, or
-//     - Have a lower-case e that represents scientific notation,
-//       e.g. -3e-3, 6.02e23.
+//     - Have digits after the decimal point, e.g. 0.5, .5, -100.0, or
+//     - Have an e or E that represents scientific notation,
+//       e.g. -3e-3, 6.02e23, 1.5E-3.
 //
 // Integers can be:
 //
-//     - decimal (e.g. -827)
-//     - hexadecimal (must begin with 0x and must use capital A-F, e.g. 0x1A2B)
+//     - decimal (e.g. -827), which may not have leading zeros
+//     - hexadecimal (must begin with 0x, e.g. 0x1A2B, 0x1f)
+//     - binary (must begin with 0b, e.g. 0b1010)
+//
+// Digits may be separated by a single underscore, e.g. 1_000_000
 //
 func LexNumber(l *Lexer) StateFn {
 	l.SkipWhiteSpaces()
@@ -1768,24 +2359,29 @@ func LexNumber(l *Lexer) StateFn {
 // LexNumberOrDuration floats, integers, hex, exponential, signed
 //
 //  1.23
+//  .5
 //  100
 //  -827
-//  6.02e23
-//  0X1A2B,  0x1a2b, 0x1A2B.2B
+//  1_000_000
+//  6.02e23,  1.5E-3
+//  0X1A2B,  0x1a2b
+//  0b1010
 //
 // durations:   45m, 2w, 20y, 22d, 40ms, 100ms, -100ms
 //
 // Floats must be in decimal and must either:
 //
-//     - Have digits both before and after the decimal point (both can be
-//       a single 0), e.g. 0.5, -100.0, or
-//     - Have a lower-case e that represents scientific notation,
-//       e.g. -3e-3, 6.02e23.
+//     - Have digits after the decimal point, e.g. 0.5, .5, -100.0, or
+//     - Have an e or E that represents scientific notation,
+//       e.g. -3e-3, 6.02e23, 1.5E-3.
 //
 // Integers can be:
 //
-//     - decimal (e.g. -827)
-//     - hexadecimal (must begin with 0x and must use capital A-F, e.g. 0x1A2B)
+//     - decimal (e.g. -827), which may not have leading zeros
+//     - hexadecimal (must begin with 0x, e.g. 0x1A2B, 0x1f)
+//     - binary (must begin with 0b, e.g. 0b1010)
+//
+// Digits may be separated by a single underscore, e.g. 1_000_000
 //
 func LexNumberOrDuration(l *Lexer) StateFn {
 	l.SkipWhiteSpaces()
@@ -1830,16 +2426,20 @@ func scanNumericOrDuration(l *Lexer, doDuration bool) (typ TokenType, ok bool) {
 	typ = TokenInteger
 	// Optional leading sign.
 	hasSign := l.accept("+-")
-	peek2 := l.peekX(2)
+	peek2 := strings.ToLower(l.peekX(2))
 	//u.Debugf("scanNumericOrDuration?  '%v'", string(peek2))
-	if peek2 == "0x" {
-		// Hexadecimal.
+	if peek2 == "0x" || peek2 == "0b" {
+		// Hexadecimal or Binary.
 		if hasSign {
 			// No signs for hexadecimals.
 			return
 		}
-		l.acceptRun("0x")
-		if !l.acceptRun(hexDigits) {
+		l.skipX(2)
+		digits := hexDigits
+		if peek2 == "0b" {
+			digits = binDigits
+		}
+		if !l.acceptDigits(digits) {
 			// Requires at least one digit.
 			return
 		}
@@ -1849,30 +2449,26 @@ func scanNumericOrDuration(l *Lexer, doDuration bool) (typ TokenType, ok bool) {
 		}
 	} else {
 		// Decimal
-		if !l.acceptRun(decDigits) {
-			// Requires at least one digit
-			return
-		}
+		digitStart := l.pos
+		hasDigits := l.acceptDigits(decDigits)
+		intDigits := l.input[digitStart:l.pos]
 		if l.accept(".") {
 			// Float
-			if !l.acceptRun(decDigits) {
+			if !l.acceptDigits(decDigits) {
 				// Requires a digit after the dot.
 				return
 			}
 			typ = TokenFloat
-		} else {
-			if (!hasSign && l.input[l.start] == '0') ||
-				(hasSign && l.input[l.start+1] == '0') {
-				if peek2 == "0 " || peek2 == "0," {
-					return typ, true
-				}
-				// Integers can't start with 0.
-				return
-			}
+		} else if !hasDigits {
+			// Requires at least one digit
+			return
+		} else if len(intDigits) > 1 && intDigits[0] == '0' {
+			// Integers can't start with 0.
+			return
 		}
-		if l.accept("e") {
+		if l.accept("eE") {
 			l.accept("+-")
-			if !l.acceptRun(decDigits) {
+			if !l.acceptDigits(decDigits) {
 				// A digit is required after the scientific notation.
 				return
 			}
@@ -1936,40 +2532,33 @@ func isIdentCh(r rune) bool {
 	return false
 }
 
-func isIdentifierRune(r rune) bool {
+func (l *Lexer) isIdentifierRune(r rune) bool {
 	if unicode.IsLetter(r) || unicode.IsDigit(r) {
 		return true
 	}
-	for _, allowedRune := range IDENTITY_CHARS {
-		if allowedRune == r {
-			return true
-		}
-	}
-	return false
+	return strings.ContainsRune(l.identChars, r)
 }
 
-func isIdentifierFirstRune(r rune) bool {
+func (l *Lexer) isIdentifierFirstRune(r rune) bool {
 	if r == '\'' {
 		return false
 	} else if isDigit(r) {
 		return false
 	} else if isAlpha(r) {
 		return true
-	} else if r == '@' {
-		// are we really going to support this globaly as identity?
-		return true
 	}
-	return false
+	// ie, @ for sql @@variables
+	return strings.ContainsRune(l.identFirstChars, r)
+}
+
+// is this rune an opening quote mark of a quoted identity in this dialect?
+func (l *Lexer) isIdentityQuote(r rune) bool {
+	return strings.ContainsRune(l.identQuotes, r)
 }
 
-func isLaxIdentifierRune(r rune) bool {
+func (l *Lexer) isLaxIdentifierRune(r rune) bool {
 	if unicode.IsLetter(r) || unicode.IsDigit(r) {
 		return true
 	}
-	for _, allowedRune := range IDENTITY_LAX_CHARS {
-		if allowedRune == r {
-			return true
-		}
-	}
-	return false
+	return strings.ContainsRune(l.identLaxChars, r)
 }
@@ -51,22 +51,58 @@ func TestLexValue(t *testing.T) {
 	tok := token(`"hello's with quote"`, LexValue)
 	assert.T(t, tok.T == TokenValue && tok.V == "hello's with quote")
 
+	// escapes are decoded by the lexer
 	rawValue := `hello\"s with quote`
 	quotedValue := fmt.Sprintf(`"%s"`, rawValue)
 	tok = token(quotedValue, LexValue)
-	assert.Tf(t, tok.T == TokenValue && strings.EqualFold(rawValue, tok.V), "%v", tok)
+	assert.Tf(t, tok.T == TokenValue && tok.V == `hello"s with quote`, "%v", tok)
 
 	rawValue = `string with \"double quotes\"`
 	quotedValue = fmt.Sprintf(`"%s"`, rawValue)
 	tok = token(quotedValue, LexValue)
-	assert.Tf(t, tok.T == TokenValue && strings.EqualFold(rawValue, tok.V), "%v", tok)
+	assert.Tf(t, tok.T == TokenValue && tok.V == `string with "double quotes"`, "%v", tok)
 
 	rawValue = `string with \'single quotes\'`
 	quotedValue = fmt.Sprintf(`"%s"`, rawValue)
 	tok = token(quotedValue, LexValue)
-	assert.Tf(t, tok.T == TokenValue && strings.EqualFold(rawValue, tok.V), "%v", tok)
+	assert.Tf(t, tok.T == TokenValue && tok.V == `string with 'single quotes'`, "%v", tok)
 	//u.Debugf("qv: %v rv:%v ", quotedValue, rawValue)
 	//u.Debugf("%v", strings.EqualFold(rawValue, tok.V), tok.V)
+
+	tok = token(`'item''s'`, LexValue)
+	assert.Tf(t, tok.T == TokenValue && tok.V == `item's` && tok.Quote == '\'', "%v", tok)
+	tok = token(`'line\nbreak\ttab\\slash'`, LexValue)
+	assert.Tf(t, tok.V == "line\nbreak\ttab\\slash", "%v", tok)
+	tok = token(`'caf\u00e9'`, LexValue)
+	assert.Tf(t, tok.V == "café", "%v", tok)
+	tok = token(`'100\%'`, LexValue)
+	assert.Tf(t, tok.V == `100\%`, "like escapes are left for LIKE %v", tok)
+	tok = token(`'bad\u00'`, LexValue)
+	assert.Tf(t, tok.T == TokenError, "want error for bad unicode %v", tok)
+	tok = token(`'not delimited`, LexValue)
+	assert.Tf(t, tok.T == TokenError, "want error %v", tok)
+
+	// ansi dialects only escape doubled quotes
+	l := NewLexer(`'c:\temp''s'`, &Dialect{StringEscaping: EscapeAnsi})
+	LexValue(l)
+	tok = l.NextToken()
+	assert.Tf(t, tok.T == TokenValue && tok.V == `c:\temp's`, "%v", tok)
+}
+
+func TestQuoteString(t *testing.T) {
+	for _, val := range []string{`item's`, "line\nbreak", `c:\temp`, `say "hi"`, "café", `100\%`} {
+		for _, quote := range []rune{'\'', '"'} {
+			for _, mode := range []EscapeMode{EscapeBackslash, EscapeAnsi} {
+				quoted := QuoteString(val, quote, mode)
+				l := NewLexer(quoted, &Dialect{StringEscaping: mode})
+				LexValue(l)
+				tok := l.NextToken()
+				assert.Tf(t, tok.T == TokenValue && tok.V == val, "want %q from %s got %v", val, quoted, tok)
+			}
+		}
+	}
+	assert.Equal(t, `'item''s'`, QuoteString(`item's`, '\'', EscapeBackslash))
+	assert.Equal(t, `"a\nb"`, QuoteString("a\nb", '"', EscapeBackslash))
 }
 
 func TestLexRegex(t *testing.T) {
@@ -83,36 +119,52 @@ func TestLexNumber(t *testing.T) {
 		// Decimal
 		"42",
 		"-827",
+		"0",
+		"1_000_000",
 		// Hexadecimal
 		"0x1A2B",
+		"0X1A2B",
+		"0x1a2b",
+		"0xFF_FF",
+		// Binary
+		"0b1010",
+		"0B1010_1010",
 	}
 	invalidIntegers := []string{
 		// Decimal
 		"042",
 		"-0827",
+		"1__000",
+		"1_",
 		// Hexadecimal
 		"-0x1A2B",
-		"0X1A2B",
-		"0x1a2b",
 		"0x1A2B.2B",
+		"0x",
+		"0x1G",
+		// Binary
+		"0b102",
+		"-0b1",
 	}
 	validFloats := []string{
 		"0.5",
+		".5",
+		"-.5",
 		"-100.0",
 		"-3e-3",
 		"6.02e23",
 		"5.1e-9",
+		"-3E-3",
+		"6.02E23",
+		"1.5E-3",
+		"1_000.000_1",
 	}
 	invalidFloats := []string{
-		".5",
-		"-.5",
 		"100.",
 		"-100.",
-		"-3E-3",
-		"6.02E23",
-		"5.1E-9",
 		"-3e",
 		"6.02e",
+		".",
+		"1._5",
 	}
 
 	for _, v := range validIntegers {
@@ -212,6 +264,128 @@ LIMIT 10`)
 	assert.Tf(t, tokens[4].Pos == 27, "want 27 Pos?%v but has %d", tokens[4], tokens[4].Pos)
 }
 
+func TestLexTokensReset(t *testing.T) {
+	l := NewSqlLexer(`SELECT x FROM mytable;`)
+	tokens := l.Tokens()
+	assert.Tf(t, len(tokens) == 6, "want 6 tokens but has %d %v", len(tokens), tokens)
+	assert.Tf(t, tokens[0].T == TokenSelect, "want select %v", tokens[0])
+	assert.Tf(t, tokens[4].T == TokenEOS, "want ; %v", tokens[4])
+	assert.Tf(t, tokens[5].T == TokenEOF, "want EOF %v", tokens[5])
+
+	// once finished lexer continues to return EOF
+	tok := l.NextToken()
+	assert.Tf(t, tok.T == TokenEOF, "want EOF %v", tok)
+
+	// re-use the same lexer for a new statement
+	l.Reset(`DELETE FROM users WHERE id = 12`)
+	verifyLexerTokens(t, l,
+		[]Token{
+			tv(TokenDelete, "DELETE"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenTable, "users"),
+			tv(TokenWhere, "WHERE"),
+			tv(TokenIdentity, "id"),
+			tv(TokenEqual, "="),
+			tv(TokenInteger, "12"),
+			tv(TokenEOF, ""),
+		})
+	l.Reset(`SELECT y FROM other`)
+	tokens = l.Tokens()
+	assert.Tf(t, len(tokens) == 5, "want 5 tokens but has %d %v", len(tokens), tokens)
+	assert.Tf(t, tokens[3].V == "other" && tokens[3].Pos == 14, "want other %v", tokens[3])
+}
+
+func TestLexMultipleStatements(t *testing.T) {
+	verifyTokens(t, `SELECT x FROM mytable; ;
+	-- next statement
+	DELETE FROM users;`,
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "x"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "mytable"),
+			tv(TokenEOS, ";"),
+			tv(TokenEOS, ";"),
+			tv(TokenCommentSingleLine, "--"),
+			tv(TokenComment, " next statement"),
+			tv(TokenDelete, "DELETE"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenTable, "users"),
+			tv(TokenEOS, ";"),
+			tv(TokenEOF, ""),
+		})
+}
+
+func TestLexParams(t *testing.T) {
+	verifyTokens(t, `SELECT x, toint(?) AS y FROM t WHERE id = $1 AND name = :name_x`,
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "x"),
+			tv(TokenComma, ","),
+			tv(TokenUdfExpr, "toint"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenParam, "?"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenAs, "AS"),
+			tv(TokenIdentity, "y"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "t"),
+			tv(TokenWhere, "WHERE"),
+			tv(TokenIdentity, "id"),
+			tv(TokenEqual, "="),
+			tv(TokenParam, "$1"),
+			tv(TokenLogicAnd, "AND"),
+			tv(TokenIdentity, "name"),
+			tv(TokenEqual, "="),
+			tv(TokenParam, ":name_x"),
+			tv(TokenEOF, ""),
+		})
+	verifyTokens(t, `INSERT INTO users (id, name) VALUES (?, ?)`,
+		[]Token{
+			tv(TokenInsert, "INSERT"),
+			tv(TokenInto, "INTO"),
+			tv(TokenTable, "users"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenIdentity, "id"),
+			tv(TokenComma, ","),
+			tv(TokenIdentity, "name"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenValues, "VALUES"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenParam, "?"),
+			tv(TokenComma, ","),
+			tv(TokenParam, "?"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenEOF, ""),
+		})
+}
+
+func TestLexSyntaxError(t *testing.T) {
+	l := NewSqlLexer(`SELECT x FROM mytable
+  LIMIT 10 garbage`)
+	tokens := l.Tokens()
+	last := tokens[len(tokens)-1]
+	assert.Tf(t, last.T == TokenError, "want error token %v", last)
+	err, ok := l.Err().(*SyntaxError)
+	assert.Tf(t, ok, "want *SyntaxError but got %T", l.Err())
+	assert.Tf(t, err.Line == 2 && err.Column == 12, "want line 2 col 12 but got %d:%d", err.Line, err.Column)
+	assert.Tf(t, err.Pos == 33, "want pos 33 but got %d", err.Pos)
+	assert.Tf(t, err.Token.V == "garbage", "want garbage token %v", err.Token)
+	assert.Tf(t, len(err.Expected) == 2 && err.Expected[0] == TokenEOS, "want ;, EOF expected %v", err.Expected)
+	assert.Tf(t, strings.Contains(err.Error(), "line 2 col 12"), "want line/col in msg %v", err)
+
+	l.Reset(`bogus stuff`)
+	l.Tokens()
+	err, ok = l.Err().(*SyntaxError)
+	assert.Tf(t, ok, "want *SyntaxError but got %T", l.Err())
+	assert.Tf(t, err.Line == 1 && err.Column == 1, "want line 1 col 1 but got %d:%d", err.Line, err.Column)
+	assert.Tf(t, len(err.Expected) > 0 && err.Expected[0] == TokenSelect, "want statement keywords %v", err.Expected)
+
+	l.Reset(`SELECT x FROM mytable`)
+	l.Tokens()
+	assert.Tf(t, l.Err() == nil, "want no error after reset %v", l.Err())
+}
+
 func TestLexCommentTypes(t *testing.T) {
 	verifyTokens(t, `--hello
 -- multiple single -- / # line comments w /* more */
@@ -266,7 +440,8 @@ func TestWithDialect(t *testing.T) {
 		{Token: TokenWith, Lexer: LexColumns, Optional: true},
 	}}
 	withDialect := &Dialect{
-		"QL With", []*Statement{withStatement},
+		Name:       "QL With",
+		Statements: []*Statement{withStatement},
 	}
 	withDialect.Init()
 	/* Many *ql languages support some type of columnar layout such as:
@@ -459,6 +634,38 @@ func TestLexGroupBy(t *testing.T) {
 			tv(TokenRightParenthesis, ")"),
 			tv(TokenRightParenthesis, ")"),
 		})
+	verifyTokens(t, `SELECT country, count(*) FROM stdio
+	GROUP BY country HAVING count(*) > 1 AND sum(x) < 10 ORDER BY country`,
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "country"),
+			tv(TokenComma, ","),
+			tv(TokenUdfExpr, "count"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenStar, "*"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "stdio"),
+			tv(TokenGroupBy, "GROUP BY"),
+			tv(TokenIdentity, "country"),
+			tv(TokenHaving, "HAVING"),
+			tv(TokenUdfExpr, "count"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenStar, "*"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenGT, ">"),
+			tv(TokenInteger, "1"),
+			tv(TokenLogicAnd, "AND"),
+			tv(TokenUdfExpr, "sum"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenIdentity, "x"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenLT, "<"),
+			tv(TokenInteger, "10"),
+			tv(TokenOrderBy, "ORDER BY"),
+			tv(TokenIdentity, "country"),
+			tv(TokenEOF, ""),
+		})
 }
 
 func TestLexFrom(t *testing.T) {
@@ -478,6 +685,71 @@ func TestLexFrom(t *testing.T) {
 		})
 }
 
+func TestLexQuotedIdentity(t *testing.T) {
+	verifyTokens(t, "SELECT `order date`, [user-id], `from` FROM `my``table` WHERE `user-id` = 5",
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "order date"),
+			tv(TokenComma, ","),
+			tv(TokenIdentity, "user-id"),
+			tv(TokenComma, ","),
+			tv(TokenIdentity, "from"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "my`table"),
+			tv(TokenWhere, "WHERE"),
+			tv(TokenIdentity, "user-id"),
+			tv(TokenEqual, "="),
+			tv(TokenInteger, "5"),
+		})
+	verifyTokens(t, "SELECT LOWER([first name]) AS `lower name` FROM users",
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenUdfExpr, "LOWER"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenIdentity, "first name"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenAs, "AS"),
+			tv(TokenIdentity, "lower name"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "users"),
+		})
+
+	tokens := NewSqlLexer("SELECT `order date` FROM users").Tokens()
+	assert.Tf(t, tokens[1].Quote == '`', "want quote mark %v", tokens[1])
+
+	// double quotes are string values in sql dialect, but identities in ansi
+	verifyTokens(t, `SELECT x FROM users WHERE "user-id" = 5`,
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "x"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "users"),
+			tv(TokenWhere, "WHERE"),
+			tv(TokenValue, "user-id"),
+		})
+	ansiDialect := &Dialect{
+		Name:            "ansi",
+		Statements:      []*Statement{&Statement{TokenSelect, SqlSelect}},
+		IdentityQuoting: `"`,
+	}
+	ansiDialect.Init()
+	verifyLexerTokens(t, NewLexer(`SELECT "order date" FROM users WHERE "user""id" = 'a'`, ansiDialect),
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "order date"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "users"),
+			tv(TokenWhere, "WHERE"),
+			tv(TokenIdentity, `user"id`),
+			tv(TokenEqual, "="),
+			tv(TokenValue, "a"),
+		})
+
+	l := NewSqlLexer("SELECT `order date FROM users")
+	tokens = l.Tokens()
+	assert.Tf(t, tokens[len(tokens)-1].T == TokenError, "want error for un-terminated %v", tokens)
+}
+
 func TestLexOrderBy(t *testing.T) {
 	verifyTokens(t, `
 	SELECT product_id, name, category
@@ -503,6 +775,32 @@ func TestLexOrderBy(t *testing.T) {
 			tv(TokenIdentity, "otherstuff"),
 			tv(TokenEOS, ";"),
 		})
+	verifyTokens(t, `SELECT name FROM product
+	ORDER BY toint(ct) * 2 DESC NULLS LAST, name ASC, status nulls first LIMIT 10`,
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "name"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "product"),
+			tv(TokenOrderBy, "ORDER BY"),
+			tv(TokenUdfExpr, "toint"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenIdentity, "ct"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenMultiply, "*"),
+			tv(TokenInteger, "2"),
+			tv(TokenDesc, "DESC"),
+			tv(TokenNullsLast, "NULLS LAST"),
+			tv(TokenComma, ","),
+			tv(TokenIdentity, "name"),
+			tv(TokenAsc, "ASC"),
+			tv(TokenComma, ","),
+			tv(TokenIdentity, "status"),
+			tv(TokenNullsFirst, "nulls first"),
+			tv(TokenLimit, "LIMIT"),
+			tv(TokenInteger, "10"),
+			tv(TokenEOF, ""),
+		})
 }
 
 func TestLexTSQL(t *testing.T) {
@@ -631,6 +929,32 @@ func TestLexSelectIfGuard(t *testing.T) {
 			tv(TokenFrom, "FROM"),
 			tv(TokenIdentity, "Product"),
 		})
+
+	// exists(field) is the func, not EXISTS (SELECT ...)
+	verifyTokens(t, `SELECT email AS e IF exists(email), score * 2 AS s IF score > 0 FROM users`,
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "email"),
+			tv(TokenAs, "AS"),
+			tv(TokenIdentity, "e"),
+			tv(TokenIf, "IF"),
+			tv(TokenUdfExpr, "exists"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenIdentity, "email"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenComma, ","),
+			tv(TokenIdentity, "score"),
+			tv(TokenMultiply, "*"),
+			tv(TokenInteger, "2"),
+			tv(TokenAs, "AS"),
+			tv(TokenIdentity, "s"),
+			tv(TokenIf, "IF"),
+			tv(TokenIdentity, "score"),
+			tv(TokenGT, ">"),
+			tv(TokenInteger, "0"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "users"),
+		})
 }
 
 func TestLexSelectLogicalColumns(t *testing.T) {
@@ -658,6 +982,24 @@ func TestLexSelectLogicalColumns(t *testing.T) {
 			tv(TokenFrom, "FROM"),
 			tv(TokenIdentity, "Product"),
 		})
+
+	verifyTokens(t, `SELECT first || last FROM Product WHERE a = 1 || b = 2`,
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "first"),
+			tv(TokenOr, "||"),
+			tv(TokenIdentity, "last"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "Product"),
+			tv(TokenWhere, "WHERE"),
+			tv(TokenIdentity, "a"),
+			tv(TokenEqual, "="),
+			tv(TokenInteger, "1"),
+			tv(TokenOr, "||"),
+			tv(TokenIdentity, "b"),
+			tv(TokenEqual, "="),
+			tv(TokenInteger, "2"),
+		})
 }
 
 func TestLexSelectNestedExpressions(t *testing.T) {
@@ -772,6 +1114,64 @@ func TestLexAlter(t *testing.T) {
 			tv(TokenIdentity, "utf8"),
 			tv(TokenEOS, ";"),
 		})
+
+	verifyTokens(t, `ALTER TABLE users ADD email TEXT NOT NULL DEFAULT "none" FIRST, DROP COLUMN bio`,
+		[]Token{
+			tv(TokenAlter, "ALTER"),
+			tv(TokenTable, "TABLE"),
+			tv(TokenIdentity, "users"),
+			tv(TokenAdd, "ADD"),
+			tv(TokenIdentity, "email"),
+			tv(TokenText, "TEXT"),
+			tv(TokenNotNull, "NOT NULL"),
+			tv(TokenDefault, "DEFAULT"),
+			tv(TokenValue, "none"),
+			tv(TokenFirst, "FIRST"),
+			tv(TokenComma, ","),
+			tv(TokenDrop, "DROP"),
+			tv(TokenColumn, "COLUMN"),
+			tv(TokenIdentity, "bio"),
+		})
+}
+
+func TestLexCreate(t *testing.T) {
+
+	verifyTokens(t, `CREATE TABLE users (
+			user_id BIGINT NOT NULL,
+			name VARCHAR(255) DEFAULT "anon",
+			PRIMARY KEY (user_id)
+		);`,
+		[]Token{
+			tv(TokenCreate, "CREATE"),
+			tv(TokenTable, "TABLE"),
+			tv(TokenIdentity, "users"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenIdentity, "user_id"),
+			tv(TokenBigInt, "BIGINT"),
+			tv(TokenNotNull, "NOT NULL"),
+			tv(TokenComma, ","),
+			tv(TokenIdentity, "name"),
+			tv(TokenVarChar, "VARCHAR"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenInteger, "255"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenDefault, "DEFAULT"),
+			tv(TokenValue, "anon"),
+			tv(TokenComma, ","),
+			tv(TokenPrimaryKey, "PRIMARY KEY"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenIdentity, "user_id"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenEOS, ";"),
+		})
+
+	verifyTokens(t, "DROP TABLE `users`",
+		[]Token{
+			tv(TokenDrop, "DROP"),
+			tv(TokenTable, "TABLE"),
+			tv(TokenIdentity, "users"),
+		})
 }
 
 func TestLexUpdate(t *testing.T) {
@@ -854,6 +1254,28 @@ func TestLexInsert(t *testing.T) {
 			tv(TokenRightParenthesis, ")"),
 		})
 
+	verifyTokens(t, `insert into archive (id, str) select id, str from mytable where id > 5`,
+		[]Token{
+			tv(TokenInsert, "insert"),
+			tv(TokenInto, "into"),
+			tv(TokenTable, "archive"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenIdentity, "id"),
+			tv(TokenComma, ","),
+			tv(TokenIdentity, "str"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenSelect, "select"),
+			tv(TokenIdentity, "id"),
+			tv(TokenComma, ","),
+			tv(TokenIdentity, "str"),
+			tv(TokenFrom, "from"),
+			tv(TokenIdentity, "mytable"),
+			tv(TokenWhere, "where"),
+			tv(TokenIdentity, "id"),
+			tv(TokenGT, ">"),
+			tv(TokenInteger, "5"),
+		})
+
 	verifyTokens(t, `-- lets insert stuff
 		INSERT INTO users SET name = 'bob', email = 'bob@email.com'`,
 		[]Token{
@@ -905,6 +1327,51 @@ func TestLexInsert(t *testing.T) {
 			tv(TokenRightParenthesis, ")"),
 			tv(TokenEOS, ";"),
 		})
+
+	verifyTokens(t, `INSERT INTO logs (site_id, hits) VALUES (1, 15)
+		ON DUPLICATE KEY UPDATE hits = hits + 15`,
+		[]Token{
+			tv(TokenInsert, "INSERT"),
+			tv(TokenInto, "INTO"),
+			tv(TokenTable, "logs"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenIdentity, "site_id"),
+			tv(TokenComma, ","),
+			tv(TokenIdentity, "hits"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenValues, "VALUES"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenInteger, "1"),
+			tv(TokenComma, ","),
+			tv(TokenInteger, "15"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenDuplicateKeyUpdate, "ON DUPLICATE KEY UPDATE"),
+			tv(TokenIdentity, "hits"),
+			tv(TokenEqual, "="),
+			tv(TokenIdentity, "hits"),
+			tv(TokenPlus, "+"),
+			tv(TokenInteger, "15"),
+			tv(TokenEOF, ""),
+		})
+
+	verifyTokens(t, `upsert into logs (site_id, hits) values (1, 15)`,
+		[]Token{
+			tv(TokenUpsert, "upsert"),
+			tv(TokenInto, "into"),
+			tv(TokenTable, "logs"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenIdentity, "site_id"),
+			tv(TokenComma, ","),
+			tv(TokenIdentity, "hits"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenValues, "values"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenInteger, "1"),
+			tv(TokenComma, ","),
+			tv(TokenInteger, "15"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenEOF, ""),
+		})
 }
 
 func TestLexDelete(t *testing.T) {
@@ -956,4 +1423,215 @@ func TestLexShow(t *testing.T) {
 			tv(TokenShow, "SHOW"),
 			tv(TokenIdentity, "mytable"),
 		})
+	verifyTokens(t, `SHOW COLUMNS FROM mytable;`,
+		[]Token{
+			tv(TokenShow, "SHOW"),
+			tv(TokenIdentity, "COLUMNS"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "mytable"),
+		})
+}
+
+func TestLexJoin(t *testing.T) {
+	verifyTokens(t, `SELECT u.name, o.total FROM users u
+		LEFT OUTER JOIN orders AS o ON u.id = o.user_id AND left(o.sku, 2) = "ab"
+		CROSS JOIN countries WHERE o.total > 10 AND u.id < 5`,
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "u.name"),
+			tv(TokenComma, ","),
+			tv(TokenIdentity, "o.total"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "users"),
+			tv(TokenIdentity, "u"),
+			tv(TokenLeft, "LEFT"),
+			tv(TokenOuter, "OUTER"),
+			tv(TokenJoin, "JOIN"),
+			tv(TokenIdentity, "orders"),
+			tv(TokenAs, "AS"),
+			tv(TokenIdentity, "o"),
+			tv(TokenOn, "ON"),
+			tv(TokenIdentity, "u.id"),
+			tv(TokenEqual, "="),
+			tv(TokenIdentity, "o.user_id"),
+			tv(TokenLogicAnd, "AND"),
+			tv(TokenUdfExpr, "left"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenIdentity, "o.sku"),
+			tv(TokenComma, ","),
+			tv(TokenInteger, "2"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenEqual, "="),
+			tv(TokenValue, "ab"),
+			tv(TokenCross, "CROSS"),
+			tv(TokenJoin, "JOIN"),
+			tv(TokenIdentity, "countries"),
+			tv(TokenWhere, "WHERE"),
+			tv(TokenIdentity, "o.total"),
+			tv(TokenGT, ">"),
+			tv(TokenInteger, "10"),
+			tv(TokenLogicAnd, "AND"),
+			tv(TokenIdentity, "u.id"),
+			tv(TokenLT, "<"),
+			tv(TokenInteger, "5"),
+		})
+}
+
+func TestLexSubQuery(t *testing.T) {
+	// a backslash only escapes in quoted strings, not identities
+	verifyTokens(t, "SELECT t.a FROM (SELECT `x\\` AS a FROM users) AS t",
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "t.a"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "x\\"),
+			tv(TokenAs, "AS"),
+			tv(TokenIdentity, "a"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "users"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenAs, "AS"),
+			tv(TokenIdentity, "t"),
+		})
+	verifyTokens(t, `SELECT t.name FROM (SELECT name FROM users) AS t
+		WHERE t.name IN (SELECT name FROM orders WHERE total > 5) AND EXISTS (SELECT id FROM days)`,
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "t.name"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "name"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "users"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenAs, "AS"),
+			tv(TokenIdentity, "t"),
+			tv(TokenWhere, "WHERE"),
+			tv(TokenIdentity, "t.name"),
+			tv(TokenIN, "IN"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "name"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "orders"),
+			tv(TokenWhere, "WHERE"),
+			tv(TokenIdentity, "total"),
+			tv(TokenGT, ">"),
+			tv(TokenInteger, "5"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenLogicAnd, "AND"),
+			tv(TokenExists, "EXISTS"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "id"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "days"),
+			tv(TokenRightParenthesis, ")"),
+		})
+	verifyTokens(t, `SELECT name, (SELECT max(total) FROM orders) AS top FROM users`,
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "name"),
+			tv(TokenComma, ","),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenSelect, "SELECT"),
+			tv(TokenUdfExpr, "max"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenIdentity, "total"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "orders"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenAs, "AS"),
+			tv(TokenIdentity, "top"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "users"),
+		})
+	// without a select, exists is the exists(field) func
+	verifyTokens(t, `SELECT exists(email) AS has FROM users WHERE exists(email)`,
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenUdfExpr, "exists"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenIdentity, "email"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenAs, "AS"),
+			tv(TokenIdentity, "has"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "users"),
+			tv(TokenWhere, "WHERE"),
+			tv(TokenUdfExpr, "exists"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenIdentity, "email"),
+			tv(TokenRightParenthesis, ")"),
+		})
+}
+
+func TestLexPredicates(t *testing.T) {
+	verifyTokens(t, `SELECT a FROM t WHERE a NOT IN (1, 2) AND b BETWEEN 1 AND 5
+		AND c IS NOT NULL AND name LIKE "a!%" ESCAPE "!"`,
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenIdentity, "a"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "t"),
+			tv(TokenWhere, "WHERE"),
+			tv(TokenIdentity, "a"),
+			tv(TokenNegate, "NOT"),
+			tv(TokenIN, "IN"),
+			tv(TokenLeftParenthesis, "("),
+			tv(TokenInteger, "1"),
+			tv(TokenComma, ","),
+			tv(TokenInteger, "2"),
+			tv(TokenRightParenthesis, ")"),
+			tv(TokenLogicAnd, "AND"),
+			tv(TokenIdentity, "b"),
+			tv(TokenBetween, "BETWEEN"),
+			tv(TokenInteger, "1"),
+			tv(TokenLogicAnd, "AND"),
+			tv(TokenInteger, "5"),
+			tv(TokenLogicAnd, "AND"),
+			tv(TokenIdentity, "c"),
+			tv(TokenIs, "IS"),
+			tv(TokenNegate, "NOT"),
+			tv(TokenNull, "NULL"),
+			tv(TokenLogicAnd, "AND"),
+			tv(TokenIdentity, "name"),
+			tv(TokenLike, "LIKE"),
+			tv(TokenValue, "a!%"),
+			tv(TokenEscape, "ESCAPE"),
+			tv(TokenValue, "!"),
+		})
+}
+
+func TestLexCase(t *testing.T) {
+	verifyTokens(t, `SELECT CASE WHEN ct > 5 THEN "many" ELSE "few" END AS size FROM t ORDER BY CASE kind WHEN "a" THEN 1 END`,
+		[]Token{
+			tv(TokenSelect, "SELECT"),
+			tv(TokenCase, "CASE"),
+			tv(TokenWhen, "WHEN"),
+			tv(TokenIdentity, "ct"),
+			tv(TokenGT, ">"),
+			tv(TokenInteger, "5"),
+			tv(TokenThen, "THEN"),
+			tv(TokenValue, "many"),
+			tv(TokenElse, "ELSE"),
+			tv(TokenValue, "few"),
+			tv(TokenEnd, "END"),
+			tv(TokenAs, "AS"),
+			tv(TokenIdentity, "size"),
+			tv(TokenFrom, "FROM"),
+			tv(TokenIdentity, "t"),
+			tv(TokenOrderBy, "ORDER BY"),
+			tv(TokenCase, "CASE"),
+			tv(TokenIdentity, "kind"),
+			tv(TokenWhen, "WHEN"),
+			tv(TokenValue, "a"),
+			tv(TokenThen, "THEN"),
+			tv(TokenInteger, "1"),
+			tv(TokenEnd, "END"),
+		})
 }
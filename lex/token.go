@@ -23,9 +23,10 @@ type TokenInfo struct {
 
 // token represents a text string returned from the lexer.
 type Token struct {
-	T   TokenType // type
-	V   string    // value
-	Pos int       // original byte value location
+	T     TokenType // type
+	V     string    // value
+	Pos   int       // original byte value location
+	Quote byte      // quote mark if value was quoted, ie ` " [
 }
 
 // convert to human readable string
@@ -161,11 +162,51 @@ const (
 	// Value Types
 	TokenIdentity             // identity, either column, table name etc
 	TokenValue                // 'some string' string or continous sequence of chars delimited by WHITE SPACE | ' | , | ( | )
-	TokenValueWithSingleQuote // '' becomes ' inside the string, parser will need to replace the string
+	TokenValueWithSingleQuote // deprecated, no longer emitted: lexer decodes '' to ' in TokenValue
 	TokenRegex                // regex
 	TokenDuration             // 14d , 22w, 3y, 45ms, 45us, 24hr, 2h, 45m, 30s
+	TokenParam                // bind parameter placeholder:   ?  $1  :name
 	//TokenKey                  // key
 	//TokenTag                  // tag
+
+	// New tokens are added at the end, so the values of existing ones don't change
+
+	// order by
+	TokenNullsFirst // nulls first
+	TokenNullsLast  // nulls last
+
+	// insert, upsert
+	TokenDuplicateKeyUpdate // on duplicate key update
+
+	// ddl
+	TokenDrop       // drop
+	TokenPrimaryKey // primary key
+	TokenNotNull    // not null
+	TokenDefault    // default
+	TokenColumn     // column
+
+	// select joins
+	TokenJoin  // join
+	TokenInner // inner
+	TokenLeft  // left
+	TokenOuter // outer
+	TokenCross // cross
+	TokenOn    // on
+
+	// subqueries
+	TokenExists // EXISTS
+
+	// predicates
+	TokenIs     // IS
+	TokenNull   // NULL
+	TokenEscape // ESCAPE
+
+	// case expressions
+	TokenCase // CASE
+	TokenWhen // WHEN
+	TokenThen // THEN
+	TokenElse // ELSE
+	TokenEnd  // END
 )
 
 var (
@@ -177,6 +218,13 @@ var (
 	IDENTITY_LAX_CHARS = "_./ "
 	// sql variables start with @@ ??
 	IDENTITY_SQL_CHARS = "@_./"
+	// Which non-letter characters may start an Identity?
+	IDENTITY_FIRST_CHARS = "@"
+	// Which opening quote marks quote an Identity?  `mysql`, [tsql]
+	IDENTITY_QUOTE_CHARS = "`["
+
+	// lower-case keyword to base token, used to detect dialect token collisions
+	baseKeywords = make(map[string]TokenType)
 
 	// list of token-name
 	TokenNameMap = map[TokenType]*TokenInfo{
@@ -232,6 +280,15 @@ var (
 		TokenLogicAnd:   {Kw: "and", Description: "And"},
 		TokenIN:         {Kw: "in", Description: "IN"},
 		TokenLike:       {Kw: "like", Description: "LIKE"},
+		TokenExists:     {Kw: "exists", Description: "EXISTS"},
+		TokenIs:         {Kw: "is", Description: "IS"},
+		TokenNull:       {Kw: "null", Description: "NULL"},
+		TokenEscape:     {Kw: "escape", Description: "ESCAPE"},
+		TokenCase:       {Kw: "case", Description: "CASE"},
+		TokenWhen:       {Kw: "when", Description: "WHEN"},
+		TokenThen:       {Kw: "then", Description: "THEN"},
+		TokenElse:       {Kw: "else", Description: "ELSE"},
+		TokenEnd:        {Kw: "end", Description: "END"},
 		TokenNegate:     {Kw: "not", Description: "NOT"},
 		TokenBetween:    {Kw: "between", Description: "between"},
 
@@ -254,6 +311,7 @@ var (
 		TokenUpsert:    {Description: "upsert"},
 		TokenAlter:     {Description: "alter"},
 		TokenCreate:    {Description: "create"},
+		TokenDrop:      {Description: "drop"},
 		TokenSubscribe: {Description: "subscribe"},
 		TokenFilter:    {Description: "filter"},
 		TokenDescribe:  {Description: "describe"},
@@ -265,6 +323,7 @@ var (
 		TokenValueWithSingleQuote: {Description: "valueWithSingleQuote"},
 		TokenRegex:                {Description: "regex"},
 		TokenDuration:             {Description: "duration"},
+		TokenParam:                {Description: "param"},
 
 		// Top Level ql clause keywords
 		TokenTable:   {Description: "table"},
@@ -280,18 +339,35 @@ var (
 		TokenLimit:   {Description: "limit"},
 		TokenOrderBy: {Description: "order by"},
 
+		// insert, upsert
+		TokenDuplicateKeyUpdate: {Description: "on duplicate key update"},
+
+		// select joins
+		TokenJoin:  {Description: "join"},
+		TokenInner: {Description: "inner"},
+		TokenLeft:  {Description: "left"},
+		TokenOuter: {Description: "outer"},
+		TokenCross: {Description: "cross"},
+		TokenOn:    {Description: "on"},
+
 		// ddl keywords
 		TokenChange:       {Description: "change"},
 		TokenCharacterSet: {Description: "character set"},
 		TokenAdd:          {Description: "add"},
 		TokenFirst:        {Description: "first"},
 		TokenAfter:        {Description: "after"},
+		TokenPrimaryKey:   {Description: "primary key"},
+		TokenNotNull:      {Description: "not null"},
+		TokenDefault:      {Description: "default"},
+		TokenColumn:       {Description: "column"},
 
 		// QL Keywords, all lower-case
-		TokenSet:  {Description: "set"},
-		TokenAs:   {Description: "as"},
-		TokenAsc:  {Description: "asc"},
-		TokenDesc: {Description: "desc"},
+		TokenSet:        {Description: "set"},
+		TokenAs:         {Description: "as"},
+		TokenAsc:        {Description: "asc"},
+		TokenDesc:       {Description: "desc"},
+		TokenNullsFirst: {Description: "nulls first"},
+		TokenNullsLast:  {Description: "nulls last"},
 	}
 )
 
@@ -299,22 +375,41 @@ func init() {
 	LoadTokenInfo()
 }
 
+// LoadTokenInfo initializes the base tokens in TokenNameMap.  Custom dialects
+// should use Dialect.RegisterToken instead of adding to TokenNameMap.
 func LoadTokenInfo() {
 	for tok, ti := range TokenNameMap {
-		ti.T = tok
-		if ti.Kw == "" {
-			ti.Kw = ti.Description
-		}
-		if strings.Contains(ti.Kw, " ") {
-			parts := strings.Split(ti.Kw, " ")
-			ti.firstWord = parts[0]
-			ti.HasSpaces = true
+		ti.load(tok)
+		kw := strings.ToLower(ti.Kw)
+		if _, exists := baseKeywords[kw]; !exists {
+			baseKeywords[kw] = tok
 		}
 	}
 
 	SqlDialect.Init()
 }
 
+func (ti *TokenInfo) load(tok TokenType) {
+	ti.T = tok
+	if ti.Kw == "" {
+		ti.Kw = ti.Description
+	}
+	if strings.Contains(ti.Kw, " ") {
+		parts := strings.Split(ti.Kw, " ")
+		ti.firstWord = parts[0]
+		ti.HasSpaces = true
+	}
+}
+
+// which keyword should we look for, either full keyword
+// OR in case of spaces such as "group by" look for group
+func (ti *TokenInfo) matchString() string {
+	if ti.HasSpaces {
+		return ti.firstWord
+	}
+	return ti.Kw
+}
+
 // convert to human readable string
 func (typ TokenType) String() string {
 	s, ok := TokenNameMap[typ]
@@ -330,14 +425,21 @@ func (typ TokenType) MatchString() string {
 	tokInfo, ok := TokenNameMap[typ]
 	//u.Debugf("matchstring: '%v' '%v'  '%v'", tokInfo.T, tokInfo.Kw, tokInfo.Description)
 	if ok {
-		if tokInfo.HasSpaces {
-			return tokInfo.firstWord
-		}
-		return tokInfo.Kw
+		return tokInfo.matchString()
 	}
 	return "not implemented"
 }
 
+// is this a comment token?
+func (typ TokenType) IsComment() bool {
+	switch typ {
+	case TokenComment, TokenCommentML, TokenCommentStart, TokenCommentEnd,
+		TokenCommentSlashes, TokenCommentSingleLine, TokenCommentHash:
+		return true
+	}
+	return false
+}
+
 // is this a word such as "Group by" with multiple words?
 func (typ TokenType) MultiWord() bool {
 	tokInfo, ok := TokenNameMap[typ]
@@ -0,0 +1,281 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	ql "github.com/araddon/qlbridge/lex"
+)
+
+var (
+	// the aggregate functions, which reduce the values of their argument
+	// over all the rows of a group to a single value
+	//
+	//    select country, count(*), avg(price) from stdio group by country
+	//
+	aggFuncs = map[string]AggFactory{
+		"count": func() Aggregator { return &countAgg{} },
+		"sum":   func() Aggregator { return &sumAgg{} },
+		"avg":   func() Aggregator { return &avgAgg{} },
+		"min":   func() Aggregator { return &minMaxAgg{want: -1} },
+		"max":   func() Aggregator { return &minMaxAgg{want: 1} },
+	}
+	aggArgRequired = map[string]bool{"sum": true, "avg": true, "min": true, "max": true}
+)
+
+// Aggregator accumulates the values of one aggregate function call
+// for a single group of rows.
+//
+//    sum(x)        Accumulate is called with each non-null value of x
+//    sessions()    Accumulate is called once per row with true
+//
+type Aggregator interface {
+	// Init resets to the state of no rows
+	Init()
+	// Accumulate a non-null value of the argument
+	Accumulate(v Value)
+	// Merge another Aggregator of the same func, ie one accumulated
+	// over a different partition of the rows
+	Merge(other Aggregator)
+	// Result is the aggregate value of all rows accumulated, or merged
+	Result() Value
+}
+
+// AggFactory creates a new Aggregator, one per group per call
+type AggFactory func() Aggregator
+
+// AggAdd registers an aggregate function, calls to it are marked by the parser
+// as aggregates and are evaluated per group instead of per row.  The name
+// may not be that of an already registered scalar func or aggregate.
+//
+//    err := vm.AggAdd("unique_users", func() vm.Aggregator { return &UniqueUsers{} })
+//
+//    select country, unique_users(user_id) from stdio group by country
+//
+func AggAdd(name string, factory AggFactory) error {
+	funcMu.Lock()
+	defer funcMu.Unlock()
+	name = strings.ToLower(name)
+	if factory == nil {
+		return fmt.Errorf("expr: aggregate %q has a nil factory", name)
+	}
+	if _, exists := funcs[name]; exists {
+		return fmt.Errorf("expr: aggregate %q is already a registered func", name)
+	}
+	if _, exists := aggFuncs[name]; exists {
+		return fmt.Errorf("expr: aggregate %q is already registered", name)
+	}
+	aggFuncs[name] = factory
+	return nil
+}
+
+// AggsGet returns a copy of the registered aggregate functions, by name
+func AggsGet() map[string]AggFactory {
+	funcMu.RLock()
+	defer funcMu.RUnlock()
+	aggs := make(map[string]AggFactory, len(aggFuncs))
+	for name, factory := range aggFuncs {
+		aggs[name] = factory
+	}
+	return aggs
+}
+
+// is this the name of an aggregate function?
+func isAggregate(name string) bool {
+	funcMu.RLock()
+	_, ok := aggFuncs[strings.ToLower(name)]
+	funcMu.RUnlock()
+	return ok
+}
+
+// the aggregate func calls in this node and its sub-nodes
+func findAggregates(arg Node) []*FuncNode {
+	var aggs []*FuncNode
+	if arg == nil {
+		return nil
+	}
+	Walk(arg, func(n Node) {
+		if fn, ok := n.(*FuncNode); ok && fn.Agg {
+			aggs = append(aggs, fn)
+		}
+	})
+	return aggs
+}
+
+// walkUngroupedAgg evaluates an aggregate outside of a grouped select.  The
+// expression of a Vm is of a single row, so its count(x) is 1 if x is not
+// null, as the deprecated builtins.CountFunc was, any other aggregate is
+// an ErrAggregate.
+func (e *State) walkUngroupedAgg(node *FuncNode) (Value, bool) {
+	if _, isExpr := e.ExprVm.(*Vm); !isExpr || strings.ToLower(node.Name) != "count" || len(node.Args) != 1 {
+		panic(ErrAggregate)
+	}
+	v, ok := e.Walk(node.Args[0])
+	if !ok || isNullValue(v) {
+		return NewIntValue(0), false
+	}
+	return NewIntValue(1), true
+}
+
+// a new aggregator of the named func, min and max compare strings in
+// the collation of the statement, as its ORDER BY does
+func newAggregator(name string, collation ql.Collation) Aggregator {
+	funcMu.RLock()
+	factory := aggFuncs[strings.ToLower(name)]
+	funcMu.RUnlock()
+	agg := factory()
+	if mm, ok := agg.(*minMaxAgg); ok {
+		mm.collation = collation
+	}
+	agg.Init()
+	return agg
+}
+
+// is the value a sql null, or an error, an empty string is not null
+func isNullValue(v Value) bool {
+	return v == nil || v.Type() == NilType || v.Err()
+}
+
+// count(*), count(field) the number of non-null values
+type countAgg struct {
+	n int64
+}
+
+func (m *countAgg) Init()              { m.n = 0 }
+func (m *countAgg) Accumulate(v Value) { m.n++ }
+func (m *countAgg) Result() Value      { return NewIntValue(m.n) }
+func (m *countAgg) Merge(other Aggregator) {
+	if o, ok := other.(*countAgg); ok {
+		m.n += o.n
+	}
+}
+
+// sum(field) stays an int as long as all values are ints, non
+// numeric values are ignored
+type sumAgg struct {
+	n     int64
+	i     int64
+	f     float64
+	float bool
+}
+
+func (m *sumAgg) Init() { *m = sumAgg{} }
+
+func (m *sumAgg) Accumulate(v Value) {
+	if i, ok := intOf(v); ok && !m.float {
+		m.i += i
+		m.n++
+		return
+	}
+	f, ok := compareNumber(v)
+	if !ok {
+		return
+	}
+	m.toFloat()
+	m.f += f
+	m.n++
+}
+
+func (m *sumAgg) toFloat() {
+	if !m.float {
+		m.float = true
+		m.f = float64(m.i)
+	}
+}
+
+func (m *sumAgg) Merge(other Aggregator) {
+	o, ok := other.(*sumAgg)
+	if !ok || o.n == 0 {
+		return
+	}
+	if o.float {
+		m.toFloat()
+		m.f += o.f
+	} else if m.float {
+		m.f += float64(o.i)
+	} else {
+		m.i += o.i
+	}
+	m.n += o.n
+}
+
+func (m *sumAgg) Result() Value {
+	switch {
+	case m.n == 0:
+		return NewNilValue()
+	case m.float:
+		return NewNumberValue(m.f)
+	}
+	return NewIntValue(m.i)
+}
+
+// the value of an int, or integer string
+func intOf(v Value) (int64, bool) {
+	switch vt := v.(type) {
+	case IntValue:
+		return vt.v, true
+	case StringValue:
+		if i, err := strconv.ParseInt(vt.v, 10, 64); err == nil {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// avg(field) is always a number
+type avgAgg struct {
+	n   int64
+	sum float64
+}
+
+func (m *avgAgg) Init() { *m = avgAgg{} }
+
+func (m *avgAgg) Accumulate(v Value) {
+	if f, ok := compareNumber(v); ok {
+		m.sum += f
+		m.n++
+	}
+}
+
+func (m *avgAgg) Merge(other Aggregator) {
+	if o, ok := other.(*avgAgg); ok {
+		m.sum += o.sum
+		m.n += o.n
+	}
+}
+
+func (m *avgAgg) Result() Value {
+	if m.n == 0 {
+		return NewNilValue()
+	}
+	return NewNumberValue(m.sum / float64(m.n))
+}
+
+// min(field), max(field) using the ordering of Compare
+type minMaxAgg struct {
+	want      int // -1 for min, 1 for max
+	collation ql.Collation
+	v         Value
+}
+
+func (m *minMaxAgg) Init() { m.v = nil }
+
+func (m *minMaxAgg) Accumulate(v Value) {
+	if m.v == nil || CompareCollate(v, m.v, m.collation) == m.want {
+		m.v = v
+	}
+}
+
+func (m *minMaxAgg) Merge(other Aggregator) {
+	if o, ok := other.(*minMaxAgg); ok && o.v != nil {
+		m.Accumulate(o.v)
+	}
+}
+
+func (m *minMaxAgg) Result() Value {
+	if m.v == nil {
+		return NewNilValue()
+	}
+	return m.v
+}
@@ -0,0 +1,612 @@
+package vm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	ql "github.com/araddon/qlbridge/lex"
+)
+
+// Catalog is an in-process registry of the schemas of tables, as declared
+//  by CREATE TABLE, so that statements may be validated against the columns
+//  of their tables.  It is safe for concurrent use.
+//
+//     catalog := vm.NewCatalog()
+//     stmt, _ := vm.ParseSql("CREATE TABLE users (user_id BIGINT NOT NULL, name TEXT)")
+//     err := catalog.Execute(stmt)
+//
+//     stmt, _ = vm.ParseSql(`INSERT INTO users (user_id, nme) VALUES (1, "bob")`)
+//     err = catalog.Validate(stmt) // no column nme in table users
+//
+type Catalog struct {
+	mu     sync.RWMutex
+	tables map[string]*TableSchema // by lower case name
+	vars   map[string]Value        // for SHOW VARIABLES
+}
+
+// TableSchema is the declared columns of a table, in order
+type TableSchema struct {
+	Name       string
+	Columns    []*ColumnDef
+	PrimaryKey []string
+}
+
+func NewCatalog() *Catalog {
+	return &Catalog{tables: make(map[string]*TableSchema), vars: make(map[string]Value)}
+}
+
+// SetVariable sets a named variable, such as a version or setting, that
+// is listed by SHOW VARIABLES
+func (m *Catalog) SetVariable(name string, v Value) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.vars[name] = v
+}
+
+// Variables is a copy of all of the variables
+func (m *Catalog) Variables() map[string]Value {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	vars := make(map[string]Value, len(m.vars))
+	for name, v := range m.vars {
+		vars[name] = v
+	}
+	return vars
+}
+
+// Column finds a column by name, case insensitive
+func (m *TableSchema) Column(name string) (*ColumnDef, bool) {
+	for _, col := range m.Columns {
+		if strings.EqualFold(col.Name, name) {
+			return col, true
+		}
+	}
+	return nil, false
+}
+
+// Table finds a table by name, case insensitive
+func (m *Catalog) Table(name string) (*TableSchema, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tbl, ok := m.tables[strings.ToLower(name)]
+	return tbl, ok
+}
+
+// Tables are all of the tables, sorted by name
+func (m *Catalog) Tables() []*TableSchema {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tables := make([]*TableSchema, 0, len(m.tables))
+	for _, tbl := range m.tables {
+		tables = append(tables, tbl)
+	}
+	sort.Sort(tablesByName(tables))
+	return tables
+}
+
+// Execute applies a CREATE TABLE, DROP TABLE or ALTER TABLE to the catalog,
+// use Alter to also migrate the existing rows of an altered table
+func (m *Catalog) Execute(stmt SqlStatement) error {
+	if alter, ok := stmt.(*SqlAlter); ok {
+		return m.Alter(alter, nil)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch v := stmt.(type) {
+	case *SqlCreate:
+		key := strings.ToLower(v.Table)
+		if _, exists := m.tables[key]; exists {
+			return fmt.Errorf("table %s already exists", v.Table)
+		}
+		m.tables[key] = &TableSchema{Name: v.Table, Columns: v.Columns, PrimaryKey: v.PrimaryKey}
+		return nil
+	case *SqlDrop:
+		key := strings.ToLower(v.Table)
+		if _, exists := m.tables[key]; !exists {
+			return fmt.Errorf("no table %s", v.Table)
+		}
+		delete(m.tables, key)
+		return nil
+	}
+	return fmt.Errorf("catalog cannot execute %v", stmt.Keyword())
+}
+
+// Alter applies an ALTER TABLE to the schema of its table, and migrates the
+//  existing rows of the table, read from the RowScanner, in place to the new
+//  columns.  Added columns are set to their default, changed columns are
+//  renamed and converted to their new type, dropped columns are removed.  If
+//  any column change, or row, fails nothing is changed.  Rows may be nil.
+//
+//  The maps returned by rows.Next() must be the live rows of the table, as
+//  they are changed in place.  A scanner that is a RowRewinder, such as
+//  ContextSimple, is rewound to its first row, any other must be fresh.
+//
+//     stmt, _ := vm.ParseSql("ALTER TABLE users ADD ct BIGINT DEFAULT 0 AFTER user_id, DROP bio")
+//     err := catalog.Alter(stmt.(*vm.SqlAlter), usersRows)
+//
+func (m *Catalog) Alter(alter *SqlAlter, rows RowScanner) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tbl, ok := m.tables[strings.ToLower(alter.Table)]
+	if !ok {
+		return fmt.Errorf("no table %s", alter.Table)
+	}
+	altered, err := tbl.alter(alter)
+	if err != nil {
+		return err
+	}
+	if rows != nil {
+		if rewinder, ok := rows.(RowRewinder); ok {
+			rewinder.Rewind()
+		}
+		if err := migrateRows(alter, rows); err != nil {
+			return err
+		}
+	}
+	m.tables[strings.ToLower(alter.Table)] = altered
+	return nil
+}
+
+// alter returns a copy of the schema with the column changes applied
+func (m *TableSchema) alter(alter *SqlAlter) (*TableSchema, error) {
+	cols := append([]*ColumnDef(nil), m.Columns...)
+	pk := append([]string(nil), m.PrimaryKey...)
+	for _, alt := range alter.Changes {
+		idx := columnIndex(cols, alt.Name)
+		switch alt.Op {
+		case ql.TokenAdd:
+			if idx >= 0 {
+				return nil, fmt.Errorf("column %s already exists in table %s", alt.Name, m.Name)
+			}
+		case ql.TokenChange, ql.TokenDrop:
+			if idx < 0 {
+				return nil, fmt.Errorf("no column %s in table %s", alt.Name, m.Name)
+			}
+			cols = append(cols[:idx:idx], cols[idx+1:]...)
+			for i, key := range pk {
+				if !strings.EqualFold(key, alt.Name) {
+					continue
+				} else if alt.Op == ql.TokenDrop {
+					return nil, fmt.Errorf("cannot drop primary key column %s of table %s", alt.Name, m.Name)
+				}
+				pk[i] = alt.Column.Name
+			}
+		}
+		if alt.Op == ql.TokenDrop {
+			if len(cols) == 0 {
+				return nil, fmt.Errorf("cannot drop the only column of table %s", m.Name)
+			}
+			continue
+		}
+		if columnIndex(cols, alt.Column.Name) >= 0 {
+			return nil, fmt.Errorf("column %s already exists in table %s", alt.Column.Name, m.Name)
+		}
+		// added columns are last, changed columns stay in place
+		pos := len(cols)
+		switch {
+		case alt.First:
+			pos = 0
+		case alt.After != "":
+			after := columnIndex(cols, alt.After)
+			if after < 0 {
+				return nil, fmt.Errorf("no column %s in table %s", alt.After, m.Name)
+			}
+			pos = after + 1
+		case alt.Op == ql.TokenChange:
+			pos = idx
+		}
+		cols = append(cols[:pos:pos], append([]*ColumnDef{alt.Column}, cols[pos:]...)...)
+	}
+	return &TableSchema{Name: m.Name, Columns: cols, PrimaryKey: pk}, nil
+}
+
+func columnIndex(cols []*ColumnDef, name string) int {
+	for i, col := range cols {
+		if strings.EqualFold(col.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// migrateRows changes all of the rows to the new columns, or none if any
+// row cannot be changed
+func migrateRows(alter *SqlAlter, rows RowScanner) error {
+	s := NewSqlVmStatement(alter).newState(NewContextSimple())
+	existing := make([]map[string]Value, 0)
+	migrated := make([]map[string]Value, 0)
+	for row := rows.Next(); row != nil; row = rows.Next() {
+		out, err := migrateRow(alter, s, row)
+		if err != nil {
+			return err
+		}
+		existing = append(existing, row)
+		migrated = append(migrated, out)
+	}
+	for i, row := range existing {
+		for k := range row {
+			delete(row, k)
+		}
+		for k, v := range migrated[i] {
+			row[k] = v
+		}
+	}
+	return nil
+}
+
+func migrateRow(alter *SqlAlter, s *State, row map[string]Value) (map[string]Value, error) {
+	out := make(map[string]Value, len(row))
+	for k, v := range row {
+		out[k] = v
+	}
+	for _, alt := range alter.Changes {
+		var v Value
+		if key, ok := rowKey(out, alt.Name); ok && alt.Op != ql.TokenAdd {
+			v = out[key]
+			delete(out, key)
+		}
+		if alt.Op == ql.TokenDrop {
+			continue
+		}
+		col := alt.Column
+		if isNullValue(v) && col.Default != nil {
+			s.Reader = NewContextSimpleData(out)
+			v, _ = s.Walk(col.Default.Root)
+		}
+		if isNullValue(v) {
+			if col.NotNull {
+				return nil, fmt.Errorf("column %s is NOT NULL but an existing row has no value", col.Name)
+			}
+			out[col.Name] = NewNilValue()
+			continue
+		}
+		cv, err := convertColumnValue(col, v)
+		if err != nil {
+			return nil, err
+		}
+		out[col.Name] = cv
+	}
+	return out, nil
+}
+
+// the key of a column in a row, case insensitive
+func rowKey(row map[string]Value, name string) (string, bool) {
+	if _, ok := row[name]; ok {
+		return name, true
+	}
+	for k := range row {
+		if strings.EqualFold(k, name) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// convert a value to the type of the column
+func convertColumnValue(col *ColumnDef, v Value) (Value, error) {
+	if col.ValueType() == IntType {
+		if i, ok := intOf(v); ok {
+			return NewIntValue(i), nil
+		}
+		if f, ok := v.(NumberValue); ok && f.v == float64(int64(f.v)) {
+			return NewIntValue(int64(f.v)), nil
+		}
+		return nil, fmt.Errorf("cannot convert %q to bigint for column %s", v.ToString(), col.Name)
+	}
+	str := v.ToString()
+	if col.Size > 0 && len([]rune(str)) > col.Size {
+		return nil, fmt.Errorf("value %q is too long for column %s", str, col.Name)
+	}
+	return NewStringValue(str), nil
+}
+
+// Validate checks that the tables and columns used by a statement are
+//  declared in the catalog, and that an insert has a value for every
+//  NOT NULL column without a default.
+func (m *Catalog) Validate(stmt SqlStatement) error {
+	switch v := stmt.(type) {
+	case *SqlSelect:
+		return m.validateSelect(v)
+	case *SqlInsert:
+		return m.validateInsert(v)
+	case *SqlUpdate:
+		tbl, err := m.table(v.Table)
+		if err != nil {
+			return err
+		}
+		for _, col := range v.Columns {
+			if err := tbl.checkColumn(col.As); err != nil {
+				return err
+			}
+			if err := tbl.checkTree(col.Tree, nil); err != nil {
+				return err
+			}
+		}
+		return tbl.checkTree(v.Where, nil)
+	case *SqlDelete:
+		tbl, err := m.table(v.Table)
+		if err != nil {
+			return err
+		}
+		return tbl.checkTree(v.Where, nil)
+	case *SqlCreate:
+		if _, exists := m.Table(v.Table); exists {
+			return fmt.Errorf("table %s already exists", v.Table)
+		}
+	case *SqlDrop:
+		_, err := m.table(v.Table)
+		return err
+	case *SqlAlter:
+		tbl, err := m.table(v.Table)
+		if err != nil {
+			return err
+		}
+		_, err = tbl.alter(v)
+		return err
+	}
+	return nil
+}
+
+func (m *Catalog) validateSelect(sel *SqlSelect) error {
+	return m.validateSubSelect(sel, nil)
+}
+
+// validateSubSelect validates a select, or a subquery which may also use
+// the columns of the tables of its outer query
+func (m *Catalog) validateSubSelect(sel *SqlSelect, outer *selectTables) error {
+	if sel.From == "" {
+		// select @@version
+		return nil
+	}
+	tbl, err := m.selectTables(sel)
+	if err != nil {
+		return err
+	}
+	tbl.outer = outer
+	for _, join := range sel.Joins {
+		if err := tbl.checkTree(join.On, nil); err != nil {
+			return err
+		}
+	}
+	// having, order by may also use the column aliases
+	aliases := make(map[string]bool)
+	for _, col := range sel.Columns {
+		if col.Star {
+			continue
+		}
+		aliases[strings.ToLower(col.As)] = true
+		if err := tbl.checkTree(col.Tree, nil); err != nil {
+			return err
+		}
+		if err := tbl.checkTree(col.Guard, nil); err != nil {
+			return err
+		}
+	}
+	if err := tbl.checkTree(sel.Where, nil); err != nil {
+		return err
+	}
+	for _, col := range sel.GroupBy {
+		if err := tbl.checkTree(col.Tree, nil); err != nil {
+			return err
+		}
+	}
+	if err := tbl.checkTree(sel.Having, aliases); err != nil {
+		return err
+	}
+	for _, ob := range sel.OrderBy {
+		if err := tbl.checkTree(ob.Tree, aliases); err != nil {
+			return err
+		}
+	}
+	for _, tree := range selectTrees(sel) {
+		if tree == nil || tree.Root == nil {
+			continue
+		}
+		Walk(tree.Root, func(n Node) {
+			if sq, ok := n.(*SubQueryNode); ok && err == nil {
+				err = m.validateSubSelect(sq.Select, tbl)
+			}
+		})
+	}
+	return err
+}
+
+func (m *Catalog) validateInsert(ins *SqlInsert) error {
+	tbl, err := m.table(ins.Into)
+	if err != nil {
+		return err
+	}
+	for i, col := range ins.Columns {
+		def, ok := tbl.Column(col.As)
+		if !ok {
+			return fmt.Errorf("no column %s in table %s", col.As, tbl.Name)
+		}
+		for _, row := range ins.Rows {
+			if i >= len(row) {
+				continue
+			}
+			if row[i].Type() == StringType && def.ValueType() == IntType {
+				if _, isInt := intOf(row[i]); !isInt {
+					return fmt.Errorf("column %s of table %s is a bigint but got %q", def.Name, tbl.Name, row[i].ToString())
+				}
+			}
+		}
+	}
+	for _, row := range ins.Rows {
+		if len(row) != len(ins.Columns) {
+			return fmt.Errorf("insert has %d columns but a row has %d values", len(ins.Columns), len(row))
+		}
+	}
+	for _, def := range tbl.Columns {
+		if !def.NotNull || def.Default != nil {
+			continue
+		}
+		if !hasColumn(ins.Columns, def.Name) {
+			return fmt.Errorf("column %s of table %s is NOT NULL and has no default", def.Name, tbl.Name)
+		}
+	}
+	for _, col := range ins.OnDuplicate {
+		if err := tbl.checkColumn(col.As); err != nil {
+			return err
+		}
+		if err := tbl.checkTree(col.Tree, nil); err != nil {
+			return err
+		}
+	}
+	if ins.Select != nil {
+		return m.validateSelect(ins.Select)
+	}
+	return nil
+}
+
+// the from table of a select, and the tables joined to it
+func (m *Catalog) selectTables(sel *SqlSelect) (*selectTables, error) {
+	tables := &selectTables{}
+	var from *TableSchema
+	var err error
+	if sel.SubQuery != nil {
+		from, err = m.derivedTable(sel.SubQuery, sel.Alias)
+	} else {
+		from, err = m.table(sel.From)
+	}
+	if err != nil {
+		return nil, err
+	}
+	name := sel.From
+	if sel.Alias != "" {
+		name = sel.Alias
+	}
+	tables.add(name, from)
+	for _, join := range sel.Joins {
+		tbl, err := m.table(join.Table)
+		if err != nil {
+			return nil, err
+		}
+		tables.add(join.Name(), tbl)
+	}
+	return tables, nil
+}
+
+// derivedTable is the schema of a derived table, FROM (SELECT ...) AS t,
+// whose columns are those of the subquery
+func (m *Catalog) derivedTable(sel *SqlSelect, alias string) (*TableSchema, error) {
+	if err := m.validateSelect(sel); err != nil {
+		return nil, err
+	}
+	tbl := &TableSchema{Name: alias}
+	for _, col := range sel.Columns {
+		if !col.Star {
+			tbl.Columns = append(tbl.Columns, &ColumnDef{Name: col.As})
+			continue
+		}
+		tables, err := m.selectTables(sel)
+		if err != nil {
+			return nil, err
+		}
+		for _, star := range tables.tables {
+			tbl.Columns = append(tbl.Columns, star.Columns...)
+		}
+	}
+	return tbl, nil
+}
+
+func (m *Catalog) table(name string) (*TableSchema, error) {
+	tbl, ok := m.Table(name)
+	if !ok {
+		return nil, fmt.Errorf("no table %s", name)
+	}
+	return tbl, nil
+}
+
+func (m *TableSchema) checkColumn(name string) error {
+	if _, ok := m.Column(name); !ok {
+		return fmt.Errorf("no column %s in table %s", name, m.Name)
+	}
+	return nil
+}
+
+// checkTree ensures every identity in the expression is a column of the
+// table, or one of the aliases
+func (m *TableSchema) checkTree(tree *Tree, aliases map[string]bool) error {
+	return checkIdentities(tree, aliases, m.checkColumn)
+}
+
+// the tables of a select, by their alias, or name, and those of the outer
+// query of a subquery
+type selectTables struct {
+	names  []string
+	tables []*TableSchema
+	outer  *selectTables
+}
+
+func (m *selectTables) add(name string, tbl *TableSchema) {
+	m.names = append(m.names, name)
+	m.tables = append(m.tables, tbl)
+}
+
+// checkColumn ensures a column qualified by table, u.name, is a column
+// of that table, and an unqualified one is a column of any of the tables,
+// or of the outer query
+func (m *selectTables) checkColumn(name string) error {
+	err := m.tablesColumn(name)
+	if err != nil && m.outer != nil && m.outer.checkColumn(name) == nil {
+		// a correlated subquery
+		return nil
+	}
+	return err
+}
+
+func (m *selectTables) tablesColumn(name string) error {
+	if i := joinRowIndex(m.names, name); i >= 0 {
+		return m.tables[i].checkColumn(name[strings.IndexByte(name, '.')+1:])
+	}
+	if len(m.tables) == 1 {
+		return m.tables[0].checkColumn(name)
+	}
+	for _, tbl := range m.tables {
+		if _, ok := tbl.Column(name); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("no column %s in tables %s", name, strings.Join(m.names, ", "))
+}
+
+func (m *selectTables) checkTree(tree *Tree, aliases map[string]bool) error {
+	return checkIdentities(tree, aliases, m.checkColumn)
+}
+
+func checkIdentities(tree *Tree, aliases map[string]bool, checkColumn func(name string) error) error {
+	if tree == nil || tree.Root == nil {
+		return nil
+	}
+	var err error
+	Walk(tree.Root, func(n Node) {
+		id, ok := n.(*IdentityNode)
+		if !ok || err != nil || aliases[strings.ToLower(id.Text)] {
+			return
+		}
+		switch strings.ToLower(id.Text) {
+		case "true", "false":
+			return
+		}
+		err = checkColumn(id.Text)
+	})
+	return err
+}
+
+func hasColumn(cols Columns, name string) bool {
+	for _, col := range cols {
+		if strings.EqualFold(col.As, name) {
+			return true
+		}
+	}
+	return false
+}
+
+type tablesByName []*TableSchema
+
+func (m tablesByName) Len() int           { return len(m) }
+func (m tablesByName) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m tablesByName) Less(i, j int) bool { return m[i].Name < m[j].Name }
@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	u "github.com/araddon/gou"
+	ql "github.com/araddon/qlbridge/lex"
 )
 
 var _ = u.EMPTY
@@ -237,6 +238,102 @@ func Equal(itemA, itemB Value) (bool, error) {
 	return false, fmt.Errorf("Could not evaluate equals")
 }
 
+// Compare orders two non-nil values, returning -1, 0, +1 if a is less than,
+//  equal to, or greater than b
+//
+//    numbers, times compare numerically
+//    strings compare lexically, but numeric strings compare to numbers numerically
+//    false < true
+//    otherwise compares the string representations
+func Compare(a, b Value) int {
+	return CompareCollate(a, b, ql.CollateBinary)
+}
+
+// CompareCollate is Compare, with strings compared in a collation
+func CompareCollate(a, b Value, collation ql.Collation) int {
+	switch at := a.(type) {
+	case IntValue:
+		if bt, ok := b.(IntValue); ok {
+			return compareInts(at.v, bt.v)
+		}
+	case StringValue:
+		if bt, ok := b.(StringValue); ok {
+			return compareStrings(at.v, bt.v, collation)
+		}
+	case BoolValue:
+		if bt, ok := b.(BoolValue); ok {
+			switch {
+			case at.v == bt.v:
+				return 0
+			case bt.v:
+				return -1
+			}
+			return 1
+		}
+	}
+	af, aok := compareNumber(a)
+	bf, bok := compareNumber(b)
+	if aok && bok {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+	return compareStrings(a.ToString(), b.ToString(), collation)
+}
+
+// compare two strings lexicographically, in a collation
+func compareStrings(a, b string, collation ql.Collation) int {
+	if collation == ql.CollateNoCase {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// the hash key of a non null value, numbers and numeric strings share keys
+// as they compare equal
+func valueKey(v Value) string {
+	if f, isNumber := compareNumber(v); isNumber {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return v.ToString()
+}
+
+// the hash key of a non null value in a collation, strings equal ignoring
+// case share keys if it ignores case
+func collateKey(v Value, collation ql.Collation) string {
+	if _, isNumber := compareNumber(v); !isNumber && collation == ql.CollateNoCase {
+		return strings.ToLower(v.ToString())
+	}
+	return valueKey(v)
+}
+
+// the numeric value of a for comparison, if it has one
+func compareNumber(a Value) (float64, bool) {
+	switch at := a.(type) {
+	case NumericValue:
+		return at.Float(), true
+	case StringValue:
+		if f := ToFloat64(at.Rv()); !math.IsNaN(f) {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
 // ToString convert all reflect.Value-s into string.
 func ToString(v reflect.Value) (string, bool) {
 	if v.Kind() == reflect.Interface {
@@ -4,12 +4,16 @@ import (
 	"fmt"
 	u "github.com/araddon/gou"
 	"net/url"
+	"strings"
 	"time"
 )
 
 var (
 	_ ContextWriter = (*ContextSimple)(nil)
 	_ ContextReader = (*ContextSimple)(nil)
+	_ RowUpdater    = (*ContextSimple)(nil)
+	_ RowRewinder   = (*ContextSimple)(nil)
+	_ RowKeyWriter  = (*ContextSimple)(nil)
 	_ ContextWriter = (*ContextUrlValues)(nil)
 	_ ContextReader = (*ContextUrlValues)(nil)
 	_               = u.EMPTY
@@ -37,11 +41,36 @@ type RowWriter interface {
 type RowScanner interface {
 	Next() map[string]Value
 }
+
+// for RowScanners that can start over from their first row
+type RowRewinder interface {
+	Rewind()
+}
+
+// for updating rows in place (update), the row is one returned
+// by the RowScanner
+type RowUpdater interface {
+	Update(row map[string]Value, set map[string]Value) error
+}
+
+// for storage that knows the key of its rows, so an insert with the key
+// of an existing row updates it instead (upsert, on duplicate key update)
+type RowKeyWriter interface {
+	RowWriter
+	RowUpdater
+	// the existing row with the same key as the values of row
+	RowForKey(row map[string]Value) (map[string]Value, bool)
+}
 type ContextSimple struct {
 	Data   map[string]Value
 	Rows   []map[string]Value
+	Key    []string // names of the key columns of Rows, for RowForKey
 	ts     time.Time
 	cursor int
+	// index of Key values to row, built by RowForKey and kept up to date
+	// by Commit, Insert, Update, Delete so Rows shouldn't be appended to
+	// directly once it is built
+	keyIndex map[string]map[string]Value
 }
 
 func NewContextSimple() *ContextSimple {
@@ -75,12 +104,15 @@ func (m *ContextSimple) Put(col SchemaInfo, rctx ContextReader, v Value) error {
 	return nil
 }
 func (m *ContextSimple) Commit(rowInfo []SchemaInfo, row RowWriter) error {
-	m.Rows = append(m.Rows, m.Data)
+	m.Insert(m.Data)
 	m.Data = make(map[string]Value)
 	return nil
 }
 func (m *ContextSimple) Insert(row map[string]Value) {
 	m.Rows = append(m.Rows, row)
+	if key, ok := m.rowKey(row); ok && m.keyIndex != nil {
+		m.keyIndex[key] = row
+	}
 }
 func (m *ContextSimple) Delete(delRow map[string]Value) error {
 	for i, row := range m.Rows {
@@ -98,6 +130,7 @@ func (m *ContextSimple) Delete(delRow map[string]Value) error {
 			}
 		}
 		if foundMatch {
+			m.keyIndex = nil
 			// we need to delete
 			// a = append(a[:i], a[j:]...)
 			//u.Infof("len=%d i=%d >?%v", len(m.Rows), i, len(m.Rows) > i+1)
@@ -136,6 +169,7 @@ func (m *ContextSimple) DeleteMatch(delRow map[string]Value) error {
 		}
 	}
 	if len(rowsToDelete) > 0 {
+		m.keyIndex = nil
 		newRows := make([]map[string]Value, 0)
 		for i, row := range m.Rows {
 			if _, ok := rowsToDelete[i]; !ok {
@@ -146,6 +180,64 @@ func (m *ContextSimple) DeleteMatch(delRow map[string]Value) error {
 	}
 	return nil
 }
+
+// Update sets values of a row, which must be one of Rows such as from Next(),
+// the values are set on the live row map in place
+func (m *ContextSimple) Update(row map[string]Value, set map[string]Value) error {
+	if key, ok := m.rowKey(row); ok && m.keyIndex != nil {
+		delete(m.keyIndex, key)
+		defer func() {
+			if key, ok := m.rowKey(row); ok {
+				m.keyIndex[key] = row
+			}
+		}()
+	}
+	for k, v := range set {
+		row[k] = v
+	}
+	return nil
+}
+
+// RowForKey finds the row with the same Key values, there is never
+// a match if there is no Key, or row doesn't have all of the Key.  Key
+// values compare as in Compare, so 5 and "5" are the same key.
+func (m *ContextSimple) RowForKey(row map[string]Value) (map[string]Value, bool) {
+	key, ok := m.rowKey(row)
+	if !ok {
+		return nil, false
+	}
+	if m.keyIndex == nil {
+		m.keyIndex = make(map[string]map[string]Value, len(m.Rows))
+		for _, r := range m.Rows {
+			if rkey, ok := m.rowKey(r); ok {
+				m.keyIndex[rkey] = r
+			}
+		}
+	}
+	r, ok := m.keyIndex[key]
+	return r, ok
+}
+
+// the index key of the Key values of row, not ok if it has no Key
+// or any of the Key values are missing, or null
+func (m *ContextSimple) rowKey(row map[string]Value) (string, bool) {
+	if len(m.Key) == 0 {
+		return "", false
+	}
+	parts := make([]string, len(m.Key))
+	for i, k := range m.Key {
+		v, ok := row[k]
+		if !ok || isNullValue(v) {
+			return "", false
+		}
+		parts[i] = valueKey(v)
+	}
+	return strings.Join(parts, "\x00"), true
+}
+// Rewind starts Next() over from the first row
+func (m *ContextSimple) Rewind() {
+	m.cursor = 0
+}
 func (m *ContextSimple) Next() map[string]Value {
 	if len(m.Rows) <= m.cursor {
 		return nil
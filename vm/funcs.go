@@ -12,7 +12,7 @@ var (
 	_ = u.EMPTY
 
 	// the func mutext
-	funcMu sync.Mutex
+	funcMu sync.RWMutex
 	funcs  = make(map[string]Func)
 )
 
@@ -0,0 +1,322 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	ql "github.com/araddon/qlbridge/lex"
+)
+
+// ExecuteJoin executes a select of joined tables, reading the rows of each
+//  table from its RowScanner in sources, by table name, and adds each joined
+//  row to the results, so the WHERE, GROUP BY, ORDER BY of the select apply
+//  to the joined rows.  Columns are read qualified by table alias, u.name,
+//  or unqualified from the first table that has them.
+//
+//     sqlVm, _ := vm.NewSqlVm("SELECT u.name, o.total FROM users u JOIN orders o ON u.id = o.user_id")
+//     results, _ := sqlVm.NewResults()
+//     err := sqlVm.ExecuteJoin(results, map[string]vm.RowScanner{"users": users, "orders": orders})
+//     rows := results.Rows()
+//
+// The first table is streamed, joined tables are read once into memory.  A
+// join whose ON is an equality between a joined table and the tables before
+// it is a hash join on the values of that equality, otherwise each row is
+// compared to every row of the joined table.
+//
+// Subqueries, and derived tables, read their tables from the same sources,
+// so any table read by a subquery is read into memory rather than streamed.
+//
+//     SELECT name FROM users WHERE id IN (SELECT user_id FROM orders)
+//     SELECT t.name, t.ct FROM (SELECT name, count(*) AS ct FROM orders GROUP BY name) AS t
+//
+func (m *SqlVm) ExecuteJoin(results *SqlResults, sources map[string]RowScanner) (err error) {
+	defer errRecover(&err)
+
+	if m.Keyword != ql.TokenSelect {
+		return fmt.Errorf("join is only for select but got %v", m.Keyword)
+	}
+	if err := m.checkParams(); err != nil {
+		return err
+	}
+	m.subs = newSubQueries(sources, m.Catalog)
+	m.subs.validate(m.sel)
+	if m.subs.err != nil {
+		return m.subs.err
+	}
+	if err := m.executeJoin(results, nil, !hasSubQueries(m.sel)); err != nil {
+		return err
+	}
+	return m.subs.err
+}
+
+// executeJoin joins the rows of the tables of the select, streaming the
+// first table if allowed.  The rows of a correlated subquery also read
+// the columns of the row of the outer query.
+func (m *SqlVm) executeJoin(results *SqlResults, outer ContextReader, stream bool) error {
+	names := make([]string, 1, len(m.sel.Joins)+1)
+	names[0] = m.sel.From
+	if m.sel.Alias != "" {
+		names[0] = m.sel.Alias
+	}
+	tables := make([]*joinTable, len(m.sel.Joins))
+	for i, join := range m.sel.Joins {
+		rows, err := m.subs.table(join.Table)
+		if err != nil {
+			return err
+		}
+		tables[i] = m.newJoinTable(join, rows, names)
+		names = append(names, join.Name())
+	}
+
+	ts := time.Now()
+	var rows []map[string]Value
+	var err error
+	switch {
+	case m.sel.SubQuery != nil:
+		rows, err = m.subQueryRows(m.sel.SubQuery, outer)
+	case !stream || m.subs.hasTable(m.sel.From):
+		// read by a subquery, or joined to itself
+		rows, err = m.subs.table(m.sel.From)
+	default:
+		scanner, err := joinSource(m.subs.sources, m.sel.From)
+		if err != nil {
+			return err
+		}
+		for row := scanner.Next(); row != nil; row = scanner.Next() {
+			jr := &joinRow{names: names, rows: []map[string]Value{copyJoinRow(row)}, ts: ts}
+			if err := m.joinRows(tables, jr, results); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		jr := &joinRow{names: names, rows: []map[string]Value{row}, ts: ts, outer: outer}
+		if err := m.joinRows(tables, jr, results); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSingleSource errors for a select of joined tables, or from a derived
+// table, which can only be executed by ExecuteJoin, not against the single
+// row of a ContextReader
+func (m *SqlSelect) checkSingleSource() error {
+	if len(m.Joins) > 0 {
+		return fmt.Errorf("select of joined tables must be executed with ExecuteJoin")
+	}
+	if m.SubQuery != nil {
+		return fmt.Errorf("select from a subquery must be executed with ExecuteJoin")
+	}
+	return nil
+}
+
+// joinRows joins a row of the tables before to the rows of the next
+// table, recursively, and adds the rows joined to all tables to the results
+func (m *SqlVm) joinRows(tables []*joinTable, jr *joinRow, results *SqlResults) error {
+	if len(tables) == 0 {
+		return results.Add(jr)
+	}
+	jt := tables[0]
+	matched := false
+	for _, row := range jt.candidates(m, jr) {
+		joined := jr.with(row)
+		if jt.join.On != nil {
+			v, ok := m.newState(joined).Walk(jt.join.On.Root)
+			if bv, isBool := v.(BoolValue); !ok || !isBool || !bv.v {
+				continue
+			}
+		}
+		matched = true
+		if err := m.joinRows(tables[1:], joined, results); err != nil {
+			return err
+		}
+	}
+	if !matched && jt.join.Kind == ql.TokenLeft {
+		// outer join, the columns of this table are null
+		return m.joinRows(tables[1:], jr.with(nil), results)
+	}
+	return nil
+}
+
+// a joined table and its rows, which for a hash join are also by the key
+// of their side of the ON equality
+type joinTable struct {
+	join      *JoinExpr
+	rows      []map[string]Value
+	before    Node         // side of the ON equality of the tables before, for a hash join
+	collation ql.Collation // of the ON equality, strings equal in it share keys
+	hashed    map[string][]map[string]Value
+}
+
+func (m *SqlVm) newJoinTable(join *JoinExpr, rows []map[string]Value, before []string) *joinTable {
+	jt := &joinTable{join: join, rows: rows}
+	if join.On == nil {
+		return jt
+	}
+	beforeSide, tableSide, collation, ok := equalitySides(join.On.Root, join.Name(), before)
+	if !ok {
+		return jt
+	}
+	jt.before, jt.collation = beforeSide, collation
+	jt.hashed = make(map[string][]map[string]Value)
+	names := []string{join.Name()}
+	for _, row := range rows {
+		s := m.newState(&joinRow{names: names, rows: []map[string]Value{row}})
+		if key, ok := joinKey(s, tableSide, collation); ok {
+			jt.hashed[key] = append(jt.hashed[key], row)
+		}
+	}
+	return jt
+}
+
+// the rows of this table which may join to a row of the tables before, for
+// a hash join only those of the same key
+func (m *joinTable) candidates(vm *SqlVm, jr *joinRow) []map[string]Value {
+	if m.hashed == nil {
+		return m.rows
+	}
+	key, ok := joinKey(vm.newState(jr), m.before, m.collation)
+	if !ok {
+		return nil
+	}
+	return m.hashed[key]
+}
+
+// the sides of an ON condition which is an equality of an expression of
+// the tables before, and one of the joined table, and the collation they
+// are compared in, such as
+//
+//    ON u.id = o.user_id
+//
+func equalitySides(on Node, table string, before []string) (beforeSide, tableSide Node, collation ql.Collation, ok bool) {
+	bn, isBinary := on.(*BinaryNode)
+	if !isBinary || (bn.Operator.T != ql.TokenEqual && bn.Operator.T != ql.TokenEqualEqual) {
+		return nil, nil, collation, false
+	}
+	a, b := bn.Args[0], bn.Args[1]
+	switch {
+	case qualifiedBy(a, before) && qualifiedBy(b, []string{table}):
+		return a, b, bn.Collation, true
+	case qualifiedBy(b, before) && qualifiedBy(a, []string{table}):
+		return b, a, bn.Collation, true
+	}
+	return nil, nil, collation, false
+}
+
+// does the node have identities, all qualified by one of the table names?
+func qualifiedBy(n Node, names []string) bool {
+	found, all := false, true
+	Walk(n, func(n Node) {
+		id, ok := n.(*IdentityNode)
+		if !ok {
+			return
+		}
+		found = true
+		if joinRowIndex(names, id.Text) < 0 {
+			all = false
+		}
+	})
+	return found && all
+}
+
+// the index of the table name which qualifies the column key, u.name, or
+// -1 if it is not qualified by one of them
+func joinRowIndex(names []string, key string) int {
+	i := strings.IndexByte(key, '.')
+	if i <= 0 {
+		return -1
+	}
+	for j, name := range names {
+		if strings.EqualFold(name, key[:i]) {
+			return j
+		}
+	}
+	return -1
+}
+
+// the hash key of a value of an ON equality, null never matches
+func joinKey(s *State, n Node, collation ql.Collation) (string, bool) {
+	v, ok := s.Walk(n)
+	if !ok || isNullValue(v) {
+		return "", false
+	}
+	return collateKey(v, collation), true
+}
+
+func joinSource(sources map[string]RowScanner, table string) (RowScanner, error) {
+	if scanner, ok := sources[table]; ok {
+		return scanner, nil
+	}
+	for name, scanner := range sources {
+		if strings.EqualFold(name, table) {
+			return scanner, nil
+		}
+	}
+	return nil, fmt.Errorf("no row source for table %s", table)
+}
+
+// copy the row, scanners may re-use theirs
+func copyJoinRow(row map[string]Value) map[string]Value {
+	cp := make(map[string]Value, len(row))
+	for k, v := range row {
+		cp[k] = v
+	}
+	return cp
+}
+
+// joinRow is a row of joined tables, the row of each table by its alias,
+// or name, where the row is nil for the missing side of an outer join.
+// Columns not of these tables are read from the outer row, if this is a
+// row of a correlated subquery.
+type joinRow struct {
+	names []string
+	rows  []map[string]Value
+	ts    time.Time
+	outer ContextReader
+}
+
+// the row joined to another table
+func (m *joinRow) with(row map[string]Value) *joinRow {
+	rows := make([]map[string]Value, len(m.rows), len(m.rows)+1)
+	copy(rows, m.rows)
+	return &joinRow{names: m.names, rows: append(rows, row), ts: m.ts, outer: m.outer}
+}
+
+func (m *joinRow) Get(key string) (Value, bool) {
+	if i := joinRowIndex(m.names[:len(m.rows)], key); i >= 0 {
+		if m.rows[i] == nil {
+			return NewNilValue(), true
+		}
+		v, ok := m.rows[i][key[strings.IndexByte(key, '.')+1:]]
+		return v, ok
+	}
+	for _, row := range m.rows {
+		if v, ok := row[key]; ok {
+			return v, true
+		}
+	}
+	if m.outer != nil {
+		return m.outer.Get(key)
+	}
+	return nil, false
+}
+
+// Row is the unqualified columns of all tables, the first table that has
+// a column wins
+func (m *joinRow) Row() map[string]Value {
+	row := make(map[string]Value)
+	for i := len(m.rows) - 1; i >= 0; i-- {
+		for k, v := range m.rows[i] {
+			row[k] = v
+		}
+	}
+	return row
+}
+
+func (m *joinRow) Ts() time.Time { return m.ts }
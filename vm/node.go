@@ -1,6 +1,7 @@
 package vm
 
 import (
+	"bytes"
 	"fmt"
 	u "github.com/araddon/gou"
 	ql "github.com/araddon/qlbridge/lex"
@@ -46,9 +47,11 @@ func (p Pos) Position() Pos { return p }
 // FuncNode holds a function invocation
 type FuncNode struct {
 	Pos
-	Name string // Name of func
-	F    Func   // The actual function that this AST maps to
-	Args []Node // Arguments are them-selves nodes
+	Name      string       // Name of func
+	F         Func         // The actual function that this AST maps to
+	Args      []Node       // Arguments are them-selves nodes
+	Agg       bool         // Is an aggregate func such as count(*), sum(x), evaluated per group not row
+	Collation ql.Collation // how an aggregate such as max(name) compares strings
 }
 
 func NewFuncNode(pos Pos, name string, f Func) *FuncNode {
@@ -72,6 +75,9 @@ func (c *FuncNode) String() string {
 }
 
 func (c *FuncNode) StringAST() string {
+	if c.Agg && len(c.Args) == 0 && strings.ToLower(c.Name) == "count" {
+		return c.Name + "(*)"
+	}
 	s := c.Name + "("
 	for i, arg := range c.Args {
 		//u.Debugf("arg: %v   %T", arg, arg)
@@ -86,6 +92,9 @@ func (c *FuncNode) StringAST() string {
 
 func (c *FuncNode) Check() error {
 
+	if c.Agg {
+		return c.checkAgg()
+	}
 	if len(c.Args) < len(c.F.Args) && !c.F.VariadicArgs {
 		return fmt.Errorf("parse: not enough arguments for %s  supplied:%d  f.Args:%v", c.Name, len(c.Args), len(c.F.Args))
 	} else if (len(c.Args) >= len(c.F.Args)) && c.F.VariadicArgs {
@@ -119,6 +128,26 @@ func (c *FuncNode) Check() error {
 	return nil
 }
 
+// aggregates take at most a single argument, the built in sum, avg,
+// min, max require one
+func (c *FuncNode) checkAgg() error {
+	switch {
+	case len(c.Args) > 1:
+		return fmt.Errorf("parse: too many arguments for aggregate %s want:1 got:%v", c.Name, len(c.Args))
+	case len(c.Args) == 0 && aggArgRequired[strings.ToLower(c.Name)]:
+		return fmt.Errorf("parse: not enough arguments for aggregate %s", c.Name)
+	}
+	for _, a := range c.Args {
+		if findAggregates(a) != nil {
+			return fmt.Errorf("parse: aggregate %s may not contain another aggregate", c.Name)
+		}
+		if err := a.Check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (f *FuncNode) Type() reflect.Value { return f.F.Return }
 
 // NumberNode holds a number: signed or unsigned integer or float.
@@ -133,10 +162,15 @@ type NumberNode struct {
 	Text    string  // The original textual representation from the input.
 }
 
+// NewNumber parses the text of a numeric literal
+//
+//   42, -827, 1_000_000, 0x1F, 0b1010, 1.5e-3, .5
 func NewNumber(pos Pos, text string) (*NumberNode, error) {
 	n := &NumberNode{Pos: pos, Text: text}
+	// digit separators are for readability only
+	num := strings.Replace(text, "_", "", -1)
 	// Do integer test first so we get 0x123 etc.
-	u, err := strconv.ParseInt(text, 0, 64) // will fail for -0.
+	u, err := parseInt(num) // will fail for -0.
 	if err == nil {
 		n.IsInt = true
 		n.Int64 = u
@@ -146,7 +180,7 @@ func NewNumber(pos Pos, text string) (*NumberNode, error) {
 		n.IsFloat = true
 		n.Float64 = float64(n.Int64)
 	} else {
-		f, err := strconv.ParseFloat(text, 64)
+		f, err := strconv.ParseFloat(num, 64)
 		if err == nil {
 			n.IsFloat = true
 			n.Float64 = f
@@ -163,6 +197,19 @@ func NewNumber(pos Pos, text string) (*NumberNode, error) {
 	return n, nil
 }
 
+// parse decimal, hex 0x1F, binary 0b1010, or octal 073 integers
+func parseInt(text string) (int64, error) {
+	if len(text) > 2 && text[0] == '0' {
+		switch text[1] {
+		case 'x', 'X':
+			return strconv.ParseInt(text[2:], 16, 64)
+		case 'b', 'B':
+			return strconv.ParseInt(text[2:], 2, 64)
+		}
+	}
+	return strconv.ParseInt(text, 0, 64)
+}
+
 func (n *NumberNode) String() string {
 	return n.Text
 }
@@ -177,24 +224,35 @@ func (n *NumberNode) Check() error {
 
 func (n *NumberNode) Type() reflect.Value { return floatRv }
 
-// StringNode holds a string constant, quotes not included
+// StringNode holds a string constant, quotes not included and
+//  escapes already decoded
 type StringNode struct {
 	Pos
-	Text string
+	Text     string
+	Quote    byte          // quote mark used in original, defaults to "
+	Escaping ql.EscapeMode // how to escape when re-quoting
 }
 
 func NewStringNode(pos Pos, text string) *StringNode {
 	return &StringNode{Pos: pos, Text: text}
 }
-func (m *StringNode) String() string      { return m.Text }
-func (m *StringNode) StringAST() string   { return fmt.Sprintf("%q", m.Text) }
+func (m *StringNode) String() string { return m.Text }
+func (m *StringNode) StringAST() string {
+	quote := rune(m.Quote)
+	if quote == 0 {
+		quote = '"'
+	}
+	return ql.QuoteString(m.Text, quote, m.Escaping)
+}
 func (m *StringNode) Check() error        { return nil }
 func (m *StringNode) Type() reflect.Value { return stringRv }
 
 // IdentityNode will look up a value out of a env bag
+//  the Text is the un-quoted, un-escaped name used for lookup
 type IdentityNode struct {
 	Pos
-	Text string
+	Text  string
+	Quote byte // quote mark if was quoted in original `order date`
 }
 
 func NewIdentityNode(pos Pos, text string) *IdentityNode {
@@ -202,10 +260,28 @@ func NewIdentityNode(pos Pos, text string) *IdentityNode {
 }
 
 func (m *IdentityNode) String() string      { return m.Text }
-func (m *IdentityNode) StringAST() string   { return m.Text }
+func (m *IdentityNode) StringAST() string   { return m.quoted() }
 func (m *IdentityNode) Check() error        { return nil }
 func (s *IdentityNode) Type() reflect.Value { return stringRv }
+
+// re-quote the identity using its original quote mark, escaping
+// any quote marks inside
+func (m *IdentityNode) quoted() string {
+	if m.Quote == 0 {
+		return m.Text
+	}
+	left, right := string(m.Quote), string(m.Quote)
+	if m.Quote == '[' {
+		right = "]"
+	}
+	return left + strings.Replace(m.Text, right, right+right, -1) + right
+}
+
+// Quoted identities are never boolean, `true` is a lookup of field true
 func (m *IdentityNode) IsBooleanIdentity() bool {
+	if m.Quote != 0 {
+		return false
+	}
 	val := strings.ToLower(m.Text)
 	if val == "true" || val == "false" {
 		return true
@@ -220,6 +296,298 @@ func (m *IdentityNode) Bool() bool {
 	return false
 }
 
+// ParamNode is a bind parameter placeholder, whose value is supplied
+//  when executing, see SqlVm.Bind(), BindNamed()
+//
+//    ?       positional, numbered in order of appearance
+//    $1      positional, 1 based
+//    :name   named
+type ParamNode struct {
+	Pos
+	Text  string // original text  ?  $1  :name
+	Name  string // name of a :name param, empty for positional
+	Index int    // 0 based index of a positional param, -1 for named, or ? not yet numbered
+}
+
+func NewParamNode(pos Pos, text string) (*ParamNode, error) {
+	n := &ParamNode{Pos: pos, Text: text, Index: -1}
+	switch {
+	case text == "?":
+	case len(text) > 1 && text[0] == '$':
+		idx, err := strconv.Atoi(text[1:])
+		if err != nil || idx < 1 {
+			return nil, fmt.Errorf("invalid param %q", text)
+		}
+		n.Index = idx - 1
+	case len(text) > 1 && text[0] == ':':
+		n.Name = text[1:]
+	default:
+		return nil, fmt.Errorf("invalid param %q", text)
+	}
+	return n, nil
+}
+
+func (m *ParamNode) String() string      { return m.Text }
+func (m *ParamNode) StringAST() string   { return m.Text }
+func (m *ParamNode) Check() error        { return nil }
+func (m *ParamNode) Type() reflect.Value { return stringRv }
+
+// is this a positional (? or $1) param?
+func (m *ParamNode) Positional() bool { return m.Name == "" }
+
+// SubQueryNode is a select within an expression.  As a scalar it is the
+//  value of the single column of its only row, or null if it has no rows,
+//  it is also the list of values on the right of IN, or the argument of
+//  EXISTS which is true if it has any rows.
+//
+//    SELECT name, (SELECT max(total) FROM orders) AS top FROM users
+//    WHERE user_id IN (SELECT user_id FROM orders WHERE total > 10)
+//    WHERE EXISTS (SELECT user_id FROM orders o WHERE o.user_id = u.id)
+//
+type SubQueryNode struct {
+	Pos
+	Select *SqlSelect
+	Exists bool
+}
+
+func NewSubQueryNode(pos Pos, sel *SqlSelect) *SubQueryNode {
+	return &SubQueryNode{Pos: pos, Select: sel}
+}
+
+func (m *SubQueryNode) String() string { return m.StringAST() }
+func (m *SubQueryNode) StringAST() string {
+	if m.Exists {
+		return fmt.Sprintf("EXISTS (%s)", m.Select)
+	}
+	return fmt.Sprintf("(%s)", m.Select)
+}
+func (m *SubQueryNode) Check() error { return nil }
+func (m *SubQueryNode) Type() reflect.Value {
+	if m.Exists {
+		return boolRv
+	}
+	return stringRv
+}
+
+// NullNode is the NULL literal
+type NullNode struct {
+	Pos
+}
+
+func NewNullNode(pos Pos) *NullNode {
+	return &NullNode{Pos: pos}
+}
+
+func (m *NullNode) String() string      { return "NULL" }
+func (m *NullNode) StringAST() string   { return "NULL" }
+func (m *NullNode) Check() error        { return nil }
+func (m *NullNode) Type() reflect.Value { return nilRv }
+
+// MultiArgNode is an operator with a list of arguments, the first is
+// compared to the rest of them
+//
+//    user_id IN (1, 2, 3)
+//    name NOT IN ("bob", "ann")
+//
+type MultiArgNode struct {
+	Pos
+	Args      []Node
+	Operator  ql.Token
+	Negated   bool         // NOT IN
+	Collation ql.Collation // how strings are compared in the dialect
+}
+
+func NewMultiArgNode(operator ql.Token, args ...Node) *MultiArgNode {
+	return &MultiArgNode{Pos: Pos(operator.Pos), Args: args, Operator: operator}
+}
+
+func (m *MultiArgNode) String() string { return m.StringAST() }
+func (m *MultiArgNode) StringAST() string {
+	args := make([]string, len(m.Args)-1)
+	for i, arg := range m.Args[1:] {
+		args[i] = arg.StringAST()
+	}
+	return fmt.Sprintf("%s %s%s (%s)", m.Args[0].StringAST(), negatedString(m.Negated), m.Operator.V, strings.Join(args, ", "))
+}
+func (m *MultiArgNode) Check() error {
+	for _, n := range m.Args {
+		if err := n.Check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (m *MultiArgNode) Type() reflect.Value { return boolRv }
+
+// TriNode is an operator with three arguments
+//
+//    ct BETWEEN 1 AND 10
+//    ct NOT BETWEEN 1 AND 10
+//
+type TriNode struct {
+	Pos
+	Args      [3]Node
+	Operator  ql.Token
+	Negated   bool         // NOT BETWEEN
+	Collation ql.Collation // how strings are compared in the dialect
+}
+
+func NewTriNode(operator ql.Token, arg1, arg2, arg3 Node) *TriNode {
+	return &TriNode{Pos: Pos(operator.Pos), Args: [3]Node{arg1, arg2, arg3}, Operator: operator}
+}
+
+func (m *TriNode) String() string { return m.StringAST() }
+func (m *TriNode) StringAST() string {
+	return fmt.Sprintf("%s %s%s %s AND %s", m.Args[0].StringAST(), negatedString(m.Negated), m.Operator.V,
+		m.Args[1].StringAST(), m.Args[2].StringAST())
+}
+func (m *TriNode) Check() error {
+	for _, n := range m.Args {
+		if err := n.Check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (m *TriNode) Type() reflect.Value { return boolRv }
+
+// IsNullNode tests if its argument is null, or missing
+//
+//    email IS NULL
+//    email IS NOT NULL
+//
+type IsNullNode struct {
+	Pos
+	Arg     Node
+	Negated bool // IS NOT NULL
+}
+
+func NewIsNullNode(pos Pos, arg Node) *IsNullNode {
+	return &IsNullNode{Pos: pos, Arg: arg}
+}
+
+func (m *IsNullNode) String() string { return m.StringAST() }
+func (m *IsNullNode) StringAST() string {
+	return fmt.Sprintf("%s IS %sNULL", m.Arg.StringAST(), negatedString(m.Negated))
+}
+func (m *IsNullNode) Check() error        { return m.Arg.Check() }
+func (m *IsNullNode) Type() reflect.Value { return boolRv }
+
+// CaseNode is a CASE expression, whose value is the THEN of the first
+//  WHEN that is true, or else the ELSE, or null if there is no ELSE.
+//  A searched CASE has WHEN conditions
+//
+//    CASE WHEN ct > 10 THEN "many" WHEN ct > 1 THEN "some" ELSE "one" END
+//
+//  a simple CASE compares its operand to the value of each WHEN
+//
+//    CASE ct WHEN 1 THEN "one" WHEN 2 THEN "two" END
+//
+type CaseNode struct {
+	Pos
+	Operand   Node         // the value of a simple CASE, nil if searched
+	Whens     []Node       // conditions, or values compared to the operand
+	Thens     []Node       // the result of each of the whens
+	Else      Node         // may be nil
+	Collation ql.Collation // how strings are compared to the operand
+}
+
+func NewCaseNode(pos Pos) *CaseNode {
+	return &CaseNode{Pos: pos}
+}
+
+func (m *CaseNode) String() string { return m.StringAST() }
+func (m *CaseNode) StringAST() string {
+	buf := bytes.Buffer{}
+	buf.WriteString("CASE")
+	if m.Operand != nil {
+		buf.WriteString(" " + m.Operand.StringAST())
+	}
+	for i, when := range m.Whens {
+		buf.WriteString(fmt.Sprintf(" WHEN %s THEN %s", when.StringAST(), m.Thens[i].StringAST()))
+	}
+	if m.Else != nil {
+		buf.WriteString(" ELSE " + m.Else.StringAST())
+	}
+	buf.WriteString(" END")
+	return buf.String()
+}
+
+// Check the whens of a searched CASE are conditions, not literal values,
+// and that the literal results are all numbers or all strings
+func (m *CaseNode) Check() error {
+	if m.Operand == nil {
+		for _, when := range m.Whens {
+			if _, isLiteral := literalValue(when); isLiteral {
+				return fmt.Errorf("parse: CASE WHEN must be a condition but got %s", when)
+			}
+		}
+	}
+	resultType := NilType
+	for _, n := range m.results() {
+		v, isLiteral := literalValue(n)
+		if !isLiteral || v.Type() == NilType {
+			continue
+		}
+		vt := v.Type()
+		if vt == IntType {
+			// ints and floats are both numbers
+			vt = NumberType
+		}
+		if resultType != NilType && vt != resultType {
+			return fmt.Errorf("parse: CASE results must be of one type but got %s", m)
+		}
+		resultType = vt
+	}
+	for _, n := range m.args() {
+		if err := n.Check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Type is that of the first non-null result
+func (m *CaseNode) Type() reflect.Value {
+	for _, n := range m.results() {
+		if _, isNull := n.(*NullNode); !isNull {
+			return n.Type()
+		}
+	}
+	return nilRv
+}
+
+// the thens, and else
+func (m *CaseNode) results() []Node {
+	results := append([]Node{}, m.Thens...)
+	if m.Else != nil {
+		results = append(results, m.Else)
+	}
+	return results
+}
+
+// all of the sub-nodes, in order
+func (m *CaseNode) args() []Node {
+	args := make([]Node, 0, 2*len(m.Whens)+2)
+	if m.Operand != nil {
+		args = append(args, m.Operand)
+	}
+	for i, when := range m.Whens {
+		args = append(args, when, m.Thens[i])
+	}
+	if m.Else != nil {
+		args = append(args, m.Else)
+	}
+	return args
+}
+
+func negatedString(negated bool) string {
+	if negated {
+		return "NOT "
+	}
+	return ""
+}
+
 // BinaryNode holds two arguments and an operator
 /*
 binary_op  = "||" | "&&" | rel_op | add_op | mul_op .
@@ -231,9 +599,13 @@ unary_op   = "+" | "-" | "!" | "^" | "*" | "&" | "<-" .
 */
 type BinaryNode struct {
 	Pos
-	Paren    bool
-	Args     [2]Node
-	Operator ql.Token
+	Paren     bool
+	Args      [2]Node
+	Operator  ql.Token
+	Negated   bool         // NOT LIKE, NOT IN (SELECT ...)
+	Escape    *StringNode  // LIKE "a!%" ESCAPE "!"
+	Concat    bool         // the || or + concatenates strings in the dialect
+	Collation ql.Collation // how strings are compared in the dialect
 }
 
 func NewBinary(operator ql.Token, arg1, arg2 Node) *BinaryNode {
@@ -245,10 +617,14 @@ func (b *BinaryNode) String() string {
 }
 
 func (b *BinaryNode) StringAST() string {
+	s := fmt.Sprintf("%s %s%s %s", b.Args[0].StringAST(), negatedString(b.Negated), b.Operator.V, b.Args[1].StringAST())
+	if b.Escape != nil {
+		s += " ESCAPE " + b.Escape.StringAST()
+	}
 	if b.Paren {
-		return fmt.Sprintf("(%s %s %s)", b.Args[0].StringAST(), b.Operator.V, b.Args[1].StringAST())
+		return "(" + s + ")"
 	}
-	return fmt.Sprintf("%s %s %s", b.Args[0].StringAST(), b.Operator.V, b.Args[1].StringAST())
+	return s
 }
 
 func (b *BinaryNode) Check() error {
@@ -295,6 +671,10 @@ func NewUnary(operator ql.Token, arg Node) *UnaryNode {
 }
 
 func (n *UnaryNode) String() string {
+	if n.Operator.T == ql.TokenNegate && n.Operator.V != "!" {
+		// NOT x
+		return fmt.Sprintf("%s %s", n.Operator.V, n.Arg)
+	}
 	return fmt.Sprintf("%s%s", n.Operator.V, n.Arg)
 }
 
@@ -333,8 +713,24 @@ func Walk(arg Node, f func(Node)) {
 			for _, a := range n.Args {
 				Walk(a, f)
 			}
-		case *NumberNode, *StringNode:
+		case *MultiArgNode:
+			for _, a := range n.Args {
+				Walk(a, f)
+			}
+		case *TriNode:
+			for _, a := range n.Args {
+				Walk(a, f)
+			}
+		case *IsNullNode:
+			Walk(n.Arg, f)
+		case *CaseNode:
+			for _, a := range n.args() {
+				Walk(a, f)
+			}
+		case *NumberNode, *StringNode, *ParamNode, *NullNode:
 			// Ignore
+		case *SubQueryNode:
+			// the select is a scope of its own, not sub-nodes
 		case *IdentityNode:
 			//Walk(n.Arg, f)
 		case *UnaryNode:
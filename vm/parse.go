@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"runtime"
 	"strings"
+	"unicode/utf8"
 	//"strconv"
 
 	u "github.com/araddon/gou"
@@ -34,6 +35,48 @@ type TokenPager interface {
 	Last() ql.TokenType
 	Backup()
 	IsEnd() bool
+	Lexer() *ql.Lexer
+}
+
+// ParseError describes a failure to parse, with the location of the offending
+// token in the original input and (when known) the tokens that would have been
+// valid in its place
+type ParseError struct {
+	Msg      string         // description of what went wrong
+	Pos      int            // byte offset of the offending token in the input
+	Line     int            // 1 based line number of Pos
+	Column   int            // 1 based column number of Pos
+	Token    ql.Token       // the offending token
+	Expected []ql.TokenType // tokens that would have been valid, may be empty
+}
+
+func (e *ParseError) Error() string {
+	msg := fmt.Sprintf("parse error at line %d col %d: %s", e.Line, e.Column, e.Msg)
+	if len(e.Expected) > 0 {
+		names := make([]string, len(e.Expected))
+		for i, tt := range e.Expected {
+			names[i] = tt.String()
+		}
+		msg += fmt.Sprintf(" expected one of [%s]", strings.Join(names, ", "))
+	}
+	return msg
+}
+
+// newParseError creates a ParseError for the given token, using the lexer
+// to find line/column.  If the token was an error from the lexer, the lexers
+// more specific SyntaxError information is used.
+func newParseError(l *ql.Lexer, tok ql.Token, expected []ql.TokenType, msg string) *ParseError {
+	if tok.T == ql.TokenError && l != nil {
+		if se, ok := l.Err().(*ql.SyntaxError); ok {
+			return &ParseError{Msg: se.Msg, Pos: se.Pos, Line: se.Line, Column: se.Column,
+				Token: se.Token, Expected: se.Expected}
+		}
+	}
+	pe := &ParseError{Msg: msg, Pos: tok.Pos, Token: tok, Expected: expected}
+	if l != nil {
+		pe.Line, pe.Column = l.LineColumn(tok.Pos)
+	}
+	return pe
 }
 
 // SchemaInfo
@@ -75,6 +118,9 @@ func (m *ExpressionPager) Last() ql.TokenType {
 func (m *ExpressionPager) IsEnd() bool {
 	return false
 }
+func (m *ExpressionPager) Lexer() *ql.Lexer {
+	return m.lex
+}
 
 // backup backs the input stream up one token.
 func (m *ExpressionPager) Backup() {
@@ -115,7 +161,17 @@ func NewTree(pager TokenPager) *Tree {
 //    ParseExpression("5 * toint(item_name)")
 //
 func ParseExpression(expressionText string) (*Tree, error) {
-	lex := ql.NewLexer(expressionText, ql.LogicalExpressionDialect)
+	return ParseExpressionDialect(expressionText, ql.LogicalExpressionDialect)
+}
+
+// Parse a single Expression in a Dialect, whose rules for strings, such
+// as which operator concatenates them, differ from the default
+//
+//    ansi := &ql.Dialect{Statements: ql.LogicalExpressionDialect.Statements, Concat: ql.ConcatPipes}
+//    ParseExpressionDialect("first_name || ' ' || last_name", ansi)
+//
+func ParseExpressionDialect(expressionText string, dialect *ql.Dialect) (*Tree, error) {
+	lex := ql.NewLexer(expressionText, dialect)
 	pager := NewExpressionPager(lex)
 	t := NewTree(pager)
 	pager.end = ql.TokenEOF
@@ -127,25 +183,34 @@ func ParseExpression(expressionText string) (*Tree, error) {
 
 // errorf formats the error and terminates processing.
 func (t *Tree) errorf(format string, args ...interface{}) {
-	t.Root = nil
-	format = fmt.Sprintf("expr: %s", format)
-	msg := fmt.Errorf(format, args...)
-	u.LogTracef(u.WARN, "about to panic: %v", msg)
-	panic(msg)
+	t.parseError(t.Peek(), nil, fmt.Sprintf(format, args...))
 }
 
 // error terminates processing.
 func (t *Tree) error(err error) {
+	if pe, ok := err.(*ParseError); ok {
+		t.Root = nil
+		panic(pe)
+	}
 	t.errorf("%s", err)
 }
 
+// parseError terminates processing with a ParseError describing the
+// offending token, and which tokens were expected
+func (t *Tree) parseError(token ql.Token, expected []ql.TokenType, msg string) {
+	t.Root = nil
+	err := newParseError(t.Lexer(), token, expected, "expr: "+msg)
+	u.LogTracef(u.WARN, "about to panic: %v", err)
+	panic(err)
+}
+
 // expect consumes the next token and guarantees it has the required type.
 func (t *Tree) expect(expected ql.TokenType, context string) ql.Token {
 	token := t.Next()
 	//u.Debugf("checking expected? token? %v", token)
 	if token.T != expected {
 		u.Warnf("unexpeted token? %v want:%v", token, expected)
-		t.unexpected(token, context)
+		t.unexpectedOf(token, context, expected)
 	}
 	return token
 }
@@ -154,15 +219,21 @@ func (t *Tree) expect(expected ql.TokenType, context string) ql.Token {
 func (t *Tree) expectOneOf(expected1, expected2 ql.TokenType, context string) ql.Token {
 	token := t.Next()
 	if token.T != expected1 && token.T != expected2 {
-		t.unexpected(token, context)
+		t.unexpectedOf(token, context, expected1, expected2)
 	}
 	return token
 }
 
 // unexpected complains about the token and terminates processing.
 func (t *Tree) unexpected(token ql.Token, context string) {
+	t.unexpectedOf(token, context)
+}
+
+// unexpectedOf complains about the token, listing the tokens that would have
+// been valid, and terminates processing.
+func (t *Tree) unexpectedOf(token ql.Token, context string, expected ...ql.TokenType) {
 	u.Errorf("unexpected?  %v", token)
-	t.errorf("unexpected %s in %s", token, context)
+	t.parseError(token, expected, fmt.Sprintf("unexpected %s in %s", token, context))
 }
 
 // recover is the handler that turns panics into returns from the top level of Parse.
@@ -180,8 +251,11 @@ func (t *Tree) recover(errp *error) {
 
 // buildTree take the tokens and recursively build into expression tree node
 // @runCheck  Do we want to verify this tree?   If being used as VM then yes.
-func (t *Tree) BuildTree(runCheck bool) error {
+//
+// Errors in the expression are returned as *ParseError
+func (t *Tree) BuildTree(runCheck bool) (err error) {
 	//u.Debugf("parsing: %v", t.Text)
+	defer t.recover(&err)
 	t.runCheck = runCheck
 	t.Root = t.O()
 	//u.Debugf("after parse()")
@@ -216,7 +290,7 @@ TODO:
 O -> A {"||" A}
 A -> C {"&&" C}
 C -> P {( "==" | "!=" | ">" | ">=" | "<" | "<=" | "LIKE" | "IN" ) P}
-P -> M {( "+" | "-" ) M}
+P -> M {( "+" | "-" | "||" ) M}        // "||" only if the dialect concatenates with it
 M -> F {( "*" | "/" ) F}
 F -> v | "(" O ")" | "!" O | "-" O
 v -> number | func(..)
@@ -290,14 +364,89 @@ func (t *Tree) C() Node {
 	for {
 		switch t.Peek().T {
 		case ql.TokenEqual, ql.TokenEqualEqual, ql.TokenNE, ql.TokenGT, ql.TokenGE,
-			ql.TokenLE, ql.TokenLT, ql.TokenLike, ql.TokenIN:
-			n = NewBinary(t.Next(), n, t.P())
+			ql.TokenLE, ql.TokenLT:
+			n = t.binary(t.Next(), n, t.P())
+		case ql.TokenLike, ql.TokenIN, ql.TokenBetween:
+			n = t.comparison(n, false)
+		case ql.TokenNegate:
+			//  NOT IN, NOT LIKE, NOT BETWEEN
+			t.Next()
+			switch tok := t.Peek(); tok.T {
+			case ql.TokenLike, ql.TokenIN, ql.TokenBetween:
+				n = t.comparison(n, true)
+			default:
+				t.unexpectedOf(tok, "not", ql.TokenIN, ql.TokenLike, ql.TokenBetween)
+			}
+		case ql.TokenIs:
+			//  IS NULL, IS NOT NULL
+			is := NewIsNullNode(Pos(t.Next().Pos), n)
+			if t.Peek().T == ql.TokenNegate {
+				t.Next()
+				is.Negated = true
+			}
+			t.expect(ql.TokenNull, "is")
+			n = is
 		default:
 			return n
 		}
 	}
 }
 
+// comparison parses the LIKE, IN or BETWEEN whose left side is n, which
+// is negated if it followed NOT
+//
+//    name LIKE "a!%%" ESCAPE "!"
+//    user_id IN (1, 2, 3)
+//    user_id IN (SELECT user_id FROM orders)
+//    ct BETWEEN 1 AND 10
+//
+func (t *Tree) comparison(n Node, negated bool) Node {
+	op := t.Next()
+	switch op.T {
+	case ql.TokenBetween:
+		lower := t.P()
+		t.expectOneOf(ql.TokenLogicAnd, ql.TokenAnd, "between")
+		tn := NewTriNode(op, n, lower, t.P())
+		tn.Negated = negated
+		tn.Collation = t.collation()
+		return tn
+	case ql.TokenIN:
+		paren := t.expect(ql.TokenLeftParenthesis, "in")
+		if t.Peek().T == ql.TokenSelect {
+			bn := t.binary(op, n, t.columnSubQuery(paren))
+			bn.Negated = negated
+			return bn
+		}
+		args := []Node{n}
+		for {
+			args = append(args, t.O())
+			if t.Peek().T != ql.TokenComma {
+				break
+			}
+			t.Next()
+		}
+		t.expect(ql.TokenRightParenthesis, "in")
+		mn := NewMultiArgNode(op, args...)
+		mn.Negated = negated
+		mn.Collation = t.collation()
+		return mn
+	}
+	bn := NewBinary(op, n, t.P())
+	bn.Negated = negated
+	if t.Peek().T == ql.TokenEscape {
+		t.Next()
+		tok := t.Peek()
+		if tok.T != ql.TokenValue {
+			t.unexpectedOf(tok, "escape", ql.TokenValue)
+		}
+		if utf8.RuneCountInString(tok.V) != 1 {
+			t.errorf("escape must be one character but got %q", tok.V)
+		}
+		bn.Escape = t.v().(*StringNode)
+	}
+	return bn
+}
+
 func (t *Tree) P() Node {
 	//u.Debugf("t.P: %v", t.Peek())
 	n := t.M()
@@ -305,13 +454,51 @@ func (t *Tree) P() Node {
 	for {
 		switch t.Peek().T {
 		case ql.TokenPlus, ql.TokenMinus:
-			n = NewBinary(t.Next(), n, t.M())
+			n = t.binary(t.Next(), n, t.M())
+		case ql.TokenOr:
+			//  first_name || ' ' || last_name   otherwise || is a logical or
+			if d := t.dialect(); d == nil || d.Concat != ql.ConcatPipes {
+				return n
+			}
+			n = t.binary(t.Next(), n, t.M())
 		default:
 			return n
 		}
 	}
 }
 
+// binary creates a binary node with how strings are concatenated and
+// compared in the dialect of the expression
+func (t *Tree) binary(op ql.Token, arg1, arg2 Node) *BinaryNode {
+	n := NewBinary(op, arg1, arg2)
+	n.Collation = t.collation()
+	if d := t.dialect(); d != nil {
+		switch op.T {
+		case ql.TokenOr:
+			n.Concat = d.Concat == ql.ConcatPipes
+		case ql.TokenPlus:
+			n.Concat = d.Concat == ql.ConcatPlus
+		}
+	}
+	return n
+}
+
+// how strings are compared in the dialect of the expression
+func (t *Tree) collation() ql.Collation {
+	if d := t.dialect(); d != nil {
+		return d.Collation
+	}
+	return ql.CollateBinary
+}
+
+// the dialect the expression was lexed in, nil if not known
+func (t *Tree) dialect() *ql.Dialect {
+	if l := t.Lexer(); l != nil {
+		return l.Dialect()
+	}
+	return nil
+}
+
 func (t *Tree) M() Node {
 	//u.Debugf("t.M: %v", t.Peek())
 	n := t.F()
@@ -335,12 +522,34 @@ func (t *Tree) F() Node {
 		return t.v()
 	case ql.TokenIdentity:
 		return t.v()
-	case ql.TokenValue:
+	case ql.TokenValue, ql.TokenParam:
 		return t.v()
-	case ql.TokenNegate, ql.TokenMinus:
+	case ql.TokenNegate:
+		//  NOT x = 5  is  NOT (x = 5),  but  !x == 5  is  (!x) == 5
+		if strings.EqualFold(t.Peek().V, "not") {
+			return NewUnary(t.Next(), t.C())
+		}
 		return NewUnary(t.Next(), t.F())
+	case ql.TokenMinus:
+		return NewUnary(t.Next(), t.F())
+	case ql.TokenNull:
+		return NewNullNode(Pos(t.Next().Pos))
+	case ql.TokenCase:
+		return t.caseExpr()
+	case ql.TokenExists:
+		t.Next()
+		t.expect(ql.TokenLeftParenthesis, "exists")
+		if t.Peek().T != ql.TokenSelect {
+			t.unexpectedOf(t.Peek(), "exists", ql.TokenSelect)
+		}
+		n := t.subQuery(token)
+		n.Exists = true
+		return n
 	case ql.TokenLeftParenthesis:
 		t.Next()
+		if t.Peek().T == ql.TokenSelect {
+			return t.columnSubQuery(token)
+		}
 		n := t.O()
 		if bn, ok := n.(*BinaryNode); ok {
 			bn.Paren = true
@@ -368,9 +577,24 @@ func (t *Tree) v() Node {
 		return n
 	case ql.TokenValue:
 		n := NewStringNode(Pos(token.Pos), token.V)
+		n.Quote = token.Quote
+		if d := t.dialect(); d != nil {
+			n.Escaping = d.StringEscaping
+		}
 		return n
 	case ql.TokenIdentity:
+		if token.Quote == 0 && strings.EqualFold(token.V, "null") {
+			// null lexed as an identity, SET x = null
+			return NewNullNode(Pos(token.Pos))
+		}
 		n := NewIdentityNode(Pos(token.Pos), token.V)
+		n.Quote = token.Quote
+		return n
+	case ql.TokenParam:
+		n, err := NewParamNode(Pos(token.Pos), token.V)
+		if err != nil {
+			t.error(err)
+		}
 		return n
 	case ql.TokenUdfExpr:
 		//u.Debugf("t.v calling Func()?: %v", token)
@@ -386,6 +610,60 @@ func (t *Tree) v() Node {
 	return nil
 }
 
+// caseExpr parses a searched, or simple CASE, up to and including its END
+//
+//    CASE WHEN ct > 10 THEN "many" ELSE "few" END
+//    CASE ct WHEN 1 THEN "one" WHEN 2 THEN "two" END
+//
+func (t *Tree) caseExpr() *CaseNode {
+	n := NewCaseNode(Pos(t.Next().Pos))
+	n.Collation = t.collation()
+	if t.Peek().T != ql.TokenWhen {
+		n.Operand = t.O()
+	}
+	for t.Peek().T == ql.TokenWhen {
+		t.Next()
+		n.Whens = append(n.Whens, t.O())
+		t.expect(ql.TokenThen, "case")
+		n.Thens = append(n.Thens, t.O())
+	}
+	if len(n.Whens) == 0 {
+		t.unexpectedOf(t.Peek(), "case", ql.TokenWhen)
+	}
+	if t.Peek().T == ql.TokenElse {
+		t.Next()
+		n.Else = t.O()
+	}
+	t.expect(ql.TokenEnd, "case")
+	return n
+}
+
+// a select within an expression, whose opening paren has been consumed,
+// parsed up to and including its closing paren
+func (t *Tree) subQuery(tok ql.Token) *SubQueryNode {
+	t.Next() // select
+	l := t.Lexer()
+	sub := &Sqlbridge{l: l, pager: NewSqlTokenPager(l), buildVm: t.runCheck}
+	sub.pager.end = ql.TokenRightParenthesis
+	sel, err := sub.parseSqlSelect()
+	if err != nil {
+		t.error(err)
+	}
+	if sub.curToken.T != ql.TokenRightParenthesis {
+		t.unexpectedOf(sub.curToken, "subquery", ql.TokenRightParenthesis)
+	}
+	return NewSubQueryNode(Pos(tok.Pos), sel)
+}
+
+// a subquery of a single column, a scalar or the list of values of IN
+func (t *Tree) columnSubQuery(tok ql.Token) *SubQueryNode {
+	n := t.subQuery(tok)
+	if len(n.Select.Columns) != 1 || n.Select.Columns[0].Star {
+		t.errorf("subquery must have one column: %s", n)
+	}
+	return n
+}
+
 func (t *Tree) Func(tok ql.Token) (fn *FuncNode) {
 	//u.Debugf("Func tok: %v peek:%v", tok, t.Peek())
 	var token ql.Token
@@ -399,6 +677,10 @@ func (t *Tree) Func(tok ql.Token) (fn *FuncNode) {
 	//var err error
 
 	funcImpl, ok := t.getFunction(token.V)
+	if !ok && isAggregate(token.V) {
+		// scalar funcs of the same name take precedence
+		return t.aggFunc(token)
+	}
 	if !ok {
 		if t.runCheck {
 			u.Warnf("non func? %v", token.V)
@@ -468,6 +750,27 @@ func (t *Tree) Func(tok ql.Token) (fn *FuncNode) {
 	}
 }
 
+// an aggregate func, which has a single argument, or * for count(*)
+func (t *Tree) aggFunc(token ql.Token) *FuncNode {
+	fn := NewFuncNode(Pos(token.Pos), token.V, Func{Name: token.V})
+	fn.Agg = true
+	fn.Collation = t.collation()
+	t.expect(ql.TokenLeftParenthesis, "func")
+	switch t.Peek().T {
+	case ql.TokenStar, ql.TokenMultiply:
+		t.Next()
+	case ql.TokenRightParenthesis:
+	default:
+		fn.append(t.O())
+		for t.Peek().T == ql.TokenComma {
+			t.Next()
+			fn.append(t.O())
+		}
+	}
+	t.expect(ql.TokenRightParenthesis, "func")
+	return fn
+}
+
 // get Function from Global
 func (t *Tree) getFunction(name string) (v Func, ok bool) {
 	if v, ok = funcs[strings.ToLower(name)]; ok {
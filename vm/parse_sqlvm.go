@@ -2,8 +2,8 @@ package vm
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -13,34 +13,110 @@ import (
 
 type SqlStatement interface {
 	Keyword() ql.TokenType
+	Span() *SourceSpan
+}
+
+// SourceSpan is the location of a statement in the original source text,
+// Start, End are byte offsets so text[Start:End] is the statement (without
+// the trailing semi-colon), Line, Column are 1 based
+type SourceSpan struct {
+	Start  int
+	End    int
+	Line   int
+	Column int
+}
+
+func (m *SourceSpan) Span() *SourceSpan { return m }
+
+// the source text of this statement
+func (m *SourceSpan) Source(text string) string {
+	if m.Start < 0 || m.End > len(text) || m.Start > m.End {
+		return ""
+	}
+	return text[m.Start:m.End]
 }
 
 type SqlSelect struct {
-	Star    bool
-	Columns Columns
-	From    string
-	Where   *Tree
-	Limit   int
+	SourceSpan
+	Star     bool
+	Columns  Columns
+	From     string      // the first table, joined to any Joins
+	Alias    string      // FROM users AS u
+	SubQuery *SqlSelect  // derived table, FROM (SELECT ...) AS t, whose From is the alias
+	Joins    []*JoinExpr // FROM users u JOIN orders o ON u.id = o.user_id
+	Where    *Tree
+	GroupBy  Columns
+	Having   *Tree
+	OrderBy  []*OrderByColumn
+	Limit    int
 }
 type SqlInsert struct {
-	Columns Columns
-	Rows    [][]Value
-	Into    string
+	SourceSpan
+	kw          ql.TokenType // Insert, Upsert
+	Columns     Columns
+	Rows        [][]Value
+	Into        string
+	Select      *SqlSelect   // INSERT INTO t (a, b) SELECT ..., instead of Rows
+	OnDuplicate Columns      // ON DUPLICATE KEY UPDATE name = expr
+	exprs       []insertExpr // expressions, params in Rows, which are Nil until evaluated
+}
+
+// an expression in the values of an insert, such as now() or a bind param,
+// evaluated for each execution
+type insertExpr struct {
+	row, col int
+	node     Node
 }
 type SqlUpdate struct {
+	SourceSpan
 	kw      ql.TokenType // Update, Upsert
-	Columns Columns
-	From    string
+	Columns Columns      // SET name = expr, Tree is the expression of the new value
+	Table   string
+	Where   *Tree
+	Limit   int
 }
 type SqlDelete struct {
+	SourceSpan
 	Table string
 	Where *Tree
 	Limit int
 }
+type SqlCreate struct {
+	SourceSpan
+	Table      string
+	Columns    []*ColumnDef
+	PrimaryKey []string
+}
+type SqlDrop struct {
+	SourceSpan
+	Table string
+}
+type SqlAlter struct {
+	SourceSpan
+	Table   string
+	Changes []*AlterColumn
+}
+
+// AlterColumn is one ADD, CHANGE or DROP column of an alter table
+//
+//    ADD email VARCHAR(255) AFTER name
+//    CHANGE bio biography TEXT FIRST
+//    DROP COLUMN ct
+//
+type AlterColumn struct {
+	Op     ql.TokenType // TokenAdd, TokenChange, TokenDrop
+	Name   string       // the existing column of a change, drop
+	Column *ColumnDef   // the new definition of an add, change
+	First  bool         // FIRST
+	After  string       // AFTER name
+}
 type SqlShow struct {
-	Identity string
+	SourceSpan
+	Identity string // tables, columns, functions, variables
+	From     string // show columns from table
 }
 type SqlDescribe struct {
+	SourceSpan
 	Identity string
 }
 
@@ -50,7 +126,7 @@ func NewSqlSelect() *SqlSelect {
 	return req
 }
 func NewSqlInsert() *SqlInsert {
-	req := &SqlInsert{}
+	req := &SqlInsert{kw: ql.TokenInsert}
 	req.Columns = make(Columns, 0)
 	return req
 }
@@ -64,21 +140,115 @@ func NewSqlDelete() *SqlDelete {
 }
 
 func (m *SqlSelect) Keyword() ql.TokenType   { return ql.TokenSelect }
-func (m *SqlInsert) Keyword() ql.TokenType   { return ql.TokenInsert }
+func (m *SqlInsert) Keyword() ql.TokenType   { return m.kw }
 func (m *SqlUpdate) Keyword() ql.TokenType   { return m.kw }
 func (m *SqlDelete) Keyword() ql.TokenType   { return ql.TokenDelete }
+func (m *SqlCreate) Keyword() ql.TokenType   { return ql.TokenCreate }
+func (m *SqlDrop) Keyword() ql.TokenType     { return ql.TokenDrop }
+func (m *SqlAlter) Keyword() ql.TokenType    { return ql.TokenAlter }
 func (m *SqlDescribe) Keyword() ql.TokenType { return ql.TokenDescribe }
 func (m *SqlShow) Keyword() ql.TokenType     { return ql.TokenShow }
 
 func (m *SqlSelect) String() string {
 	buf := bytes.Buffer{}
-	buf.WriteString(fmt.Sprintf("SELECT %s FROM %s", m.Columns, m.From))
+	if m.SubQuery != nil {
+		buf.WriteString(fmt.Sprintf("SELECT %s FROM (%s)", m.Columns, m.SubQuery))
+	} else {
+		buf.WriteString(fmt.Sprintf("SELECT %s FROM %s", m.Columns, m.From))
+	}
+	if m.Alias != "" {
+		buf.WriteString(" AS " + m.Alias)
+	}
+	for _, join := range m.Joins {
+		buf.WriteString(" " + join.String())
+	}
 	if m.Where != nil {
 		buf.WriteString(fmt.Sprintf(" WHERE %s ", m.Where.String()))
 	}
+	if len(m.GroupBy) > 0 {
+		buf.WriteString(fmt.Sprintf(" GROUP BY %s", m.GroupBy.String()))
+	}
+	if m.Having != nil {
+		buf.WriteString(fmt.Sprintf(" HAVING %s", m.Having.String()))
+	}
+	if len(m.OrderBy) > 0 {
+		s := make([]string, len(m.OrderBy))
+		for i, ob := range m.OrderBy {
+			s[i] = ob.String()
+		}
+		buf.WriteString(fmt.Sprintf(" ORDER BY %s", strings.Join(s, ", ")))
+	}
 	return buf.String()
 }
 
+// OrderByColumn is an expression to sort on in [ORDER BY]
+//
+//    ORDER BY toint(ct) DESC NULLS LAST
+//
+// Nulls sort as the smallest value unless NULLS FIRST|LAST was given, so are
+// first when ascending, last when descending
+type OrderByColumn struct {
+	Tree       *Tree
+	Desc       bool
+	NullsFirst bool
+	Collation  ql.Collation // how strings are ordered in the dialect
+}
+
+func (m *OrderByColumn) String() string {
+	s := m.Tree.Root.StringAST()
+	if m.Desc {
+		s += " DESC"
+	}
+	if m.NullsFirst == m.Desc {
+		// not the default, so was explicit
+		if m.NullsFirst {
+			s += " NULLS FIRST"
+		} else {
+			s += " NULLS LAST"
+		}
+	}
+	return s
+}
+
+// JoinExpr is a table joined to the tables before it in [FROM], an
+//  INNER, LEFT [OUTER] or CROSS join, where each row of the tables before
+//  is combined with the rows of this table which match the ON condition
+//
+//    FROM users u LEFT OUTER JOIN orders o ON u.id = o.user_id
+//
+type JoinExpr struct {
+	Kind  ql.TokenType // TokenInner, TokenLeft, TokenCross
+	Table string
+	Alias string
+	On    *Tree // nil for a cross join
+}
+
+// Name the columns of this table are qualified by, its alias, or table name
+func (m *JoinExpr) Name() string {
+	if m.Alias != "" {
+		return m.Alias
+	}
+	return m.Table
+}
+
+func (m *JoinExpr) String() string {
+	kw := "JOIN"
+	switch m.Kind {
+	case ql.TokenLeft:
+		kw = "LEFT OUTER JOIN"
+	case ql.TokenCross:
+		kw = "CROSS JOIN"
+	}
+	s := fmt.Sprintf("%s %s", kw, m.Table)
+	if m.Alias != "" {
+		s += " AS " + m.Alias
+	}
+	if m.On != nil {
+		s += " ON " + m.On.Root.StringAST()
+	}
+	return s
+}
+
 // Array of Columns
 type Columns []*Column
 
@@ -120,14 +290,73 @@ type Column struct {
 func (m *Column) Key() string    { return m.As }
 func (m *Column) String() string { return m.As }
 
-// Parses ql.Tokens and returns an request.
+// ColumnDef is the definition of a column of a create table
+//
+//    name VARCHAR(255) NOT NULL DEFAULT "anon"
+//
+type ColumnDef struct {
+	Name    string
+	Type    ql.TokenType // TokenText, TokenVarChar, TokenBigInt
+	Size    int          // varchar(255), 0 if not given
+	NotNull bool
+	Default *Tree
+	Charset string // CHARACTER SET utf8
+}
+
+func (m *ColumnDef) Key() string { return m.Name }
+func (m *ColumnDef) String() string {
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("%s %s", m.Name, m.TypeString()))
+	if m.NotNull {
+		buf.WriteString(" NOT NULL")
+	}
+	if m.Default != nil {
+		buf.WriteString(fmt.Sprintf(" DEFAULT %s", m.Default.Root.StringAST()))
+	}
+	if m.Charset != "" {
+		buf.WriteString(fmt.Sprintf(" CHARACTER SET %s", m.Charset))
+	}
+	return buf.String()
+}
+
+// the declared type, VARCHAR(255)
+func (m *ColumnDef) TypeString() string {
+	if m.Size > 0 {
+		return fmt.Sprintf("%s(%d)", strings.ToUpper(m.Type.String()), m.Size)
+	}
+	return strings.ToUpper(m.Type.String())
+}
+
+// the type of values of this column
+func (m *ColumnDef) ValueType() ValueType {
+	if m.Type == ql.TokenBigInt {
+		return IntType
+	}
+	return StringType
+}
+
+// Parses ql.Tokens and returns an request.  Only a single statement is
+// allowed, use ParseScript or ScriptScanner for multiple statements.
 func ParseSql(sqlQuery string) (SqlStatement, error) {
-	l := ql.NewSqlLexer(sqlQuery)
+	return ParseSqlDialect(sqlQuery, ql.SqlDialect)
+}
+func ParseSqlVm(sqlQuery string) (SqlStatement, error) {
+	return ParseSqlVmDialect(sqlQuery, ql.SqlDialect)
+}
+
+// Parses a single statement in a Dialect, whose rules for strings, such as
+// how they are concatenated and compared, differ from the default SqlDialect
+//
+//    ansi := &ql.Dialect{Statements: ql.SqlDialect.Statements, Concat: ql.ConcatPipes}
+//    ParseSqlDialect(`SELECT first_name || " " || last_name AS name FROM users`, ansi)
+//
+func ParseSqlDialect(sqlQuery string, dialect *ql.Dialect) (SqlStatement, error) {
+	l := ql.NewLexer(sqlQuery, dialect)
 	p := Sqlbridge{l: l, pager: NewSqlTokenPager(l), buildVm: false}
 	return p.parse()
 }
-func ParseSqlVm(sqlQuery string) (SqlStatement, error) {
-	l := ql.NewSqlLexer(sqlQuery)
+func ParseSqlVmDialect(sqlQuery string, dialect *ql.Dialect) (SqlStatement, error) {
+	l := ql.NewLexer(sqlQuery, dialect)
 	p := Sqlbridge{l: l, pager: NewSqlTokenPager(l)}
 	return p.parse()
 }
@@ -142,25 +371,174 @@ type Sqlbridge struct {
 	curToken   ql.Token
 }
 
-// parse the request
+// parse the request, which must be a single statement
 func (m *Sqlbridge) parse() (SqlStatement, error) {
 	m.firstToken = m.l.NextToken()
+	for m.firstToken.T.IsComment() {
+		m.firstToken = m.l.NextToken()
+	}
+	stmt, err := m.parseStatement()
+	if err != nil || m.curToken.T != ql.TokenEOS {
+		return stmt, err
+	}
+	// only comments, or empty statements may follow the ;
+	for tok := m.l.NextToken(); tok.T != ql.TokenEOF; tok = m.l.NextToken() {
+		if tok.T != ql.TokenEOS && !tok.T.IsComment() {
+			return nil, m.unexpected(tok, "expected a single statement but got %v, use ParseScript for multiple statements", tok.V)
+		}
+	}
+	return stmt, nil
+}
+
+// parse the statement starting at firstToken, afterwards curToken is the
+// first token after the statement (EOS, EOF)
+func (m *Sqlbridge) parseStatement() (SqlStatement, error) {
 	//u.Info(m.firstToken)
 	switch m.firstToken.T {
 	case ql.TokenSelect:
-		return m.parseSqlSelect()
-	case ql.TokenInsert:
-		return m.parseSqlInsert()
+		return m.spanned(m.parseSqlSelect())
+	case ql.TokenInsert, ql.TokenUpsert:
+		return m.spanned(m.parseSqlInsert())
 	case ql.TokenDelete:
-		return m.parseSqlDelete()
-		// case ql.TokenTypeSqlUpdate:
-		// 	return this.parseSqlUpdate()
+		return m.spanned(m.parseSqlDelete())
+	case ql.TokenUpdate:
+		return m.spanned(m.parseSqlUpdate())
+	case ql.TokenCreate:
+		return m.spanned(m.parseSqlCreate())
+	case ql.TokenDrop:
+		return m.spanned(m.parseSqlDrop())
+	case ql.TokenAlter:
+		return m.spanned(m.parseSqlAlter())
 	case ql.TokenShow:
-		return m.parseShow()
+		return m.spanned(m.parseShow())
 	case ql.TokenDescribe:
-		return m.parseDescribe()
+		return m.spanned(m.parseDescribe())
+	}
+	return nil, m.unexpected(m.firstToken, "Unrecognized request type %v", m.firstToken.V)
+}
+
+// number the ? params of a statement in order of appearance, it is
+// an error to mix ? and $1 params as their numbering would collide
+func (m *Sqlbridge) numberParams(stmt SqlStatement) error {
+	var numbered *ParamNode
+	idx := 0
+	for _, p := range statementParams(stmt) {
+		switch {
+		case p.Text == "?":
+			p.Index = idx
+			idx++
+		case p.Positional():
+			numbered = p
+		}
+		if idx > 0 && numbered != nil {
+			tok := ql.Token{T: ql.TokenParam, V: numbered.Text, Pos: int(numbered.Pos)}
+			return m.unexpected(tok, "cannot mix ? and %v params", numbered.Text)
+		}
+	}
+	return nil
+}
+
+// statementParams finds all of the ParamNode placeholders in a statement
+// ordered by position
+func statementParams(stmt SqlStatement) []*ParamNode {
+	params := make([]*ParamNode, 0)
+	collect := func(tree *Tree) {
+		if tree == nil || tree.Root == nil {
+			return
+		}
+		Walk(tree.Root, func(n Node) {
+			switch nt := n.(type) {
+			case *ParamNode:
+				params = append(params, nt)
+			case *SubQueryNode:
+				params = append(params, statementParams(nt.Select)...)
+			}
+		})
+	}
+	switch v := stmt.(type) {
+	case *SqlSelect:
+		for _, col := range v.Columns {
+			collect(col.Tree)
+			collect(col.Guard)
+		}
+		if v.SubQuery != nil {
+			params = append(params, statementParams(v.SubQuery)...)
+		}
+		for _, join := range v.Joins {
+			collect(join.On)
+		}
+		collect(v.Where)
+		for _, col := range v.GroupBy {
+			collect(col.Tree)
+		}
+		collect(v.Having)
+		for _, ob := range v.OrderBy {
+			collect(ob.Tree)
+		}
+	case *SqlInsert:
+		for _, ie := range v.exprs {
+			collect(&Tree{Root: ie.node})
+		}
+		if v.Select != nil {
+			params = append(params, statementParams(v.Select)...)
+		}
+		for _, col := range v.OnDuplicate {
+			collect(col.Tree)
+		}
+	case *SqlUpdate:
+		for _, col := range v.Columns {
+			collect(col.Tree)
+		}
+		collect(v.Where)
+	case *SqlDelete:
+		collect(v.Where)
 	}
-	return nil, fmt.Errorf("Unrecognized request type")
+	sort.Sort(paramsByPos(params))
+	return params
+}
+
+type paramsByPos []*ParamNode
+
+func (m paramsByPos) Len() int           { return len(m) }
+func (m paramsByPos) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m paramsByPos) Less(i, j int) bool { return m[i].Pos < m[j].Pos }
+
+// spanned finishes a parsed statement, numbering its params and recording
+// its source span, from the first token up to the token that ended it
+func (m *Sqlbridge) spanned(stmt SqlStatement, err error) (SqlStatement, error) {
+	if err != nil || stmt == nil {
+		return nil, err
+	}
+	if err := m.numberParams(stmt); err != nil {
+		return nil, err
+	}
+	input := m.l.Input()
+	end := len(input)
+	switch m.curToken.T {
+	case ql.TokenEOS, ql.TokenEOF:
+		if m.curToken.Pos < end {
+			end = m.curToken.Pos
+		}
+	}
+	start := m.firstToken.Pos
+	if end < start {
+		end = start
+	}
+	end = start + len(strings.TrimRight(input[start:end], " \t\r\n"))
+	span := stmt.Span()
+	span.Start, span.End = start, end
+	span.Line, span.Column = m.l.LineColumn(start)
+	return stmt, nil
+}
+
+// unexpected creates a ParseError for the given token, expected is the list
+// of tokens that would have been valid in its place
+func (m *Sqlbridge) unexpected(tok ql.Token, format string, args ...interface{}) error {
+	return m.unexpectedOf(tok, nil, format, args...)
+}
+
+func (m *Sqlbridge) unexpectedOf(tok ql.Token, expected []ql.TokenType, format string, args ...interface{}) error {
+	return newParseError(m.l, tok, expected, fmt.Sprintf(format, args...))
 }
 
 // First keyword was SELECT, so use the SELECT parser rule-set
@@ -182,12 +560,10 @@ func (m *Sqlbridge) parseSqlSelect() (*SqlSelect, error) {
 
 	// select @@myvar limit 1
 	if m.curToken.T == ql.TokenLimit {
-		if err := m.parseLimit(req); err != nil {
-			return req, nil
-		}
-		if m.isEnd() {
-			return req, nil
+		if err := m.parseLimit(&req.Limit); err != nil {
+			return nil, err
 		}
+		return req, nil
 	}
 
 	// SPECIAL END CASE for simple selects
@@ -200,31 +576,59 @@ func (m *Sqlbridge) parseSqlSelect() (*SqlSelect, error) {
 	// FROM
 	//u.Debugf("token:  %#v", m.curToken)
 	if m.curToken.T != ql.TokenFrom {
-		return nil, fmt.Errorf("expected From but got: %v", m.curToken)
+		return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenFrom}, "expected From but got: %v", m.curToken.V)
 	} else {
 		// table name
 		m.curToken = m.l.NextToken()
 		//u.Debugf("found from?  %#v  %s", m.curToken, m.curToken.T.String())
-		if m.curToken.T != ql.TokenIdentity && m.curToken.T != ql.TokenValue {
+		if m.curToken.T == ql.TokenLeftParenthesis {
+			if err := m.parseDerivedTable(req); err != nil {
+				return nil, err
+			}
+		} else if m.curToken.T != ql.TokenIdentity && m.curToken.T != ql.TokenValue {
 			//u.Warnf("No From? %v toktype:%v", m.curToken.V, m.curToken.T.String())
-			return nil, errors.New("expected from name")
+			return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity}, "expected from name")
 		} else {
 			req.From = m.curToken.V
 		}
 	}
 
-	// WHERE
+	// alias, JOIN
 	m.curToken = m.l.NextToken()
+	if err := m.parseJoins(req); err != nil {
+		return nil, err
+	}
+	if req.SubQuery != nil {
+		if req.Alias == "" {
+			return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenAs}, "derived table must have an alias")
+		}
+		req.From = req.Alias
+	}
+
+	// WHERE
 	//u.Debugf("cur ql.Token: %s", m.curToken.T.String())
 	if errreq := m.parseWhere(req); errreq != nil {
 		return nil, errreq
 	}
 
-	// TODO ORDER BY
+	// GROUP BY
+	if err := m.parseGroupBy(req); err != nil {
+		return nil, err
+	}
+
+	// HAVING
+	if err := m.parseHaving(req); err != nil {
+		return nil, err
+	}
+
+	// ORDER BY
+	if err := m.parseOrderBy(req); err != nil {
+		return nil, err
+	}
 
 	// LIMIT
-	if err := m.parseLimit(req); err != nil {
-		return req, nil
+	if err := m.parseLimit(&req.Limit); err != nil {
+		return nil, err
 	}
 
 	// we are good
@@ -235,13 +639,15 @@ func (m *Sqlbridge) parseSqlSelect() (*SqlSelect, error) {
 func (m *Sqlbridge) parseSqlInsert() (*SqlInsert, error) {
 
 	// insert into mytable (id, str) values (0, "a")
+	// upsert into mytable (id, str) values (0, "a")
 	req := NewSqlInsert()
+	req.kw = m.firstToken.T
 	m.curToken = m.l.NextToken()
 
 	// into
 	//u.Debugf("token:  %v", m.curToken)
 	if m.curToken.T != ql.TokenInto {
-		return nil, fmt.Errorf("expected INTO but got: %v", m.curToken)
+		return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenInto}, "expected INTO but got: %v", m.curToken.V)
 	} else {
 		// table name
 		m.curToken = m.l.NextToken()
@@ -250,7 +656,7 @@ func (m *Sqlbridge) parseSqlInsert() (*SqlInsert, error) {
 		case ql.TokenTable:
 			req.Into = m.curToken.V
 		default:
-			return nil, fmt.Errorf("expected table name but got : %v", m.curToken.V)
+			return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenTable}, "expected table name but got : %v", m.curToken.V)
 		}
 	}
 
@@ -265,13 +671,32 @@ func (m *Sqlbridge) parseSqlInsert() (*SqlInsert, error) {
 	switch m.curToken.T {
 	case ql.TokenValues:
 		m.curToken = m.l.NextToken()
+		if err := m.parseValueList(req); err != nil {
+			u.Error(err)
+			return nil, err
+		}
+	case ql.TokenSelect:
+		// insert into mytable (id, str) select id, name from users
+		sel, err := m.parseSqlSelect()
+		if err != nil {
+			return nil, err
+		}
+		if !sel.Star && len(sel.Columns) != len(req.Columns) {
+			return nil, m.unexpected(m.firstToken, "insert has %d columns but select has %d",
+				len(req.Columns), len(sel.Columns))
+		}
+		req.Select = sel
 	default:
-		return nil, fmt.Errorf("expected values but got : %v", m.curToken.V)
+		return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenValues, ql.TokenSelect},
+			"expected values but got : %v", m.curToken.V)
 	}
-	//u.Debugf("found ?  %v", m.curToken)
-	if err := m.parseValueList(req); err != nil {
-		u.Error(err)
-		return nil, err
+	if m.curToken.T == ql.TokenDuplicateKeyUpdate {
+		if req.kw == ql.TokenUpsert {
+			return nil, m.unexpected(m.curToken, "upsert may not have ON DUPLICATE KEY UPDATE")
+		}
+		if err := m.parseSetList(&req.OnDuplicate); err != nil {
+			return nil, err
+		}
 	}
 	// we are good
 	return req, nil
@@ -286,7 +711,7 @@ func (m *Sqlbridge) parseSqlDelete() (*SqlDelete, error) {
 	// from
 	u.Debugf("token:  %v", m.curToken)
 	if m.curToken.T != ql.TokenFrom {
-		return nil, fmt.Errorf("expected FROM but got: %v", m.curToken)
+		return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenFrom}, "expected FROM but got: %v", m.curToken.V)
 	} else {
 		// table name
 		m.curToken = m.l.NextToken()
@@ -295,7 +720,7 @@ func (m *Sqlbridge) parseSqlDelete() (*SqlDelete, error) {
 		case ql.TokenTable:
 			req.Table = m.curToken.V
 		default:
-			return nil, fmt.Errorf("expected table name but got : %v", m.curToken.V)
+			return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenTable}, "expected table name but got : %v", m.curToken.V)
 		}
 	}
 
@@ -308,6 +733,317 @@ func (m *Sqlbridge) parseSqlDelete() (*SqlDelete, error) {
 	return req, nil
 }
 
+// First keyword was UPDATE
+func (m *Sqlbridge) parseSqlUpdate() (*SqlUpdate, error) {
+
+	req := NewSqlUpdate()
+	m.curToken = m.l.NextToken()
+
+	// table name
+	if m.curToken.T != ql.TokenTable {
+		return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenTable}, "expected table name but got : %v", m.curToken.V)
+	}
+	req.Table = m.curToken.V
+
+	// SET
+	m.curToken = m.l.NextToken()
+	if m.curToken.T != ql.TokenSet {
+		return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenSet}, "expected SET but got: %v", m.curToken.V)
+	}
+	if err := m.parseSetList(&req.Columns); err != nil {
+		return nil, err
+	}
+
+	// WHERE
+	if err := m.parseWhereUpdate(req); err != nil {
+		return nil, err
+	}
+
+	// LIMIT
+	if err := m.parseLimit(&req.Limit); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// First keyword was CREATE
+func (m *Sqlbridge) parseSqlCreate() (*SqlCreate, error) {
+
+	// create table users (user_id bigint not null, name varchar(255), primary key (user_id))
+	req := &SqlCreate{}
+	m.curToken = m.l.NextToken()
+	if m.curToken.T != ql.TokenTable {
+		return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenTable}, "expected TABLE but got: %v", m.curToken.V)
+	}
+	m.curToken = m.l.NextToken()
+	if m.curToken.T != ql.TokenIdentity {
+		return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity}, "expected table name but got: %v", m.curToken.V)
+	}
+	req.Table = m.curToken.V
+	m.curToken = m.l.NextToken()
+	if m.curToken.T != ql.TokenLeftParenthesis {
+		return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenLeftParenthesis}, "expected ( but got: %v", m.curToken.V)
+	}
+	m.curToken = m.l.NextToken()
+
+	// defaults end at the comma, or paren closing the definitions
+	m.pager.end = ql.TokenRightParenthesis
+	defer func() { m.pager.end = ql.TokenNil }()
+	var keyTokens []ql.Token
+	for {
+		switch m.curToken.T {
+		case ql.TokenPrimaryKey:
+			if len(req.PrimaryKey) > 0 {
+				return nil, m.unexpected(m.curToken, "multiple primary keys")
+			}
+			if keyTokens = m.parsePrimaryKey(); keyTokens == nil {
+				return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity}, "expected primary key column but got: %v", m.curToken.V)
+			}
+			for _, tok := range keyTokens {
+				req.PrimaryKey = append(req.PrimaryKey, tok.V)
+			}
+		case ql.TokenIdentity:
+			for _, col := range req.Columns {
+				if strings.EqualFold(col.Name, m.curToken.V) {
+					return nil, m.unexpected(m.curToken, "duplicate column %v", m.curToken.V)
+				}
+			}
+			col, err := m.parseColumnDef()
+			if err != nil {
+				return nil, err
+			}
+			req.Columns = append(req.Columns, col)
+		default:
+			return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity, ql.TokenPrimaryKey}, "expected column definition but got: %v", m.curToken.V)
+		}
+
+		switch m.curToken.T {
+		case ql.TokenComma:
+			m.curToken = m.l.NextToken()
+		case ql.TokenRightParenthesis:
+			m.curToken = m.l.NextToken()
+			// primary key columns must be defined
+			for _, tok := range keyTokens {
+				if !hasColumnDef(req.Columns, tok.V) {
+					return nil, m.unexpected(tok, "primary key column %v is not defined", tok.V)
+				}
+			}
+			return req, nil
+		default:
+			return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenComma, ql.TokenRightParenthesis}, "expected , or ) but got: %v", m.curToken.V)
+		}
+	}
+}
+
+func hasColumnDef(cols []*ColumnDef, name string) bool {
+	for _, col := range cols {
+		if strings.EqualFold(col.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseColumnDef parses the definition of a column, the current token is
+// its name
+//
+//    name VARCHAR(255) NOT NULL DEFAULT "anon"
+//
+func (m *Sqlbridge) parseColumnDef() (*ColumnDef, error) {
+
+	col := &ColumnDef{Name: m.curToken.V}
+	m.curToken = m.l.NextToken()
+	switch m.curToken.T {
+	case ql.TokenText, ql.TokenBigInt, ql.TokenVarChar:
+		col.Type = m.curToken.T
+	default:
+		return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenText, ql.TokenVarChar, ql.TokenBigInt},
+			"expected data type but got: %v", m.curToken.V)
+	}
+	m.curToken = m.l.NextToken()
+	if col.Type == ql.TokenVarChar && m.curToken.T == ql.TokenLeftParenthesis {
+		// varchar(255)
+		m.curToken = m.l.NextToken()
+		size, err := strconv.Atoi(m.curToken.V)
+		if m.curToken.T != ql.TokenInteger || err != nil || size <= 0 {
+			return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenInteger}, "expected varchar size but got: %v", m.curToken.V)
+		}
+		col.Size = size
+		m.curToken = m.l.NextToken()
+		if m.curToken.T != ql.TokenRightParenthesis {
+			return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenRightParenthesis}, "expected ) but got: %v", m.curToken.V)
+		}
+		m.curToken = m.l.NextToken()
+	}
+	for {
+		switch m.curToken.T {
+		case ql.TokenNotNull:
+			col.NotNull = true
+			m.curToken = m.l.NextToken()
+		case ql.TokenDefault:
+			defTok := m.l.NextToken()
+			m.curToken = defTok
+			tree := NewTree(m.pager)
+			m.pager.columnDef = true
+			err := m.parseNode(tree)
+			m.pager.columnDef = false
+			if err != nil {
+				return nil, err
+			}
+			if v, ok := literalValue(tree.Root); ok && col.Type == ql.TokenBigInt {
+				if _, isInt := intOf(v); !isInt {
+					return nil, m.unexpected(defTok, "default of bigint column %v must be an integer", col.Name)
+				}
+			}
+			col.Default = tree
+		case ql.TokenCharacterSet:
+			if err := m.parseCharset(col); err != nil {
+				return nil, err
+			}
+		default:
+			return col, nil
+		}
+	}
+}
+
+func (m *Sqlbridge) parseCharset(col *ColumnDef) error {
+	m.curToken = m.l.NextToken()
+	if m.curToken.T != ql.TokenIdentity {
+		return m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity}, "expected character set but got: %v", m.curToken.V)
+	}
+	col.Charset = m.curToken.V
+	m.curToken = m.l.NextToken()
+	return nil
+}
+
+// parsePrimaryKey parses the column list of a primary key, nil if
+// there isn't one
+//
+//    PRIMARY KEY (user_id, ts)
+//
+func (m *Sqlbridge) parsePrimaryKey() []ql.Token {
+	m.curToken = m.l.NextToken()
+	if m.curToken.T != ql.TokenLeftParenthesis {
+		return nil
+	}
+	var cols []ql.Token
+	for {
+		m.curToken = m.l.NextToken()
+		if m.curToken.T != ql.TokenIdentity {
+			return nil
+		}
+		cols = append(cols, m.curToken)
+		m.curToken = m.l.NextToken()
+		switch m.curToken.T {
+		case ql.TokenComma:
+		case ql.TokenRightParenthesis:
+			m.curToken = m.l.NextToken()
+			return cols
+		default:
+			return nil
+		}
+	}
+}
+
+// First keyword was DROP
+func (m *Sqlbridge) parseSqlDrop() (*SqlDrop, error) {
+
+	// drop table users
+	req := &SqlDrop{}
+	m.curToken = m.l.NextToken()
+	if m.curToken.T != ql.TokenTable {
+		return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenTable}, "expected TABLE but got: %v", m.curToken.V)
+	}
+	m.curToken = m.l.NextToken()
+	if m.curToken.T != ql.TokenIdentity {
+		return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity}, "expected table name but got: %v", m.curToken.V)
+	}
+	req.Table = m.curToken.V
+	m.curToken = m.l.NextToken()
+	return req, nil
+}
+
+// First keyword was ALTER
+func (m *Sqlbridge) parseSqlAlter() (*SqlAlter, error) {
+
+	// alter table users add email text after name, change bio biography text, drop column ct
+	req := &SqlAlter{}
+	m.curToken = m.l.NextToken()
+	if m.curToken.T != ql.TokenTable {
+		return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenTable}, "expected TABLE but got: %v", m.curToken.V)
+	}
+	m.curToken = m.l.NextToken()
+	if m.curToken.T != ql.TokenIdentity {
+		return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity}, "expected table name but got: %v", m.curToken.V)
+	}
+	req.Table = m.curToken.V
+	m.curToken = m.l.NextToken()
+
+	for {
+		alt := &AlterColumn{Op: m.curToken.T}
+		switch m.curToken.T {
+		case ql.TokenAdd, ql.TokenChange, ql.TokenDrop:
+		default:
+			return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenAdd, ql.TokenChange, ql.TokenDrop},
+				"expected ADD, CHANGE or DROP but got: %v", m.curToken.V)
+		}
+		m.curToken = m.l.NextToken()
+		if m.curToken.T == ql.TokenColumn {
+			m.curToken = m.l.NextToken()
+		}
+		if m.curToken.T != ql.TokenIdentity {
+			return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity}, "expected column but got: %v", m.curToken.V)
+		}
+		alt.Name = m.curToken.V
+		if alt.Op == ql.TokenChange {
+			// change old_name new_name TEXT
+			m.curToken = m.l.NextToken()
+			if m.curToken.T != ql.TokenIdentity {
+				return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity}, "expected new column name but got: %v", m.curToken.V)
+			}
+		}
+		if alt.Op == ql.TokenDrop {
+			m.curToken = m.l.NextToken()
+		} else {
+			col, err := m.parseColumnDef()
+			if err != nil {
+				return nil, err
+			}
+			alt.Column = col
+			if err := m.parseColumnPosition(alt); err != nil {
+				return nil, err
+			}
+		}
+		req.Changes = append(req.Changes, alt)
+
+		if m.curToken.T != ql.TokenComma {
+			return req, nil
+		}
+		m.curToken = m.l.NextToken()
+	}
+}
+
+// the optional FIRST or AFTER name of an added or changed column, which
+// may be followed by its character set
+func (m *Sqlbridge) parseColumnPosition(alt *AlterColumn) error {
+	switch m.curToken.T {
+	case ql.TokenFirst:
+		alt.First = true
+		m.curToken = m.l.NextToken()
+	case ql.TokenAfter:
+		m.curToken = m.l.NextToken()
+		if m.curToken.T != ql.TokenIdentity {
+			return m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity}, "expected column but got: %v", m.curToken.V)
+		}
+		alt.After = m.curToken.V
+		m.curToken = m.l.NextToken()
+	}
+	if m.curToken.T == ql.TokenCharacterSet {
+		return m.parseCharset(alt.Column)
+	}
+	return nil
+}
+
 // First keyword was DESCRIBE
 func (m *Sqlbridge) parseDescribe() (*SqlDescribe, error) {
 
@@ -316,9 +1052,10 @@ func (m *Sqlbridge) parseDescribe() (*SqlDescribe, error) {
 
 	u.Debugf("token:  %v", m.curToken)
 	if m.curToken.T != ql.TokenIdentity {
-		return nil, fmt.Errorf("expected idenity but got: %v", m.curToken)
+		return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity}, "expected idenity but got: %v", m.curToken.V)
 	}
 	req.Identity = m.curToken.V
+	m.curToken = m.l.NextToken()
 	return req, nil
 }
 
@@ -330,9 +1067,20 @@ func (m *Sqlbridge) parseShow() (*SqlShow, error) {
 
 	//u.Debugf("token:  %v", m.curToken)
 	if m.curToken.T != ql.TokenIdentity {
-		return nil, fmt.Errorf("expected idenity but got: %v", m.curToken)
+		return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity}, "expected idenity but got: %v", m.curToken.V)
 	}
 	req.Identity = m.curToken.V
+	m.curToken = m.l.NextToken()
+
+	// show columns from mytable
+	if m.curToken.T == ql.TokenFrom {
+		m.curToken = m.l.NextToken()
+		if m.curToken.T != ql.TokenIdentity {
+			return nil, m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity}, "expected table name but got: %v", m.curToken.V)
+		}
+		req.From = m.curToken.V
+		m.curToken = m.l.NextToken()
+	}
 	return req, nil
 }
 
@@ -347,16 +1095,32 @@ func (m *Sqlbridge) parseColumns(stmt *SqlSelect) error {
 		case ql.TokenUdfExpr:
 			// we have a udf/functional expression column
 			col = &Column{As: m.curToken.V, Tree: NewTree(m.pager)}
-			m.parseNode(col.Tree)
+			if err := m.parseNode(col.Tree); err != nil {
+				return err
+			}
 
 		case ql.TokenIdentity:
 			//u.Warnf("TODO")
 			col = &Column{As: m.curToken.V, Tree: NewTree(m.pager)}
-			m.parseNode(col.Tree)
+			if err := m.parseNode(col.Tree); err != nil {
+				return err
+			}
 		case ql.TokenValue:
 			// Value Literal
 			col = &Column{As: m.curToken.V, Tree: NewTree(m.pager)}
-			m.parseNode(col.Tree)
+			if err := m.parseNode(col.Tree); err != nil {
+				return err
+			}
+		case ql.TokenLeftParenthesis, ql.TokenExists, ql.TokenNegate, ql.TokenNull, ql.TokenCase:
+			// subquery, parenthesized, negated or case expression
+			//    (SELECT max(total) FROM orders) AS top
+			//    NOT ct > 5 AS few
+			//    CASE WHEN ct > 5 THEN "many" ELSE "few" END AS size
+			col = &Column{Tree: NewTree(m.pager)}
+			if err := m.parseNode(col.Tree); err != nil {
+				return err
+			}
+			col.As = col.Tree.Root.StringAST()
 		}
 		//u.Debugf("after colstart?:   %v  ", m.curToken)
 
@@ -371,21 +1135,24 @@ func (m *Sqlbridge) parseColumns(stmt *SqlSelect) error {
 				m.curToken = m.l.NextToken()
 				continue
 			}
-			return fmt.Errorf("expected identity but got: %v", m.curToken.String())
+			return m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity}, "expected identity but got: %v", m.curToken.V)
 		case ql.TokenFrom, ql.TokenInto, ql.TokenLimit, ql.TokenEOS, ql.TokenEOF:
 			// This indicates we have come to the End of the columns
 			stmt.Columns = append(stmt.Columns, col)
 			//u.Debugf("Ending column ")
 			return nil
 		case ql.TokenIf:
-			// If guard
+			// If guard, the column is only written if it evaluates true
+			//    email AS e IF exists(email)
 			m.curToken = m.l.NextToken()
 			//u.Infof("if guard: %v", m.curToken)
 			col.Guard = NewTree(m.pager)
-			//m.curToken = m.l.NextToken()
-			//u.Infof("if guard 2: %v", m.curToken)
-			m.parseNode(col.Guard)
+			if err := m.parseNode(col.Guard); err != nil {
+				return err
+			}
 			//u.Debugf("after if guard?:   %v  ", m.curToken)
+			// the guard ends at the comma, or end of columns
+			continue
 		case ql.TokenCommentSingleLine:
 			m.curToken = m.l.NextToken()
 			col.Comment = m.curToken.V
@@ -395,7 +1162,7 @@ func (m *Sqlbridge) parseColumns(stmt *SqlSelect) error {
 			stmt.Columns = append(stmt.Columns, col)
 			//u.Debugf("comma, added cols:  %v", len(stmt.Columns))
 		default:
-			return fmt.Errorf("expected column but got: %v", m.curToken.String())
+			return m.unexpected(m.curToken, "expected column but got: %v", m.curToken.V)
 		}
 		m.curToken = m.l.NextToken()
 	}
@@ -407,7 +1174,7 @@ func (m *Sqlbridge) parseFieldList(stmt *SqlInsert) error {
 
 	var col *Column
 	if m.curToken.T != ql.TokenLeftParenthesis {
-		return fmt.Errorf("Expecting opening paren ( but got %v", m.curToken)
+		return m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenLeftParenthesis}, "Expecting opening paren ( but got %v", m.curToken.V)
 	}
 	m.curToken = m.l.NextToken()
 
@@ -437,7 +1204,7 @@ func (m *Sqlbridge) parseFieldList(stmt *SqlInsert) error {
 			stmt.Columns = append(stmt.Columns, col)
 			//u.Debugf("comma, added cols:  %v", len(stmt.Columns))
 		default:
-			return fmt.Errorf("expected column but got: %v", m.curToken.String())
+			return m.unexpected(m.curToken, "expected column but got: %v", m.curToken.V)
 		}
 		m.curToken = m.l.NextToken()
 	}
@@ -448,40 +1215,91 @@ func (m *Sqlbridge) parseFieldList(stmt *SqlInsert) error {
 func (m *Sqlbridge) parseValueList(stmt *SqlInsert) error {
 
 	if m.curToken.T != ql.TokenLeftParenthesis {
-		return fmt.Errorf("Expecting opening paren ( but got %v", m.curToken)
+		return m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenLeftParenthesis}, "Expecting opening paren ( but got %v", m.curToken.V)
 	}
 	//m.curToken = m.l.NextToken()
 	stmt.Rows = make([][]Value, 0)
+	// each value is an expression ending at the comma, or paren closing the row
+	m.pager.end = ql.TokenRightParenthesis
+	defer func() { m.pager.end = ql.TokenNil }()
 	var row []Value
+	inRow := false
 	for {
 
 		//u.Debug(m.curToken.String())
 		switch m.curToken.T {
 		case ql.TokenLeftParenthesis:
+			if inRow {
+				// (1 + 2) * 3
+				break
+			}
 			// start of row
 			row = make([]Value, 0)
+			inRow = true
+			m.curToken = m.l.NextToken()
+			continue
 		case ql.TokenRightParenthesis:
 			stmt.Rows = append(stmt.Rows, row)
-		case ql.TokenFrom, ql.TokenInto, ql.TokenLimit, ql.TokenEOS, ql.TokenEOF:
+			inRow = false
+			m.curToken = m.l.NextToken()
+			continue
+		case ql.TokenFrom, ql.TokenInto, ql.TokenLimit, ql.TokenEOS, ql.TokenEOF,
+			ql.TokenDuplicateKeyUpdate:
 			// This indicates we have come to the End of the values
 			//u.Debugf("Ending %v ", m.curToken)
 			return nil
-		case ql.TokenValue:
-			row = append(row, NewStringValue(m.curToken.V))
-		case ql.TokenInteger:
-			iv, _ := strconv.ParseInt(m.curToken.V, 10, 64)
-			row = append(row, NewIntValue(iv))
 		case ql.TokenComma:
-			//row = append(row, col)
 			//u.Debugf("comma, added cols:  %v", len(stmt.Columns))
-		default:
-			u.Warnf("don't know how to handle ?  %v", m.curToken)
-			return fmt.Errorf("expected column but got: %v", m.curToken.String())
+			m.curToken = m.l.NextToken()
+			continue
+		}
+		if !inRow {
+			return m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenLeftParenthesis}, "Expecting opening paren ( but got %v", m.curToken.V)
+		}
+		tree := NewTree(m.pager)
+		if err := m.parseNode(tree); err != nil {
+			return err
+		}
+		if v, ok := literalValue(tree.Root); ok {
+			row = append(row, v)
+		} else {
+			stmt.exprs = append(stmt.exprs, insertExpr{row: len(stmt.Rows), col: len(row), node: tree.Root})
+			row = append(row, NewNilValue())
 		}
-		m.curToken = m.l.NextToken()
 	}
-	//u.Debugf("cols: %d", len(stmt.Columns))
-	return nil
+}
+
+// the value of a string or number literal, which needs no evaluation
+func literalValue(node Node) (Value, bool) {
+	switch n := node.(type) {
+	case *StringNode:
+		return NewStringValue(n.Text), true
+	case *NumberNode:
+		return numberValue(n, false), true
+	case *NullNode:
+		return NewNilValue(), true
+	case *UnaryNode:
+		// -1
+		if num, ok := n.Arg.(*NumberNode); ok && n.Operator.T == ql.TokenMinus {
+			return numberValue(num, true), true
+		}
+	}
+	return nil, false
+}
+
+func numberValue(n *NumberNode, negate bool) Value {
+	// 1.0, 1e3 are floats even though they have int values
+	isHex := strings.HasPrefix(strings.ToLower(n.Text), "0x")
+	if n.IsInt && !strings.Contains(n.Text, ".") && (isHex || !strings.ContainsAny(n.Text, "eE")) {
+		if negate {
+			return NewIntValue(-n.Int64)
+		}
+		return NewIntValue(n.Int64)
+	}
+	if negate {
+		return NewNumberValue(-n.Float64)
+	}
+	return NewNumberValue(n.Float64)
 }
 
 // Parse an expression tree or root Node
@@ -505,6 +1323,126 @@ func (m *Sqlbridge) parseSelectStar(req *SqlSelect) error {
 	return nil
 }
 
+// a derived table, the select whose rows are the from table
+//
+//    FROM (SELECT user_id, count(*) AS ct FROM orders GROUP BY user_id) AS t
+//
+func (m *Sqlbridge) parseDerivedTable(req *SqlSelect) error {
+	m.curToken = m.l.NextToken()
+	if m.curToken.T != ql.TokenSelect {
+		return m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenSelect}, "expected SELECT but got: %v", m.curToken.V)
+	}
+	sub := &Sqlbridge{l: m.l, pager: NewSqlTokenPager(m.l), buildVm: m.buildVm}
+	sub.pager.end = ql.TokenRightParenthesis
+	sel, err := sub.parseSqlSelect()
+	if err != nil {
+		return err
+	}
+	if sub.curToken.T != ql.TokenRightParenthesis {
+		return m.unexpectedOf(sub.curToken, []ql.TokenType{ql.TokenRightParenthesis}, "expected ) but got: %v", sub.curToken.V)
+	}
+	req.SubQuery = sel
+	return nil
+}
+
+// the alias of the from table, and the tables joined to it
+//
+//    FROM users AS u
+//        INNER JOIN orders o ON u.id = o.user_id
+//        LEFT OUTER JOIN refunds r ON o.id = r.order_id
+//        CROSS JOIN countries
+//
+func (m *Sqlbridge) parseJoins(req *SqlSelect) error {
+
+	alias, err := m.parseTableAlias()
+	if err != nil {
+		return err
+	}
+	req.Alias = alias
+	names := map[string]bool{strings.ToLower(req.From): true}
+	if alias != "" {
+		names = map[string]bool{strings.ToLower(alias): true}
+	}
+
+	for {
+		join := &JoinExpr{Kind: ql.TokenInner}
+		switch m.curToken.T {
+		case ql.TokenJoin:
+		case ql.TokenInner:
+			m.curToken = m.l.NextToken()
+		case ql.TokenLeft:
+			join.Kind = ql.TokenLeft
+			m.curToken = m.l.NextToken()
+			if m.curToken.T == ql.TokenOuter {
+				m.curToken = m.l.NextToken()
+			}
+		case ql.TokenCross:
+			join.Kind = ql.TokenCross
+			m.curToken = m.l.NextToken()
+		default:
+			return nil
+		}
+		if m.curToken.T != ql.TokenJoin {
+			return m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenJoin}, "expected JOIN but got: %v", m.curToken.V)
+		}
+
+		// table name
+		m.curToken = m.l.NextToken()
+		if m.curToken.T != ql.TokenIdentity && m.curToken.T != ql.TokenValue {
+			return m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity}, "expected join table name but got: %v", m.curToken.V)
+		}
+		join.Table = m.curToken.V
+		m.curToken = m.l.NextToken()
+		if join.Alias, err = m.parseTableAlias(); err != nil {
+			return err
+		}
+		name := strings.ToLower(join.Name())
+		if names[name] {
+			return m.unexpected(m.curToken, "table %q is joined more than once, use an alias", join.Name())
+		}
+		names[name] = true
+
+		// ON condition
+		switch {
+		case m.curToken.T == ql.TokenOn && join.Kind == ql.TokenCross:
+			return m.unexpected(m.curToken, "CROSS JOIN %s may not have an ON condition", join.Table)
+		case m.curToken.T == ql.TokenOn:
+			m.curToken = m.l.NextToken()
+			join.On = NewTree(m.pager)
+			if err := m.parseNode(join.On); err != nil {
+				return err
+			}
+			if aggs := findAggregates(join.On.Root); len(aggs) > 0 {
+				return m.unexpected(m.curToken, "aggregate %s not allowed in ON", aggs[0].StringAST())
+			}
+		case join.Kind != ql.TokenCross:
+			return m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenOn}, "expected ON but got: %v", m.curToken.V)
+		}
+		req.Joins = append(req.Joins, join)
+	}
+}
+
+// an optional table alias, with or without AS
+//
+//    users AS u
+//    users u
+//
+func (m *Sqlbridge) parseTableAlias() (string, error) {
+	switch m.curToken.T {
+	case ql.TokenAs:
+		m.curToken = m.l.NextToken()
+		if m.curToken.T != ql.TokenIdentity {
+			return "", m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity}, "expected table alias but got: %v", m.curToken.V)
+		}
+		fallthrough
+	case ql.TokenIdentity:
+		alias := m.curToken.V
+		m.curToken = m.l.NextToken()
+		return alias, nil
+	}
+	return "", nil
+}
+
 func (m *Sqlbridge) parseWhere(req *SqlSelect) error {
 
 	if m.curToken.T != ql.TokenWhere {
@@ -513,11 +1451,53 @@ func (m *Sqlbridge) parseWhere(req *SqlSelect) error {
 
 	m.curToken = m.l.NextToken()
 	tree := NewTree(m.pager)
-	m.parseNode(tree)
+	if err := m.parseNode(tree); err != nil {
+		return err
+	}
+	if aggs := findAggregates(tree.Root); len(aggs) > 0 {
+		return m.unexpected(m.curToken, "aggregate %s not allowed in WHERE, use HAVING", aggs[0].StringAST())
+	}
 	req.Where = tree
 	return nil
 }
 
+func (m *Sqlbridge) parseGroupBy(req *SqlSelect) error {
+
+	if m.curToken.T != ql.TokenGroupBy {
+		return nil
+	}
+
+	for {
+		m.curToken = m.l.NextToken()
+		col := &Column{As: m.curToken.V, Tree: NewTree(m.pager)}
+		if err := m.parseNode(col.Tree); err != nil {
+			return err
+		}
+		if aggs := findAggregates(col.Tree.Root); len(aggs) > 0 {
+			return m.unexpected(m.curToken, "aggregate %s not allowed in GROUP BY", aggs[0].StringAST())
+		}
+		req.GroupBy = append(req.GroupBy, col)
+		if m.curToken.T != ql.TokenComma {
+			return nil
+		}
+	}
+}
+
+func (m *Sqlbridge) parseHaving(req *SqlSelect) error {
+
+	if m.curToken.T != ql.TokenHaving {
+		return nil
+	}
+
+	m.curToken = m.l.NextToken()
+	tree := NewTree(m.pager)
+	if err := m.parseNode(tree); err != nil {
+		return err
+	}
+	req.Having = tree
+	return nil
+}
+
 func (m *Sqlbridge) parseWhereDelete(req *SqlDelete) error {
 
 	if m.curToken.T != ql.TokenWhere {
@@ -526,21 +1506,108 @@ func (m *Sqlbridge) parseWhereDelete(req *SqlDelete) error {
 
 	m.curToken = m.l.NextToken()
 	tree := NewTree(m.pager)
-	m.parseNode(tree)
+	if err := m.parseNode(tree); err != nil {
+		return err
+	}
 	req.Where = tree
 	return nil
 }
 
-func (m *Sqlbridge) parseLimit(req *SqlSelect) error {
+func (m *Sqlbridge) parseWhereUpdate(req *SqlUpdate) error {
+
+	if m.curToken.T != ql.TokenWhere {
+		return nil
+	}
+
+	m.curToken = m.l.NextToken()
+	tree := NewTree(m.pager)
+	if err := m.parseNode(tree); err != nil {
+		return err
+	}
+	req.Where = tree
+	return nil
+}
+
+// the assignments of an update, or on duplicate key update
+//
+//    SET name = 'bob', visits = visits + 1
+//
+func (m *Sqlbridge) parseSetList(cols *Columns) error {
+
+	for {
+		m.curToken = m.l.NextToken()
+		if m.curToken.T != ql.TokenIdentity {
+			return m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenIdentity}, "expected column name but got: %v", m.curToken.V)
+		}
+		col := &Column{As: m.curToken.V}
+		m.curToken = m.l.NextToken()
+		if m.curToken.T != ql.TokenEqual {
+			return m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenEqual}, "expected = but got: %v", m.curToken.V)
+		}
+		m.curToken = m.l.NextToken()
+		col.Tree = NewTree(m.pager)
+		if err := m.parseNode(col.Tree); err != nil {
+			return err
+		}
+		cols.AddColumn(col)
+		if m.curToken.T != ql.TokenComma {
+			return nil
+		}
+	}
+}
+
+func (m *Sqlbridge) parseOrderBy(req *SqlSelect) error {
+
+	if m.curToken.T != ql.TokenOrderBy {
+		return nil
+	}
+
+	for {
+		m.curToken = m.l.NextToken()
+		col := &OrderByColumn{Tree: NewTree(m.pager)}
+		if d := m.l.Dialect(); d != nil {
+			col.Collation = d.Collation
+		}
+		if err := m.parseNode(col.Tree); err != nil {
+			return err
+		}
+		switch m.curToken.T {
+		case ql.TokenAsc:
+			m.curToken = m.l.NextToken()
+		case ql.TokenDesc:
+			col.Desc = true
+			m.curToken = m.l.NextToken()
+		}
+		col.NullsFirst = !col.Desc
+		switch m.curToken.T {
+		case ql.TokenNullsFirst:
+			col.NullsFirst = true
+			m.curToken = m.l.NextToken()
+		case ql.TokenNullsLast:
+			col.NullsFirst = false
+			m.curToken = m.l.NextToken()
+		}
+		req.OrderBy = append(req.OrderBy, col)
+		if m.curToken.T != ql.TokenComma {
+			return nil
+		}
+	}
+}
+
+func (m *Sqlbridge) parseLimit(limit *int) error {
+	if m.curToken.T != ql.TokenLimit {
+		return nil
+	}
 	m.curToken = m.l.NextToken()
 	if m.curToken.T != ql.TokenInteger {
-		return fmt.Errorf("Limit must be an integer %v %v", m.curToken.T, m.curToken.V)
+		return m.unexpectedOf(m.curToken, []ql.TokenType{ql.TokenInteger}, "Limit must be an integer %v %v", m.curToken.T, m.curToken.V)
 	}
 	iv, err := strconv.Atoi(m.curToken.V)
 	if err != nil {
-		return fmt.Errorf("Could not convert limit to integer %v", m.curToken.V)
+		return m.unexpected(m.curToken, "Could not convert limit to integer %v", m.curToken.V)
 	}
-	req.Limit = int(iv)
+	*limit = int(iv)
+	m.curToken = m.l.NextToken()
 	return nil
 }
 
@@ -555,6 +1622,7 @@ type SqlTokenPager struct {
 	peekCount int
 	lex       *ql.Lexer
 	end       ql.TokenType
+	columnDef bool // in the default of a column definition, which ends at its NOT NULL, FIRST ...
 }
 
 func NewSqlTokenPager(lex *ql.Lexer) *SqlTokenPager {
@@ -580,13 +1648,22 @@ func (m *SqlTokenPager) Next() ql.Token {
 func (m *SqlTokenPager) Last() ql.TokenType {
 	return m.end
 }
+func (m *SqlTokenPager) Lexer() *ql.Lexer {
+	return m.lex
+}
 func (m *SqlTokenPager) IsEnd() bool {
 	tok := m.Peek()
 	//u.Debugf("tok:  %v", tok)
 	switch tok.T {
-	case ql.TokenEOF, ql.TokenEOS, ql.TokenFrom, ql.TokenComma, ql.TokenIf,
-		ql.TokenAs, ql.TokenLimit:
+	case ql.TokenEOF, ql.TokenEOS, ql.TokenFrom, ql.TokenWhere, ql.TokenComma, ql.TokenIf,
+		ql.TokenAs, ql.TokenLimit, ql.TokenOrderBy, ql.TokenAsc, ql.TokenDesc,
+		ql.TokenNullsFirst, ql.TokenNullsLast, ql.TokenGroupBy, ql.TokenHaving,
+		ql.TokenDuplicateKeyUpdate, ql.TokenJoin, ql.TokenInner, ql.TokenLeft, ql.TokenCross:
 		return true
+	case ql.TokenNotNull, ql.TokenFirst, ql.TokenAfter, ql.TokenCharacterSet:
+		return m.columnDef
+	case m.end:
+		return m.end != ql.TokenNil
 	}
 	return false
 }
@@ -4,7 +4,9 @@ import (
 	"flag"
 	"github.com/araddon/dateparse"
 	u "github.com/araddon/gou"
+	"io"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -82,6 +84,13 @@ var numberTests = []numberTest{
 	// funny bases
 	{"0123", true, true, 0123, 0123, 0123},
 	{"0xdeadbeef", true, true, 0xdeadbeef, 0xdeadbeef, 0xdeadbeef},
+	{"0XDEADBEEF", true, true, 0xdeadbeef, 0xdeadbeef, 0xdeadbeef},
+	{"0b1010", true, true, 10, 10, 10},
+	// digit separators, scientific
+	{"1_000_000", true, true, 1000000, 1000000, 1000000},
+	{"1.5e-3", false, true, 0, 0, 1.5e-3},
+	{"1.5E3", true, true, 1500, 1500, 1500},
+	{".5", false, true, 0, 0, .5},
 	// some broken syntax
 	{text: "+-2"},
 	{text: "0x123."},
@@ -111,6 +120,9 @@ func TestNumberParse(t *testing.T) {
 		if test.isInt && !n.IsInt {
 			t.Errorf("did not expect unsigned integer for %q", test.text)
 		}
+		if test.isInt && n.Int64 != test.int64 {
+			t.Errorf("int64 for %q should be %d Is %d", test.text, test.int64, n.Int64)
+		}
 		if test.isFloat {
 			if !n.IsFloat {
 				t.Errorf("expected float for %q", test.text)
@@ -138,6 +150,16 @@ const (
 
 var parseTests = []parseTest{
 	{"general parse test", `eq(toint(item),5)`, noError, `eq(toint(item), 5)`},
+	{"quoted identity", "`user-id` == 5", noError, "`user-id` == 5"},
+	{"quoted identity escaped", "[my]]col] > 5", noError, "[my]]col] > 5"},
+	{"string escapes", `item == 'it''s'`, noError, `item == 'it''s'`},
+	{"string backslash escapes", `item == "tab\there\\"`, noError, `item == "tab\there\\"`},
+	{"bind params", `toint(:max_ct) > $1`, noError, `toint(:max_ct) > $1`},
+	{"! binds to its factor", `!a == b`, noError, `!(a) == b`},
+	{"! of a comparison", `!(a == b)`, noError, `!(a == b)`},
+	{"check in list", `a IN (1, CASE WHEN b THEN 1 ELSE "c" END)`, hasError, ``},
+	{"check between", `a BETWEEN 1 AND CASE WHEN b THEN 1 ELSE "c" END`, hasError, ``},
+	{"check is null", `CASE WHEN b THEN 1 ELSE "c" END IS NULL`, hasError, ``},
 }
 
 func TestParseQls(t *testing.T) {
@@ -166,3 +188,139 @@ func TestParseQls(t *testing.T) {
 		}
 	}
 }
+
+func TestParseError(t *testing.T) {
+	_, err := ParseExpression(`eq(item,5`)
+	pe, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected *ParseError but got %T %v", err, err)
+	}
+	if pe.Line != 1 || pe.Column != 10 {
+		t.Errorf("expected line 1 col 10 but got %d:%d  %v", pe.Line, pe.Column, pe)
+	}
+
+	_, err = ParseSql("SELECT x\n FROM mytable WHERE x == ")
+	if _, ok = err.(*ParseError); !ok {
+		t.Errorf("expected *ParseError but got %T %v", err, err)
+	}
+
+	_, err = ParseSql("INSERT users (id) values (1)")
+	pe, ok = err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected *ParseError but got %T %v", err, err)
+	}
+	if pe.Line != 1 || pe.Column != 8 || len(pe.Expected) != 2 {
+		t.Errorf("expected error at line 1 col 8 but got %d:%d  %v", pe.Line, pe.Column, pe)
+	}
+
+	// trailing statements are not silently dropped
+	_, err = ParseSql("select a FROM t; -- done\n;")
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	_, err = ParseSql("select a FROM t; delete FROM t")
+	pe, ok = err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected *ParseError but got %T %v", err, err)
+	}
+	if pe.Line != 1 || pe.Column != 18 {
+		t.Errorf("expected error at line 1 col 18 but got %d:%d  %v", pe.Line, pe.Column, pe)
+	}
+}
+
+var scriptText = `-- create some users
+insert into users (id, name) values (1, "bob");;
+/* multi-line
+   comment */
+select name FROM users WHERE id == 1 LIMIT 1;
+select name, 'a;b' AS x FROM users;
+describe users
+`
+
+func TestParseScript(t *testing.T) {
+	stmts, err := ParseScript(scriptText)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(stmts) != 4 {
+		t.Fatalf("expected 4 statements but got %d", len(stmts))
+	}
+	sources := []string{
+		`insert into users (id, name) values (1, "bob")`,
+		`select name FROM users WHERE id == 1 LIMIT 1`,
+		`select name, 'a;b' AS x FROM users`,
+		`describe users`,
+	}
+	for i, stmt := range stmts {
+		if src := stmt.Span().Source(scriptText); src != sources[i] {
+			t.Errorf("expected %q but got %q", sources[i], src)
+		}
+	}
+	if sel, ok := stmts[1].(*SqlSelect); !ok || sel.Limit != 1 || sel.Where == nil {
+		t.Errorf("expected select with where, limit but got %#v", stmts[1])
+	}
+	if span := stmts[2].Span(); span.Line != 6 || span.Column != 1 {
+		t.Errorf("expected line 6 col 1 but got %d:%d", span.Line, span.Column)
+	}
+
+	// t2 is a table alias, garbage is not
+	_, err = ParseScript("select a FROM t; select b FROM t t2 garbage;")
+	pe, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected *ParseError but got %T %v", err, err)
+	}
+	if pe.Line != 1 || pe.Column != 37 {
+		t.Errorf("expected error at line 1 col 37 but got %d:%d  %v", pe.Line, pe.Column, pe)
+	}
+}
+
+func TestScriptScanner(t *testing.T) {
+	s := NewScriptScanner(strings.NewReader(scriptText))
+	stmts, err := ParseScript(scriptText)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for i := 0; ; i++ {
+		stmt, err := s.Next()
+		if err == io.EOF {
+			if i != len(stmts) {
+				t.Errorf("expected %d statements but got %d", len(stmts), i)
+			}
+			break
+		} else if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if stmt.Keyword() != stmts[i].Keyword() || *stmt.Span() != *stmts[i].Span() {
+			t.Errorf("expected %v %+v but got %v %+v", stmts[i].Keyword(), stmts[i].Span(),
+				stmt.Keyword(), stmt.Span())
+		}
+	}
+
+	// a backslash is not an escape inside of quoted identities
+	s = NewScriptScanner(strings.NewReader("select `a\\` FROM t; select [b\\] FROM t;"))
+	for _, src := range []string{"select `a\\` FROM t", "select [b\\] FROM t"} {
+		stmt, err := s.Next()
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if stmt.Span().End-stmt.Span().Start != len(src) {
+			t.Errorf("expected %q but got span %+v", src, stmt.Span())
+		}
+	}
+	if _, err = s.Next(); err != io.EOF {
+		t.Errorf("expected EOF but got %v", err)
+	}
+
+	s = NewScriptScanner(strings.NewReader("select a FROM t;\nselect b FROM t t2 garbage;"))
+	if _, err = s.Next(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	_, err = s.Next()
+	pe, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected *ParseError but got %T %v", err, err)
+	}
+	if pe.Line != 2 || pe.Column != 20 || pe.Pos != 36 {
+		t.Errorf("expected error at line 2 col 20 but got %d:%d  %v", pe.Line, pe.Column, pe)
+	}
+}
@@ -0,0 +1,335 @@
+package vm
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"sort"
+	"time"
+
+	ql "github.com/araddon/qlbridge/lex"
+)
+
+// SqlResults collects the rows output by a select executed over many
+//  input rows, sorted by its ORDER BY.  With a LIMIT only the top rows are
+//  kept, in a bounded heap, so memory is constant over large inputs.
+//
+//     sqlVm, _ := NewSqlVm("SELECT user_id, ct FROM stdio ORDER BY ct DESC LIMIT 10")
+//     results, _ := sqlVm.NewResults()
+//     for _, row := range rows {
+//         if err := results.Add(row); err != nil {
+//             return err
+//         }
+//     }
+//     top10 := results.Rows()
+//
+// With a GROUP BY, or aggregate funcs, input rows are grouped instead and
+//  there is one output row per group, filtered by the HAVING.
+//
+//     SELECT country, count(*) AS ct FROM stdio GROUP BY country HAVING ct > 10
+//
+type SqlResults struct {
+	vm      *SqlVm
+	orderBy []*OrderByColumn
+	limit   int
+	seq     int
+	rows    *resultRows // a heap with the last of the top rows at root when limited
+	grouped bool
+	aggs    []*FuncNode             // aggregate funcs of the columns, having, order by
+	groups  map[string]*resultGroup // groups by key of their GROUP BY values
+	keys    []string                // group keys, in order first seen
+}
+
+// the accumulated aggregates of the input rows of one group
+type resultGroup struct {
+	first ContextReader // first input row, for the non aggregate columns
+	aggs  []Aggregator  // for each of SqlResults.aggs
+}
+
+// a row output by select, with its values for each ORDER BY
+type resultRow struct {
+	row  map[string]Value
+	keys []Value
+	seq  int // order added, so sort is stable
+}
+
+// NewResults creates a collector for the output rows of this select
+func (m *SqlVm) NewResults() (*SqlResults, error) {
+	if m.Keyword != ql.TokenSelect {
+		return nil, fmt.Errorf("results are only for select but got %v", m.Keyword)
+	}
+	r := &SqlResults{vm: m, orderBy: m.sel.OrderBy, limit: m.sel.Limit}
+	m.subs = nil // a new execution, subqueries are evaluated again
+	r.rows = r.newRows()
+	for _, col := range m.sel.Columns {
+		if col.Tree != nil {
+			r.aggs = append(r.aggs, findAggregates(col.Tree.Root)...)
+		}
+	}
+	if m.sel.Having != nil {
+		r.aggs = append(r.aggs, findAggregates(m.sel.Having.Root)...)
+	}
+	for _, ob := range m.sel.OrderBy {
+		r.aggs = append(r.aggs, findAggregates(ob.Tree.Root)...)
+	}
+	if len(m.sel.GroupBy) > 0 || len(r.aggs) > 0 || m.sel.Having != nil {
+		r.grouped = true
+		r.groups = make(map[string]*resultGroup)
+	}
+	return r, nil
+}
+
+func (m *SqlResults) newRows() *resultRows {
+	return &resultRows{less: func(a, b *resultRow) bool { return m.before(b, a) }}
+}
+
+// Add executes the select against an input row, keeping the output row if
+// it is not filtered out, and sorts within the LIMIT
+func (m *SqlResults) Add(readContext ContextReader) error {
+	if m.grouped {
+		return m.addToGroup(readContext)
+	}
+	if m.limit > 0 && len(m.orderBy) == 0 && m.rows.Len() >= m.limit {
+		// without ordering the first rows are the top rows
+		return nil
+	}
+	out := NewContextSimple()
+	matched, err := m.vm.executeSelect(out, readContext)
+	if err != nil || !matched {
+		return err
+	}
+	m.push(m.rows, out, m.vm.newState(readContext))
+	return nil
+}
+
+// Merge the results of another SqlResults of the same select into these, so
+// rows may be added in parallel to a SqlResults per partition.  Groups
+// with the same GROUP BY values are merged with Aggregator.Merge.
+func (m *SqlResults) Merge(other *SqlResults) error {
+	if m.vm.sel.String() != other.vm.sel.String() || len(m.aggs) != len(other.aggs) {
+		return fmt.Errorf("cannot merge results of different selects")
+	}
+	if !m.grouped {
+		base := m.seq
+		for _, rr := range other.rows.rows {
+			merged := *rr
+			merged.seq += base
+			m.pushRow(m.rows, &merged)
+		}
+		m.seq = base + other.seq
+		return nil
+	}
+	for _, key := range other.keys {
+		og := other.groups[key]
+		g, ok := m.groups[key]
+		if !ok {
+			g = m.newGroup(og.first)
+			m.groups[key] = g
+			m.keys = append(m.keys, key)
+		}
+		for i, agg := range g.aggs {
+			agg.Merge(og.aggs[i])
+		}
+	}
+	return nil
+}
+
+// push an output row onto rows, evaluating its ORDER BY with state s
+func (m *SqlResults) push(rows *resultRows, out *ContextSimple, s *State) {
+	rr := &resultRow{row: out.Data, seq: m.seq}
+	m.seq++
+	if len(m.orderBy) > 0 {
+		s.Reader = &resultReader{out: out, in: s.Reader}
+		rr.keys = make([]Value, len(m.orderBy))
+		for i, ob := range m.orderBy {
+			if v, ok := s.Walk(ob.Tree.Root); ok {
+				rr.keys[i] = v
+			}
+		}
+	}
+	m.pushRow(rows, rr)
+}
+
+// push a row, keeping only the top rows when limited
+func (m *SqlResults) pushRow(rows *resultRows, rr *resultRow) {
+	switch {
+	case m.limit <= 0:
+		rows.Push(rr)
+	case rows.Len() < m.limit:
+		heap.Push(rows, rr)
+	case m.before(rr, rows.rows[0]):
+		// replace the last of the top rows
+		rows.rows[0] = rr
+		heap.Fix(rows, 0)
+	}
+}
+
+// addToGroup accumulates the aggregates of the group of the input row
+func (m *SqlResults) addToGroup(readContext ContextReader) error {
+	if err := m.vm.checkParams(); err != nil {
+		return err
+	}
+	s := m.vm.newState(readContext)
+	if matched, err := m.vm.matchWhere(s, m.vm.sel.Where); !matched {
+		return err
+	}
+	key := m.groupKey(s)
+	g, ok := m.groups[key]
+	if !ok {
+		first := readContext
+		if _, isJoin := readContext.(*joinRow); !isJoin {
+			// copy the row, readers may re-use theirs, joined rows are
+			// already copies and keep their qualified columns
+			first = NewContextSimpleTs(copyJoinRow(readContext.Row()), readContext.Ts())
+		}
+		g = m.newGroup(first)
+		m.groups[key] = g
+		m.keys = append(m.keys, key)
+	}
+	for i, fn := range m.aggs {
+		var v Value = BoolValueTrue // count(*), or no args, once per row
+		if len(fn.Args) > 0 {
+			v, ok = s.Walk(fn.Args[0])
+			if !ok || isNullValue(v) {
+				continue
+			}
+		}
+		g.aggs[i].Accumulate(v)
+	}
+	return nil
+}
+
+func (m *SqlResults) newGroup(first ContextReader) *resultGroup {
+	g := &resultGroup{first: first, aggs: make([]Aggregator, len(m.aggs))}
+	for i, fn := range m.aggs {
+		g.aggs[i] = newAggregator(fn.Name, fn.Collation)
+	}
+	return g
+}
+
+// the key of the GROUP BY values of the row, type and length prefixed
+// so that different values never share a key
+func (m *SqlResults) groupKey(s *State) string {
+	var buf bytes.Buffer
+	for _, col := range m.vm.sel.GroupBy {
+		v, ok := s.Walk(col.Tree.Root)
+		if !ok || isNullValue(v) {
+			buf.WriteString("null;")
+			continue
+		}
+		str := v.ToString()
+		fmt.Fprintf(&buf, "%d:%d:%s;", v.Type(), len(str), str)
+	}
+	return buf.String()
+}
+
+// groupRows outputs a row per group that matches the HAVING
+func (m *SqlResults) groupRows() *resultRows {
+	rows := m.newRows()
+	groups := make([]*resultGroup, 0, len(m.keys))
+	for _, key := range m.keys {
+		groups = append(groups, m.groups[key])
+	}
+	if len(groups) == 0 && len(m.vm.sel.GroupBy) == 0 {
+		// aggregates over no rows, ie count(*) is 0
+		groups = append(groups, m.newGroup(NewContextSimple()))
+	}
+	for _, g := range groups {
+		s := m.vm.newState(g.first)
+		s.aggs = make(map[*FuncNode]Value, len(m.aggs))
+		for i, fn := range m.aggs {
+			s.aggs[fn] = g.aggs[i].Result()
+		}
+		out := NewContextSimple()
+		m.vm.writeColumns(s, out, g.first)
+		if having := m.vm.sel.Having; having != nil {
+			// having may use column aliases as well as aggregates
+			s.Reader = &resultReader{out: out, in: g.first}
+			v, ok := s.Walk(having.Root)
+			if bv, isBool := v.(BoolValue); !ok || !isBool || !bv.v {
+				continue
+			}
+			s.Reader = g.first
+		}
+		m.push(rows, out, s)
+	}
+	return rows
+}
+
+// Rows are the output rows, in ORDER BY order
+func (m *SqlResults) Rows() []map[string]Value {
+	top := m.rows
+	if m.grouped {
+		top = m.groupRows()
+	}
+	sorted := &resultRows{rows: make([]*resultRow, top.Len()), less: m.before}
+	copy(sorted.rows, top.rows)
+	sort.Sort(sorted)
+	rows := make([]map[string]Value, len(sorted.rows))
+	for i, rr := range sorted.rows {
+		rows[i] = rr.row
+	}
+	return rows
+}
+
+// does row a sort before b?
+func (m *SqlResults) before(a, b *resultRow) bool {
+	for i, ob := range m.orderBy {
+		if c := compareSortKeys(a.keys[i], b.keys[i], ob); c != 0 {
+			return c < 0
+		}
+	}
+	return a.seq < b.seq
+}
+
+// compare two ORDER BY values, where nil is a missing value
+func compareSortKeys(a, b Value, ob *OrderByColumn) int {
+	aNull := a == nil || a.Type() == NilType
+	bNull := b == nil || b.Type() == NilType
+	switch {
+	case aNull && bNull:
+		return 0
+	case aNull, bNull:
+		if aNull == ob.NullsFirst {
+			return -1
+		}
+		return 1
+	}
+	c := CompareCollate(a, b, ob.Collation)
+	if ob.Desc {
+		return -c
+	}
+	return c
+}
+
+// sort.Interface, heap.Interface over result rows
+type resultRows struct {
+	rows []*resultRow
+	less func(a, b *resultRow) bool
+}
+
+func (m *resultRows) Len() int           { return len(m.rows) }
+func (m *resultRows) Less(i, j int) bool { return m.less(m.rows[i], m.rows[j]) }
+func (m *resultRows) Swap(i, j int)      { m.rows[i], m.rows[j] = m.rows[j], m.rows[i] }
+func (m *resultRows) Push(x interface{}) { m.rows = append(m.rows, x.(*resultRow)) }
+func (m *resultRows) Pop() interface{} {
+	rr := m.rows[len(m.rows)-1]
+	m.rows = m.rows[:len(m.rows)-1]
+	return rr
+}
+
+// reads the output row of a select first, then the input row, so
+// ORDER BY may use column aliases as well as input fields
+type resultReader struct {
+	out ContextReader
+	in  ContextReader
+}
+
+func (m *resultReader) Get(key string) (Value, bool) {
+	if v, ok := m.out.Get(key); ok {
+		return v, true
+	}
+	return m.in.Get(key)
+}
+func (m *resultReader) Row() map[string]Value { return m.in.Row() }
+func (m *resultReader) Ts() time.Time         { return m.in.Ts() }
@@ -0,0 +1,209 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+
+	ql "github.com/araddon/qlbridge/lex"
+)
+
+// ParseScript parses a script of semi-colon separated statements, returning
+// them in order.  Each statement has its SourceSpan in the text, empty
+// statements and comments between statements are skipped.
+//
+//    stmts, err := vm.ParseScript(`
+//        -- load some users
+//        insert into users (id, name) values (1, "bob");
+//        select name from users where id = 1;
+//    `)
+//
+func ParseScript(text string) ([]SqlStatement, error) {
+	l := ql.NewSqlLexer(text)
+	p := Sqlbridge{l: l, pager: NewSqlTokenPager(l), buildVm: true}
+	stmts := make([]SqlStatement, 0)
+	for {
+		tok := l.NextToken()
+		switch {
+		case tok.T == ql.TokenEOF:
+			return stmts, nil
+		case tok.T == ql.TokenEOS || tok.T.IsComment():
+			// empty statement ;; or comments between statements
+			continue
+		}
+		p.firstToken = tok
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+		switch p.curToken.T {
+		case ql.TokenEOS:
+			// next statement
+		case ql.TokenEOF:
+			return stmts, nil
+		default:
+			return nil, p.unexpectedOf(p.curToken, []ql.TokenType{ql.TokenEOS, ql.TokenEOF},
+				"expected end of statement but got: %v", p.curToken.V)
+		}
+	}
+}
+
+// ScriptScanner reads a script of semi-colon separated statements from
+// a reader, parsing one statement at a time so the whole script is never
+// held in memory.  Spans, and positions of errors, are relative to the
+// start of the reader.
+//
+//    s := vm.NewScriptScanner(f)
+//    for {
+//        stmt, err := s.Next()
+//        if err == io.EOF {
+//            break
+//        } else if err != nil {
+//            return err
+//        }
+//        ....
+//    }
+//
+type ScriptScanner struct {
+	r        *bufio.Reader
+	pending  []SqlStatement
+	err      error
+	offset   int           // byte offset of the start of the next chunk
+	line     int           // line of the start of next chunk
+	column   int           // column of the start of next chunk
+	idQuotes string        // quote marks of identities, same as the lexer
+	escaping ql.EscapeMode // escaping of quoted string values
+}
+
+func NewScriptScanner(r io.Reader) *ScriptScanner {
+	idQuotes := ql.SqlDialect.IdentityQuoting
+	if idQuotes == "" {
+		idQuotes = ql.IDENTITY_QUOTE_CHARS
+	}
+	return &ScriptScanner{r: bufio.NewReader(r), line: 1, column: 1,
+		idQuotes: idQuotes, escaping: ql.SqlDialect.StringEscaping}
+}
+
+// Next returns the next statement, or io.EOF when there are no more
+func (m *ScriptScanner) Next() (SqlStatement, error) {
+	for len(m.pending) == 0 {
+		if m.err != nil {
+			return nil, m.err
+		}
+		chunk, err := m.readStatement()
+		if err != nil && err != io.EOF {
+			m.err = err
+			return nil, err
+		}
+		if len(chunk) > 0 {
+			stmts, perr := ParseScript(chunk)
+			if perr != nil {
+				m.shiftError(perr)
+				m.err = perr
+				return nil, perr
+			}
+			for _, stmt := range stmts {
+				m.shiftSpan(stmt.Span())
+			}
+			m.pending = stmts
+			m.advance(chunk)
+		}
+		if err == io.EOF {
+			m.err = io.EOF
+		}
+	}
+	stmt := m.pending[0]
+	m.pending = m.pending[1:]
+	return stmt, nil
+}
+
+// shift a span in the current chunk to be relative to start of reader
+func (m *ScriptScanner) shiftSpan(span *SourceSpan) {
+	if span.Line == 1 {
+		span.Column += m.column - 1
+	}
+	span.Line += m.line - 1
+	span.Start += m.offset
+	span.End += m.offset
+}
+
+func (m *ScriptScanner) shiftError(err error) {
+	if pe, ok := err.(*ParseError); ok {
+		if pe.Line == 1 {
+			pe.Column += m.column - 1
+		}
+		pe.Line += m.line - 1
+		pe.Pos += m.offset
+		pe.Token.Pos += m.offset
+	}
+}
+
+// advance the offset, line, column past the chunk
+func (m *ScriptScanner) advance(chunk string) {
+	m.offset += len(chunk)
+	if n := strings.Count(chunk, "\n"); n > 0 {
+		m.line += n
+		m.column = len(chunk) - strings.LastIndex(chunk, "\n")
+	} else {
+		m.column += len(chunk)
+	}
+}
+
+// readStatement reads up to and including the next semi-colon that is not
+// inside of a quoted value, identity or comment.  Quoting follows the lexer,
+// a backslash only escapes inside string values, and a doubled quote mark
+// inside either just closes and re-opens the quote.
+func (m *ScriptScanner) readStatement() (string, error) {
+	var buf bytes.Buffer
+	var quote rune       // the closing quote mark we are inside of
+	var identity bool    // the quote is an identity, not a string value
+	var lineComment bool // inside -- # or // comment
+	var blockComment bool
+	var prev rune
+	for {
+		r, _, err := m.r.ReadRune()
+		if err != nil {
+			return buf.String(), err
+		}
+		buf.WriteRune(r)
+		switch {
+		case lineComment:
+			if r == '\n' {
+				lineComment = false
+			}
+		case blockComment:
+			if prev == '*' && r == '/' {
+				blockComment = false
+				r = 0 // so the / isn't re-used as a comment start
+			}
+		case quote != 0:
+			if r == '\\' && !identity && m.escaping == ql.EscapeBackslash {
+				// escaped char, copy it through
+				next, _, err := m.r.ReadRune()
+				if err != nil {
+					return buf.String(), err
+				}
+				buf.WriteRune(next)
+				r = 0
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '[' && strings.ContainsRune(m.idQuotes, r):
+			quote, identity = ']', true
+		case r == '\'' || r == '"' || r == '`':
+			quote, identity = r, strings.ContainsRune(m.idQuotes, r)
+		case r == '#':
+			lineComment = true
+		case (r == '-' && prev == '-') || (r == '/' && prev == '/'):
+			lineComment = true
+		case r == '*' && prev == '/':
+			blockComment = true
+			r = 0 // so /*/ isn't a complete comment
+		case r == ';':
+			return buf.String(), nil
+		}
+		prev = r
+	}
+}
@@ -0,0 +1,363 @@
+package vm
+
+import (
+	"strings"
+
+	u "github.com/araddon/gou"
+	ql "github.com/araddon/qlbridge/lex"
+)
+
+// subQueries is the state of the subqueries of one execution of a select,
+// the rows of each table read from its source, and the rows of each
+// uncorrelated subquery, which is evaluated only once.  A correlated
+// subquery, one using columns of the outer query, is evaluated for each
+// outer row.  Outer columns are those qualified by the name or alias of an
+// outer table, or with a Catalog, unqualified ones that are not declared
+// columns of the subquery's own tables.  Without a Catalog an unqualified
+// column is always one of the subquery's own tables.
+type subQueries struct {
+	sources    map[string]RowScanner
+	catalog    *Catalog                          // declared columns of tables, may be nil
+	tables     map[string][]map[string]Value     // rows read from sources, by lower case table name
+	vms        map[*SqlSelect]*SqlVm             // vm of each subquery
+	correlated map[*SqlSelect]bool               // does the subquery use columns of the outer query?
+	results    map[*SqlSelect][]map[string]Value // rows of the uncorrelated subqueries
+	sets       map[*SqlSelect]*subQuerySet       // values of the uncorrelated subqueries of IN
+	err        error                             // first error of a subquery
+}
+
+func newSubQueries(sources map[string]RowScanner, catalog *Catalog) *subQueries {
+	return &subQueries{
+		sources:    sources,
+		catalog:    catalog,
+		tables:     make(map[string][]map[string]Value),
+		vms:        make(map[*SqlSelect]*SqlVm),
+		correlated: make(map[*SqlSelect]bool),
+		results:    make(map[*SqlSelect][]map[string]Value),
+		sets:       make(map[*SqlSelect]*subQuerySet),
+	}
+}
+
+// the values of the column of the rows of a subquery of IN
+type subQuerySet struct {
+	values  map[string]bool // by collateKey
+	hasNull bool
+}
+
+func newSubQuerySet(rows []map[string]Value, key string, collation ql.Collation) *subQuerySet {
+	set := &subQuerySet{values: make(map[string]bool, len(rows))}
+	for _, row := range rows {
+		if v, ok := row[key]; ok && !isNullValue(v) {
+			set.values[collateKey(v, collation)] = true
+		} else {
+			set.hasNull = true
+		}
+	}
+	return set
+}
+
+// the rows of a table, read from its source only once even if the table
+// is read by more than one subquery, or joined more than once
+func (m *subQueries) table(name string) ([]map[string]Value, error) {
+	if rows, ok := m.tables[strings.ToLower(name)]; ok {
+		return rows, nil
+	}
+	scanner, err := joinSource(m.sources, name)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]Value, 0)
+	for row := scanner.Next(); row != nil; row = scanner.Next() {
+		rows = append(rows, copyJoinRow(row))
+	}
+	m.tables[strings.ToLower(name)] = rows
+	return rows, nil
+}
+
+// has the table already been read from its source?
+func (m *subQueries) hasTable(name string) bool {
+	_, ok := m.tables[strings.ToLower(name)]
+	return ok
+}
+
+func (m *subQueries) isCorrelated(sel *SqlSelect) bool {
+	correlated, ok := m.correlated[sel]
+	if !ok {
+		correlated = len(outerNames(sel)) > 0 || len(m.outerColumns(sel)) > 0
+		m.correlated[sel] = correlated
+	}
+	return correlated
+}
+
+// validate the columns of a select with subqueries against the Catalog, if
+// any, so a misspelled column is an error rather than one of an outer query
+func (m *subQueries) validate(sel *SqlSelect) {
+	if m.catalog == nil || sel == nil || !hasSubQueries(sel) {
+		return
+	}
+	if err := m.catalog.Validate(sel); err != nil {
+		m.fail(err)
+	}
+}
+
+// keep the first error, so it is returned by the execution
+func (m *subQueries) fail(err error) {
+	if m.err == nil {
+		m.err = err
+	}
+}
+
+// the subquery state of this execution, reading tables from Sources
+func (m *SqlVm) subQueries() *subQueries {
+	if m.subs == nil {
+		m.subs = newSubQueries(m.Sources, m.Catalog)
+		m.subs.validate(m.sel)
+	}
+	return m.subs
+}
+
+// the error of a subquery of this execution, if any
+func (m *SqlVm) subQueryErr() error {
+	if m.subs != nil {
+		return m.subs.err
+	}
+	return nil
+}
+
+// subQueryRows executes a subquery, for the row of the outer query if it
+// is correlated, otherwise only once per execution
+func (m *SqlVm) subQueryRows(sel *SqlSelect, outer ContextReader) ([]map[string]Value, error) {
+	subs := m.subQueries()
+	correlated := subs.isCorrelated(sel)
+	if !correlated {
+		if rows, ok := subs.results[sel]; ok {
+			return rows, nil
+		}
+		outer = nil
+	}
+	sub, ok := subs.vms[sel]
+	if !ok {
+		sub = NewSqlVmStatement(sel)
+		sub.bound = m.bound
+		subs.vms[sel] = sub
+	}
+	results, err := sub.NewResults()
+	if err != nil {
+		return nil, err
+	}
+	sub.subs = subs
+	if err := sub.executeJoin(results, outer, false); err != nil {
+		return nil, err
+	}
+	if err := subs.err; err != nil {
+		return nil, err
+	}
+	rows := results.Rows()
+	if !correlated {
+		subs.results[sel] = rows
+	}
+	return rows, nil
+}
+
+// walkSubQuery evaluates EXISTS (SELECT ...), or a scalar subquery which
+// is null if it has no rows, and an error if it has more than one
+func (e *State) walkSubQuery(node *SubQueryNode) (Value, bool) {
+	m, ok := e.ExprVm.(*SqlVm)
+	if !ok {
+		u.Warnf("subquery outside of a sql statement: %s", node)
+		return nil, false
+	}
+	rows, err := m.subQueryRows(node.Select, e.Reader)
+	if err != nil {
+		m.subQueries().fail(err)
+		return nil, false
+	}
+	if node.Exists {
+		return NewBoolValue(len(rows) > 0), true
+	}
+	switch len(rows) {
+	case 0:
+		return NewNilValue(), true
+	case 1:
+		if v, ok := rows[0][node.Select.Columns[0].Key()]; ok {
+			return v, true
+		}
+		return NewNilValue(), true
+	}
+	m.subQueries().fail(SqlSubQueryRowsError)
+	return nil, false
+}
+
+// walkInSubQuery evaluates  a [NOT] IN (SELECT ...), true if a is equal to a
+// value of the column of the subquery, null if a is null, or it is not
+// equal to any but one is null
+func (e *State) walkInSubQuery(node *BinaryNode, sq *SubQueryNode) Value {
+	a, ok := e.Walk(node.Args[0])
+	if !ok || isNullValue(a) {
+		return NewNilValue()
+	}
+	m, ok := e.ExprVm.(*SqlVm)
+	if !ok {
+		u.Warnf("subquery outside of a sql statement: %s", sq)
+		return NewNilValue()
+	}
+	subs := m.subQueries()
+	set, ok := subs.sets[sq.Select]
+	if !ok {
+		rows, err := m.subQueryRows(sq.Select, e.Reader)
+		if err != nil {
+			subs.fail(err)
+			return NewNilValue()
+		}
+		set = newSubQuerySet(rows, sq.Select.Columns[0].Key(), node.Collation)
+		if !subs.isCorrelated(sq.Select) {
+			subs.sets[sq.Select] = set
+		}
+	}
+	switch {
+	case set.values[collateKey(a, node.Collation)]:
+		return NewBoolValue(!node.Negated)
+	case set.hasNull:
+		return NewNilValue()
+	}
+	return NewBoolValue(node.Negated)
+}
+
+// the trees of the expressions of a select
+func selectTrees(sel *SqlSelect) []*Tree {
+	trees := make([]*Tree, 0, len(sel.Columns)+len(sel.Joins)+2)
+	for _, col := range sel.Columns {
+		trees = append(trees, col.Tree, col.Guard)
+	}
+	for _, join := range sel.Joins {
+		trees = append(trees, join.On)
+	}
+	trees = append(trees, sel.Where)
+	for _, col := range sel.GroupBy {
+		trees = append(trees, col.Tree)
+	}
+	trees = append(trees, sel.Having)
+	for _, ob := range sel.OrderBy {
+		trees = append(trees, ob.Tree)
+	}
+	return trees
+}
+
+// does the select have subqueries in its expressions?
+func hasSubQueries(sel *SqlSelect) bool {
+	found := false
+	for _, tree := range selectTrees(sel) {
+		if tree == nil || tree.Root == nil {
+			continue
+		}
+		Walk(tree.Root, func(n Node) {
+			if _, ok := n.(*SubQueryNode); ok {
+				found = true
+			}
+		})
+	}
+	return found
+}
+
+// outerNames are the table names, or aliases, which qualify columns of a
+// select, or of its subqueries, but are not tables of the select, so are
+// those of an outer query
+//
+//    SELECT user_id FROM orders o WHERE o.user_id = u.id    // u
+//
+func outerNames(sel *SqlSelect) []string {
+	names := []string{sel.From}
+	if sel.Alias != "" {
+		names = append(names, sel.Alias)
+	}
+	for _, join := range sel.Joins {
+		names = append(names, join.Table, join.Name())
+	}
+	outer := make([]string, 0)
+	add := func(name string) {
+		for _, known := range names {
+			if strings.EqualFold(known, name) {
+				return
+			}
+		}
+		outer = append(outer, name)
+	}
+	if sel.SubQuery != nil {
+		for _, name := range outerNames(sel.SubQuery) {
+			add(name)
+		}
+	}
+	for _, tree := range selectTrees(sel) {
+		if tree == nil || tree.Root == nil {
+			continue
+		}
+		Walk(tree.Root, func(n Node) {
+			switch nt := n.(type) {
+			case *IdentityNode:
+				if i := strings.IndexByte(nt.Text, '.'); i > 0 {
+					add(nt.Text[:i])
+				}
+			case *SubQueryNode:
+				for _, name := range outerNames(nt.Select) {
+					add(name)
+				}
+			}
+		})
+	}
+	return outer
+}
+
+// outerColumns are the unqualified columns of a select, or of its
+// subqueries, which are not declared columns of its tables in the Catalog,
+// nor aliases of its columns, so are those of an outer query.  Without a
+// Catalog there are none, outer columns must be qualified.
+//
+//    SELECT total FROM orders WHERE user_id = id    // id, of users
+//
+func (m *subQueries) outerColumns(sel *SqlSelect) []string {
+	if m.catalog == nil || sel.From == "" {
+		return nil
+	}
+	tables, err := m.catalog.selectTables(sel)
+	if err != nil {
+		m.fail(err)
+		return nil
+	}
+	aliases := make(map[string]bool, len(sel.Columns))
+	for _, col := range sel.Columns {
+		aliases[strings.ToLower(col.As)] = true
+	}
+	outer := make([]string, 0)
+	add := func(name string) {
+		if !aliases[strings.ToLower(name)] && tables.tablesColumn(name) != nil {
+			outer = append(outer, name)
+		}
+	}
+	for _, tree := range selectTrees(sel) {
+		if tree == nil || tree.Root == nil {
+			continue
+		}
+		Walk(tree.Root, func(n Node) {
+			switch nt := n.(type) {
+			case *IdentityNode:
+				if !nt.IsBooleanIdentity() && strings.IndexByte(nt.Text, '.') < 0 {
+					add(nt.Text)
+				}
+			case *SubQueryNode:
+				for _, name := range m.outerColumns(nt.Select) {
+					add(name)
+				}
+			}
+		})
+	}
+	return outer
+}
+
+// is the binary node  a IN (SELECT ...)?
+func inSubQuery(node *BinaryNode) (*SubQueryNode, bool) {
+	if node.Operator.T != ql.TokenIN {
+		return nil, false
+	}
+	sq, ok := node.Args[1].(*SubQueryNode)
+	return sq, ok
+}
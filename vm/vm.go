@@ -6,6 +6,7 @@ import (
 	"math"
 	"reflect"
 	"runtime"
+	"unicode/utf8"
 
 	u "github.com/araddon/gou"
 	ql "github.com/araddon/qlbridge/lex"
@@ -15,6 +16,8 @@ var (
 	ErrUnknownOp       = fmt.Errorf("expr: unknown op type")
 	ErrUnknownNodeType = fmt.Errorf("expr: unknown node type")
 	ErrExecute         = fmt.Errorf("Could not execute")
+	ErrAggregate       = fmt.Errorf("expr: aggregate outside of grouped select")
+	ErrStringOp        = fmt.Errorf("expr: operator is not defined for strings")
 	_                  = u.EMPTY
 
 	SchemaInfoEmpty = &NoSchema{}
@@ -26,6 +29,30 @@ type State struct {
 	rv     reflect.Value
 	Reader ContextReader
 	Writer ContextWriter
+	Params *Params             // values bound to ParamNode placeholders, may be nil
+	aggs   map[*FuncNode]Value // results of aggregate funcs for the group being output
+}
+
+// Params are the values bound to the ParamNode placeholders of a
+//  statement, positional ? $1 in Args, :name in Named
+type Params struct {
+	Args  []Value
+	Named map[string]Value
+}
+
+// Get the value bound to this param
+func (m *Params) Get(p *ParamNode) (Value, bool) {
+	if m == nil {
+		return nil, false
+	}
+	if p.Positional() {
+		if p.Index < 0 || p.Index >= len(m.Args) {
+			return nil, false
+		}
+		return m.Args[p.Index], true
+	}
+	v, ok := m.Named[p.Name]
+	return v, ok
 }
 
 func NewState(vm ExprVm, read ContextReader, write ContextWriter) *State {
@@ -70,7 +97,7 @@ func NewVm(expr string) (*Vm, error) {
 
 // Execute applies a parse expression to the specified context's
 func (m *Vm) Execute(writeContext ContextWriter, readContext ContextReader) (err error) {
-	//defer errRecover(&err)
+	defer errRecover(&err)
 	s := &State{
 		ExprVm: m,
 		Reader: readContext,
@@ -132,8 +159,24 @@ func (e *State) Walk(arg Node) (Value, bool) {
 		return e.walkFunc(argVal)
 	case *IdentityNode:
 		return e.walkIdentity(argVal)
+	case *ParamNode:
+		return e.Params.Get(argVal)
 	case *StringNode:
 		return NewStringValue(argVal.Text), true
+	case *SubQueryNode:
+		return e.walkSubQuery(argVal)
+	case *MultiArgNode:
+		return e.walkMultiArg(argVal), true
+	case *TriNode:
+		return e.walkTri(argVal), true
+	case *IsNullNode:
+		// a missing value is null
+		v, ok := e.Walk(argVal.Arg)
+		return NewBoolValue((!ok || isNullValue(v)) != argVal.Negated), true
+	case *NullNode:
+		return NewNilValue(), true
+	case *CaseNode:
+		return e.walkCase(argVal)
 	default:
 		u.Errorf("Unknonwn node type:  %T", argVal)
 		panic(ErrUnknownNodeType)
@@ -141,13 +184,27 @@ func (e *State) Walk(arg Node) (Value, bool) {
 }
 
 func (e *State) walkBinary(node *BinaryNode) Value {
+	if sq, ok := inSubQuery(node); ok {
+		return e.walkInSubQuery(node, sq)
+	}
+	if node.Operator.T == ql.TokenLike {
+		return e.walkLike(node)
+	}
 	ar, aok := e.Walk(node.Args[0])
 	br, bok := e.Walk(node.Args[1])
-	if !aok || !bok {
+	// a missing value is null
+	aNull, bNull := !aok || isNullValue(ar), !bok || isNullValue(br)
+	if v, ok := operateLogic(node, ar, br, aNull, bNull); ok {
+		return v
+	}
+	if aNull || bNull {
 		//u.Warnf("not ok: %v  l:%v  r:%v  %T  %T", node, ar, br, ar, br)
-		return nil
+		return NewNilValue()
 	}
 	//u.Debugf("walkBinary: %v  l:%v  r:%v  %T  %T", node, ar, br, ar, br)
+	if v, ok := operateStrings(node, ar, br); ok {
+		return v
+	}
 	switch at := ar.(type) {
 	case IntValue:
 		switch bt := br.(type) {
@@ -179,9 +236,9 @@ func (e *State) walkBinary(node *BinaryNode) Value {
 		switch bt := br.(type) {
 		case BoolValue:
 			switch node.Operator.T {
-			case ql.TokenLogicAnd:
+			case ql.TokenLogicAnd, ql.TokenAnd:
 				return NewBoolValue(at.v && bt.v)
-			case ql.TokenLogicOr:
+			case ql.TokenLogicOr, ql.TokenOr:
 				return NewBoolValue(at.v || bt.v)
 			case ql.TokenEqualEqual:
 				return NewBoolValue(at.v == bt.v)
@@ -196,25 +253,6 @@ func (e *State) walkBinary(node *BinaryNode) Value {
 			u.Errorf("at?%T  %v  coerce?%v bt? %T     %v", at, at.Value(), at.CanCoerce(stringRv), bt, bt.Value())
 			panic(ErrUnknownOp)
 		}
-	case StringValue:
-		if at.CanCoerce(int64Rv) {
-			switch bt := br.(type) {
-			case StringValue:
-				n := operateNumbers(node.Operator, at.NumberValue(), bt.NumberValue())
-				return n
-			case IntValue:
-				n := operateNumbers(node.Operator, at.NumberValue(), bt.NumberValue())
-				return n
-			case NumberValue:
-				n := operateNumbers(node.Operator, at.NumberValue(), bt)
-				return n
-			default:
-				u.Errorf("at?%T  %v  coerce?%v bt? %T     %v", at, at.Value(), at.CanCoerce(stringRv), bt, bt.Value())
-				panic(ErrUnknownOp)
-			}
-		} else {
-			u.Errorf("at?%T  %v  coerce?%v bt? %T     %v", at, at.Value(), at.CanCoerce(stringRv), br, br)
-		}
 		// case nil:
 		// 	// TODO, remove this case?  is this valid?  used?
 		// 	switch bt := br.(type) {
@@ -238,7 +276,45 @@ func (e *State) walkBinary(node *BinaryNode) Value {
 		panic(ErrUnknownOp)
 	}
 
-	return nil
+	return NewNilValue()
+}
+
+// operateLogic evaluates AND, OR with sql's three valued logic, where null
+// is UNKNOWN, returning false if the operands are not booleans or null
+//
+//    false AND null  => false        true OR null  => true
+//    true AND null   => null         false OR null => null
+//
+func operateLogic(node *BinaryNode, a, b Value, aNull, bNull bool) (Value, bool) {
+	var and bool
+	switch node.Operator.T {
+	case ql.TokenLogicAnd, ql.TokenAnd:
+		and = true
+	case ql.TokenLogicOr, ql.TokenOr:
+		if node.Concat {
+			// first_name || last_name
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	av, aBool := a.(BoolValue)
+	bv, bBool := b.(BoolValue)
+	aBool, bBool = aBool && !aNull, bBool && !bNull
+	if !(aBool || aNull) || !(bBool || bNull) {
+		return nil, false
+	}
+	switch {
+	case and && (aBool && !av.v || bBool && !bv.v):
+		return BoolValueFalse, true
+	case !and && (aBool && av.v || bBool && bv.v):
+		return BoolValueTrue, true
+	case aNull || bNull:
+		return NewNilValue(), true
+	case and:
+		return NewBoolValue(av.v && bv.v), true
+	}
+	return NewBoolValue(av.v || bv.v), true
 }
 
 func (e *State) walkIdentity(node *IdentityNode) (Value, bool) {
@@ -248,15 +324,20 @@ func (e *State) walkIdentity(node *IdentityNode) (Value, bool) {
 		return NewBoolValue(node.Bool()), true
 	}
 	//u.Debugf("walkIdentity() node=%T  %v", node, node)
-	return e.Reader.Get(node.Text)
+	v, ok := e.Reader.Get(node.Text)
+	if !ok || v == nil {
+		// missing, readers may return an empty string
+		return NewNilValue(), false
+	}
+	return v, true
 }
 
 func (e *State) walkUnary(node *UnaryNode) (Value, bool) {
 
 	a, ok := e.Walk(node.Arg)
-	if !ok {
-		u.Infof("whoops, %#v", node)
-		return a, false
+	if !ok || isNullValue(a) {
+		// NOT null, -null are null
+		return NewNilValue(), true
 	}
 	switch node.Operator.T {
 	case ql.TokenNegate:
@@ -279,8 +360,143 @@ func (e *State) walkUnary(node *UnaryNode) (Value, bool) {
 	return NewNilValue(), false
 }
 
+// walkMultiArg evaluates  a IN (1, 2, 3), true if a is equal to any of
+// the values, null if a is null, or it is not equal to any but one is null
+func (e *State) walkMultiArg(node *MultiArgNode) Value {
+	a, ok := e.Walk(node.Args[0])
+	if !ok || isNullValue(a) {
+		return NewNilValue()
+	}
+	hasNull := false
+	for _, arg := range node.Args[1:] {
+		v, ok := e.Walk(arg)
+		if !ok || isNullValue(v) {
+			hasNull = true
+		} else if CompareCollate(a, v, node.Collation) == 0 {
+			return NewBoolValue(!node.Negated)
+		}
+	}
+	if hasNull {
+		return NewNilValue()
+	}
+	return NewBoolValue(node.Negated)
+}
+
+// walkCase evaluates the whens of a CASE in order, and only the result
+// of the first that is true, a null operand or when is never equal
+func (e *State) walkCase(node *CaseNode) (Value, bool) {
+	var operand Value
+	if node.Operand != nil {
+		v, ok := e.Walk(node.Operand)
+		if !ok || isNullValue(v) {
+			return e.walkCaseElse(node)
+		}
+		operand = v
+	}
+	for i, when := range node.Whens {
+		v, ok := e.Walk(when)
+		if !ok || isNullValue(v) {
+			continue
+		}
+		if operand != nil {
+			if CompareCollate(operand, v, node.Collation) == 0 {
+				return e.Walk(node.Thens[i])
+			}
+		} else if bv, isBool := v.(BoolValue); isBool && bv.v {
+			return e.Walk(node.Thens[i])
+		}
+	}
+	return e.walkCaseElse(node)
+}
+
+func (e *State) walkCaseElse(node *CaseNode) (Value, bool) {
+	if node.Else == nil {
+		return NewNilValue(), true
+	}
+	return e.Walk(node.Else)
+}
+
+// walkTri evaluates  a BETWEEN x AND y, inclusive of x and y, null if
+// any is null
+func (e *State) walkTri(node *TriNode) Value {
+	var args [3]Value
+	for i, arg := range node.Args {
+		v, ok := e.Walk(arg)
+		if !ok || isNullValue(v) {
+			return NewNilValue()
+		}
+		args[i] = v
+	}
+	between := CompareCollate(args[0], args[1], node.Collation) >= 0 &&
+		CompareCollate(args[0], args[2], node.Collation) <= 0
+	return NewBoolValue(between != node.Negated)
+}
+
+// walkLike evaluates  a LIKE "pattern", null if either is null
+func (e *State) walkLike(node *BinaryNode) Value {
+	a, aok := e.Walk(node.Args[0])
+	b, bok := e.Walk(node.Args[1])
+	if !aok || !bok || isNullValue(a) || isNullValue(b) {
+		return NewNilValue()
+	}
+	escape := '\\'
+	if node.Escape != nil {
+		escape, _ = utf8.DecodeRuneInString(node.Escape.Text)
+	}
+	return NewBoolValue(likeMatch(a.ToString(), b.ToString(), escape) != node.Negated)
+}
+
+// likeMatch matches s to a LIKE pattern, where % is any characters, _ is
+// any one character, and the escape character makes the next one literal.
+// Matching is case sensitive.
+//
+//    "ab%"      abc, ab
+//    "a_c"      abc, but not ac
+//    "100\%"    100%
+//
+func likeMatch(s, pattern string, escape rune) bool {
+	str, pat := []rune(s), []rune(pattern)
+	si, pi := 0, 0
+	// position of the last %, and of s when it was reached, to backtrack to
+	lastPct, lastS := -1, 0
+	for si < len(str) {
+		if pi < len(pat) {
+			switch c := pat[pi]; {
+			case c == escape && pi+1 < len(pat):
+				if pat[pi+1] == str[si] {
+					si++
+					pi += 2
+					continue
+				}
+			case c == '%':
+				lastPct, lastS = pi, si
+				pi++
+				continue
+			case c == '_' || c == str[si]:
+				si++
+				pi++
+				continue
+			}
+		}
+		if lastPct < 0 {
+			return false
+		}
+		// let the last % match one more character
+		lastS++
+		si, pi = lastS, lastPct+1
+	}
+	for pi < len(pat) && pat[pi] == '%' {
+		pi++
+	}
+	return pi == len(pat)
+}
+
 func (e *State) walkFunc(node *FuncNode) (Value, bool) {
 
+	if node.Agg {
+		return e.walkAgg(node)
+	}
+
 	//u.Debugf("walk node --- %v   ", node.StringAST())
 
 	//we create a set of arguments to pass to the function, first arg
@@ -297,71 +513,106 @@ func (e *State) walkFunc(node *FuncNode) (Value, bool) {
 		case *StringNode: // String Literal
 			v = NewStringValue(t.Text)
 		case *IdentityNode: // Identity node = lookup in context
-
-			if t.IsBooleanIdentity() {
-				v = NewBoolValue(t.Bool())
-			} else {
-				v, ok = e.Reader.Get(t.Text)
-				if !ok {
-					// nil arguments are valid
-					v = NewNilValue()
-				}
-			}
-
+			// nil arguments are valid, a missing value is null
+			v, _ = e.walkIdentity(t)
 		case *NumberNode:
 			v = nodeToValue(t)
-		case *FuncNode:
-			//u.Debugf("descending to %v()", t.Name)
-			v, ok = e.walkFunc(t)
+		case *ParamNode:
+			v, ok = e.Params.Get(t)
 			if !ok {
-				return NewNilValue(), false
+				v = NewNilValue()
 			}
+		case *FuncNode:
+			//u.Debugf("descending to %v()", t.Name)
+			// null if it could not be evaluated
+			v, _ = e.walkFunc(t)
 			//u.Debugf("result of %v() = %v, %T", t.Name, v, v)
 			//v = extractScalar()
 		case *UnaryNode:
 			//v = extractScalar(e.walkUnary(t))
-			v, ok = e.walkUnary(t)
-			if !ok {
-				return NewNilValue(), false
-			}
+			v, _ = e.walkUnary(t)
 		case *BinaryNode:
 			//v = extractScalar(e.walkBinary(t))
 			v = e.walkBinary(t)
+		case *MultiArgNode, *TriNode, *IsNullNode, *NullNode, *SubQueryNode, *CaseNode:
+			v, ok = e.Walk(t)
+			if !ok {
+				v = NewNilValue()
+			}
 		default:
 			panic(fmt.Errorf("expr: unknown func arg type"))
 		}
 
 		if v == nil {
 			//u.Warnf("Nil vals?  %v  %T  arg:%T", v, v, a)
-			// What do we do with Nil Values?
-			switch a.(type) {
-			case *StringNode: // String Literal
-				u.Warnf("NOT IMPLEMENTED T:%T v:%v", a, a)
-			case *IdentityNode: // Identity node = lookup in context
-				v = NewStringValue("")
-			default:
-				u.Warnf("unknown type:  %v  %T", v, v)
-			}
-
-			funcArgs = append(funcArgs, reflect.ValueOf(v))
-		} else {
-			//u.Debugf(`found func arg:  key="%v"  %T  arg:%T`, v, v, a)
-			funcArgs = append(funcArgs, reflect.ValueOf(v))
+			// nil arguments are passed as null, not an empty string
+			v = NewNilValue()
 		}
-
+		//u.Debugf(`found func arg:  key="%v"  %T  arg:%T`, v, v, a)
+		funcArgs = append(funcArgs, reflect.ValueOf(v))
 	}
 	// Get the result of calling our Function (Value,bool)
 	//u.Debugf("Calling func:%v(%v)", node.F.Name, funcArgs)
 	fnRet := node.F.F.Call(funcArgs)
 	// check if has an error response?
 	if len(fnRet) > 1 && !fnRet[1].Bool() {
-		// What do we do if not ok?
-		return EmptyStringValue, false
+		// could not be evaluated, so is null
+		return NewNilValue(), false
 	}
 	//u.Debugf("response %v %v  %T", node.F.Name, fnRet[0].Interface(), fnRet[0].Interface())
 	return fnRet[0].Interface().(Value), true
 }
 
+// aggregates are not evaluated per row, their values for a group
+// are accumulated by SqlResults
+func (e *State) walkAgg(node *FuncNode) (Value, bool) {
+	if e.aggs == nil {
+		return e.walkUngroupedAgg(node)
+	}
+	v, ok := e.aggs[node]
+	return v, ok
+}
+
+// operateStrings evaluates a binary operation if either operand is a
+// string, returning false if neither is.  Strings which are both numbers,
+// such as "5" == 5, are compared as numbers, otherwise as strings in the
+// collation of the node, any other operator is an ErrStringOp.  If the
+// dialect concatenates with the operator
+//
+//    first_name || ' ' || last_name
+//
+func operateStrings(node *BinaryNode, a, b Value) (Value, bool) {
+	_, aStr := a.(StringValue)
+	_, bStr := b.(StringValue)
+	if node.Concat && (aStr || bStr || node.Operator.T == ql.TokenOr) {
+		return NewStringValue(a.ToString() + b.ToString()), true
+	}
+	if !aStr && !bStr {
+		return nil, false
+	}
+	af, aok := compareNumber(a)
+	bf, bok := compareNumber(b)
+	if aok && bok {
+		return operateNumbers(node.Operator, NewNumberValue(af), NewNumberValue(bf)), true
+	}
+	c := compareStrings(a.ToString(), b.ToString(), node.Collation)
+	switch node.Operator.T {
+	case ql.TokenEqualEqual, ql.TokenEqual:
+		return NewBoolValue(c == 0), true
+	case ql.TokenNE:
+		return NewBoolValue(c != 0), true
+	case ql.TokenGT:
+		return NewBoolValue(c > 0), true
+	case ql.TokenGE:
+		return NewBoolValue(c >= 0), true
+	case ql.TokenLT:
+		return NewBoolValue(c < 0), true
+	case ql.TokenLE:
+		return NewBoolValue(c <= 0), true
+	}
+	panic(ErrStringOp)
+}
+
 func operateNumbers(op ql.Token, av, bv NumberValue) Value {
 	switch op.T {
 	case ql.TokenPlus, ql.TokenStar, ql.TokenMultiply, ql.TokenDivide, ql.TokenMinus,
@@ -387,7 +638,7 @@ func operateNumbers(op ql.Token, av, bv NumberValue) Value {
 		return NewNumberValue(float64(int64(a) % int64(b)))
 
 	// Below here are Boolean Returns
-	case ql.TokenEqualEqual: //  ==
+	case ql.TokenEqualEqual, ql.TokenEqual: //  ==  or sql =
 		//u.Infof("==?  %v  %v", av, bv)
 		if a == b {
 			return BoolValueTrue
@@ -468,7 +719,7 @@ func operateInts(op ql.Token, av, bv IntValue) Value {
 		return NewIntValue(a % b)
 
 	// Below here are Boolean Returns
-	case ql.TokenEqualEqual: //  ==
+	case ql.TokenEqualEqual, ql.TokenEqual: //  ==  or sql =
 		if a == b {
 			return BoolValueTrue
 		} else {
@@ -3,6 +3,8 @@ package vm
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 
 	u "github.com/araddon/gou"
 	ql "github.com/araddon/qlbridge/lex"
@@ -10,6 +12,8 @@ import (
 
 var (
 	SqlEvalError = fmt.Errorf("Could not evaluate sql statement")
+	// a scalar subquery returned more than one row
+	SqlSubQueryRowsError = fmt.Errorf("subquery returns more than 1 row")
 )
 
 // SqlVm vm is a vm for parsing, evaluating a
@@ -20,31 +24,118 @@ type SqlVm struct {
 	sel       *SqlSelect
 	ins       *SqlInsert
 	del       *SqlDelete
+	upd       *SqlUpdate
+	show      *SqlShow
+	desc      *SqlDescribe
+	params    []*ParamNode // bind param placeholders, in order of appearance
+	bound     Params
+	Catalog   *Catalog // the tables, variables for SHOW, DESCRIBE
+	// Sources are the rows of the tables read by subqueries, by table name,
+	// each read only once per execution
+	Sources map[string]RowScanner
+	subs    *subQueries // subquery rows, results of this execution
 }
 
 // SqlVm parsers a sql query into columns, where guards, etc
 //
 func NewSqlVm(sqlText string) (*SqlVm, error) {
+	return NewSqlVmDialect(sqlText, ql.SqlDialect)
+}
+
+// NewSqlVmDialect parses a sql query in a Dialect, whose rules for strings,
+// such as how they are compared, differ from the default SqlDialect
+//
+//    nocase := &ql.Dialect{Statements: ql.SqlDialect.Statements, Collation: ql.CollateNoCase}
+//    sqlVm, _ := NewSqlVmDialect(`SELECT name FROM users ORDER BY name`, nocase)
+//
+func NewSqlVmDialect(sqlText string, dialect *ql.Dialect) (*SqlVm, error) {
 
-	stmt, err := ParseSqlVm(sqlText)
+	stmt, err := ParseSqlVmDialect(sqlText, dialect)
 	if err != nil {
 		return nil, err
 	}
+	return NewSqlVmStatement(stmt), nil
+}
+
+// NewSqlVmStatement creates a vm for an already parsed statement, such
+// as those from ParseScript
+//
+func NewSqlVmStatement(stmt SqlStatement) *SqlVm {
 	m := &SqlVm{
 		Statement: stmt,
+		params:    statementParams(stmt),
 	}
 	switch v := stmt.(type) {
 	case *SqlSelect:
 		m.Keyword = ql.TokenSelect
 		m.sel = v
 	case *SqlInsert:
-		m.Keyword = ql.TokenInsert
+		m.Keyword = v.Keyword()
 		m.ins = v
 	case *SqlDelete:
 		m.Keyword = ql.TokenDelete
 		m.del = v
+	case *SqlUpdate:
+		m.Keyword = ql.TokenUpdate
+		m.upd = v
+	case *SqlShow:
+		m.Keyword = ql.TokenShow
+		m.show = v
+	case *SqlDescribe:
+		m.Keyword = ql.TokenDescribe
+		m.desc = v
+	default:
+		m.Keyword = stmt.Keyword()
+	}
+	return m
+}
+
+// Bind sets the values of the positional ? or $1 params of the statement,
+//  so it may be parsed once and executed many times.  Values are used as is,
+//  never parsed as sql.  Bind, and Execute are not safe for concurrent use.
+//
+//     sqlVm, _ := NewSqlVm("SELECT name FROM users WHERE id = ?")
+//     err := sqlVm.Bind(NewIntValue(5))
+//
+func (m *SqlVm) Bind(args ...Value) error {
+	want := 0
+	for _, p := range m.params {
+		if p.Positional() && p.Index+1 > want {
+			want = p.Index + 1
+		}
 	}
-	return m, nil
+	if len(args) != want {
+		return fmt.Errorf("expected %d params but got %d", want, len(args))
+	}
+	m.bound.Args = args
+	m.subs = nil
+	return nil
+}
+
+// BindNamed sets the values of the :name params of the statement
+//
+//     sqlVm, _ := NewSqlVm("SELECT name FROM users WHERE id = :id")
+//     err := sqlVm.BindNamed(map[string]Value{"id": NewIntValue(5)})
+//
+func (m *SqlVm) BindNamed(args map[string]Value) error {
+	for _, p := range m.params {
+		if _, ok := args[p.Name]; !p.Positional() && !ok {
+			return fmt.Errorf("no value for param %s", p.Text)
+		}
+	}
+	m.bound.Named = args
+	m.subs = nil
+	return nil
+}
+
+// ensure every param has a bound value
+func (m *SqlVm) checkParams() error {
+	for _, p := range m.params {
+		if _, ok := m.bound.Get(p); !ok {
+			return fmt.Errorf("no value bound for param %s", p.Text)
+		}
+	}
+	return nil
 }
 
 // Execute applies a parse expression to the specified context's
@@ -57,14 +148,29 @@ func (m *SqlVm) Execute(writeContext ContextWriter, readContext ContextReader) (
 	switch m.Keyword {
 	case ql.TokenSelect:
 		return m.ExecuteSelect(writeContext, readContext)
-	case ql.TokenInsert:
+	case ql.TokenInsert, ql.TokenUpsert:
 		if rowWriter, ok := writeContext.(RowWriter); ok {
+			if m.ins.Select != nil {
+				return m.ExecuteInsertSelect(rowWriter, readContext)
+			}
 			return m.ExecuteInsert(rowWriter)
 		} else {
 			return fmt.Errorf("Must implement RowWriter: %T", writeContext)
 		}
 	case ql.TokenDelete:
 		return m.ExecuteDelete(writeContext, readContext)
+	case ql.TokenUpdate:
+		if updater, ok := writeContext.(RowUpdater); ok {
+			return m.ExecuteUpdate(updater, readContext)
+		} else {
+			return fmt.Errorf("Must implement RowUpdater: %T", writeContext)
+		}
+	case ql.TokenShow, ql.TokenDescribe:
+		if rowWriter, ok := writeContext.(RowWriter); ok {
+			return m.ExecuteShow(rowWriter)
+		} else {
+			return fmt.Errorf("Must implement RowWriter: %T", writeContext)
+		}
 	default:
 		u.Warnf("not implemented: %v", m.Keyword)
 		return fmt.Errorf("not implemented %v", m.Keyword)
@@ -78,32 +184,64 @@ func (m *SqlVm) Execute(writeContext ContextWriter, readContext ContextReader) (
 //       or for delete, insert, update it is like the storage layer
 //
 func (m *SqlVm) ExecuteSelect(writeContext ContextWriter, readContext ContextReader) (err error) {
-	//defer errRecover(&err)
-	s := &State{
-		ExprVm: m,
-		Reader: readContext,
+	if err := m.sel.checkSingleSource(); err != nil {
+		return err
 	}
-	s.rv = reflect.ValueOf(s)
+	_, err = m.executeSelect(writeContext, readContext)
+	return err
+}
+
+// executeSelect evaluates the select for a single row, matched is false
+// if the row was filtered out by the where
+func (m *SqlVm) executeSelect(writeContext ContextWriter, readContext ContextReader) (matched bool, err error) {
+	defer errRecover(&err)
+	if err := m.checkParams(); err != nil {
+		return false, err
+	}
+	s := m.newState(readContext)
+
+	if matched, err := m.matchWhere(s, m.sel.Where); !matched {
+		return false, err
+	}
+	m.writeColumns(s, writeContext, readContext)
+	if err := m.subQueryErr(); err != nil {
+		return false, err
+	}
+
+	//writeContext.Put()
+	return true, nil
+}
+
+// matchWhere evaluates the where guard against the row of the state,
+// matching if there is no where.  As in sql only true matches, a null
+// (UNKNOWN), such as  missing_col > 5, is filtered out like false.
+func (m *SqlVm) matchWhere(s *State, where *Tree) (bool, error) {
 
 	// Check and see if we are where Guarded
-	if m.sel.Where != nil {
+	if where != nil {
 		//u.Debugf("Has a Where:  %v", m.Request.Where.Root.StringAST())
-		whereValue, ok := s.Walk(m.sel.Where.Root)
-		if !ok {
-			return SqlEvalError
+		whereValue, ok := s.Walk(where.Root)
+		if err := m.subQueryErr(); err != nil {
+			return false, err
 		}
-		switch whereVal := whereValue.(type) {
-		case BoolValue:
-			if whereVal == BoolValueFalse {
-				u.Debugf("Filtering out")
-				return nil
-			}
+		if whereVal, isBool := whereValue.(BoolValue); !ok || !isBool || !whereVal.v {
+			u.Debugf("Filtering out")
+			return false, nil
 		}
 		//u.Debugf("Matched where: %v", whereValue)
 	}
+	return true, nil
+}
+
+// writeColumns evaluates the select columns, writing them to writeContext,
+// skipping columns whose IF guard does not evaluate true
+func (m *SqlVm) writeColumns(s *State, writeContext ContextWriter, readContext ContextReader) {
 	for _, col := range m.sel.Columns {
 		if col.Guard != nil {
-			// TODO:  evaluate if guard
+			v, ok := s.Walk(col.Guard.Root)
+			if bv, isBool := v.(BoolValue); !ok || !isBool || !bv.v {
+				continue
+			}
 		}
 		if col.Star {
 			for k, v := range readContext.Row() {
@@ -118,41 +256,335 @@ func (m *SqlVm) ExecuteSelect(writeContext ContextWriter, readContext ContextRea
 		}
 
 	}
+}
 
-	//writeContext.Put()
-	return
+func (m *SqlVm) newState(readContext ContextReader) *State {
+	s := &State{
+		ExprVm: m,
+		Reader: readContext,
+		Params: &m.bound,
+	}
+	s.rv = reflect.ValueOf(s)
+	return s
 }
 
+// ExecuteInsert writes the rows of an insert.  For an upsert, or insert with
+//  on duplicate key update, the writer must be a RowKeyWriter and rows with
+//  the key of an existing row update it instead.  Expressions in the values
+//  are evaluated for each execution.
+//
+//     UPSERT INTO profiles (user_id, name) VALUES (5, "bob")
+//     INSERT INTO profiles (user_id, ct) VALUES (5, 1) ON DUPLICATE KEY UPDATE ct = ct + 1
+//     INSERT INTO events (name, created) VALUES (tolower("Login"), now())
+//
 func (m *SqlVm) ExecuteInsert(writeContext RowWriter) (err error) {
+	defer errRecover(&err)
 
-	for _, row := range m.ins.Rows {
+	if m.ins.Select != nil {
+		return fmt.Errorf("insert select must be executed against a RowScanner")
+	}
+	if err := m.checkParams(); err != nil {
+		return err
+	}
+	keyWriter, err := m.insertKeyWriter(writeContext)
+	if err != nil {
+		return err
+	}
+	for ri, row := range m.ins.Rows {
+		row, err = m.evalRow(ri, row)
+		if err != nil {
+			return err
+		}
+		if err := m.insertRow(writeContext, keyWriter, row); err != nil {
+			return err
+		}
+	}
+	return
+}
 
-		for i, col := range m.ins.Columns {
+// ExecuteInsertSelect executes the select of an INSERT ... SELECT against
+//  each row of the readContext, which must be a RowScanner, and writes its
+//  output rows.  Select columns are inserted into the insert columns by
+//  position.  All of the input rows are selected before the first output
+//  row is written, so the writer may also be the reader.
+//
+//     INSERT INTO archive (user_id, name) SELECT user_id, name FROM users WHERE deleted = true
+//
+func (m *SqlVm) ExecuteInsertSelect(writeContext RowWriter, readContext ContextReader) (err error) {
+	defer errRecover(&err)
 
-			//u.Debugf("tree.Root: i, as, val:  %v %v %v", i, col.As, row[i])
-			if col.Tree != nil && col.Tree.Root != nil {
-				u.Warnf("Not implemented")
+	scanner, ok := readContext.(RowScanner)
+	if !ok {
+		return fmt.Errorf("Must implement RowScanner: %T", readContext)
+	}
+	if len(m.ins.Select.Joins) > 0 {
+		return fmt.Errorf("insert select of joined tables is not supported")
+	}
+	if err := m.checkParams(); err != nil {
+		return err
+	}
+	keyWriter, err := m.insertKeyWriter(writeContext)
+	if err != nil {
+		return err
+	}
+	if err := m.ins.Select.checkSingleSource(); err != nil {
+		return err
+	}
+	selVm := NewSqlVmStatement(m.ins.Select)
+	selVm.bound = m.bound
+	results, err := selVm.NewResults()
+	if err != nil {
+		return err
+	}
+	var selected []map[string]Value
+	if results.grouped || len(results.orderBy) > 0 {
+		for row := scanner.Next(); row != nil; row = scanner.Next() {
+			if err := results.Add(NewContextSimpleTs(row, readContext.Ts())); err != nil {
+				return err
+			}
+		}
+		selected = results.Rows()
+	} else {
+		for row := scanner.Next(); row != nil; row = scanner.Next() {
+			if results.limit > 0 && len(selected) >= results.limit {
+				break
+			}
+			out := NewContextSimple()
+			matched, err := selVm.executeSelect(out, NewContextSimpleTs(row, readContext.Ts()))
+			if err != nil {
+				return err
+			} else if matched {
+				selected = append(selected, out.Data)
 			}
-			//v, ok := s.Walk(col.Tree.Root)
-			writeContext.Put(col, nil, row[i])
+		}
+	}
+	for _, out := range selected {
+		if err := m.insertRow(writeContext, keyWriter, m.selectedRow(out)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the writer as a RowKeyWriter, which an upsert or on duplicate key update
+// requires, nil if it isn't one
+func (m *SqlVm) insertKeyWriter(writeContext RowWriter) (RowKeyWriter, error) {
+	keyWriter, isKeyWriter := writeContext.(RowKeyWriter)
+	if (m.Keyword == ql.TokenUpsert || len(m.ins.OnDuplicate) > 0) && !isKeyWriter {
+		return nil, fmt.Errorf("Must implement RowKeyWriter: %T", writeContext)
+	}
+	return keyWriter, nil
+}
+
+// insertRow writes a row of values of the insert columns, unless it has
+// the key of an existing row to update instead
+func (m *SqlVm) insertRow(writeContext RowWriter, keyWriter RowKeyWriter, row []Value) error {
+	if keyWriter != nil {
+		updated, err := m.updateDuplicate(keyWriter, row)
+		if err != nil || updated {
+			return err
+		}
+	}
+	for i, col := range m.ins.Columns {
+		writeContext.Put(col, nil, row[i])
+	}
+	writeContext.Commit(nil, writeContext)
+	return nil
+}
+
+// the values of the insert columns from an output row of the insert select
+func (m *SqlVm) selectedRow(out map[string]Value) []Value {
+	row := make([]Value, len(m.ins.Columns))
+	for i, col := range m.ins.Columns {
+		name := col.As
+		if !m.ins.Select.Star {
+			name = m.ins.Select.Columns[i].As
+		}
+		v, ok := out[name]
+		if !ok || v == nil {
+			v = NewNilValue()
+		}
+		row[i] = v
+	}
+	return row
+}
+
+// updateDuplicate updates the existing row with the same key as the insert
+// row, if any, with the upsert values or on duplicate key update values
+func (m *SqlVm) updateDuplicate(writeContext RowKeyWriter, row []Value) (bool, error) {
+	if m.Keyword != ql.TokenUpsert && len(m.ins.OnDuplicate) == 0 {
+		return false, nil
+	}
+	values := make(map[string]Value, len(m.ins.Columns))
+	for i, col := range m.ins.Columns {
+		values[col.As] = row[i]
+	}
+	existing, ok := writeContext.RowForKey(values)
+	if !ok {
+		return false, nil
+	}
+	if m.Keyword == ql.TokenUpsert {
+		return true, writeContext.Update(existing, values)
+	}
+	// evaluate against the existing row
+	s := m.newState(NewContextSimpleData(existing))
+	set := make(map[string]Value, len(m.ins.OnDuplicate))
+	for _, col := range m.ins.OnDuplicate {
+		// a missing column is null, as it is in a WHERE
+		v, ok := s.Walk(col.Tree.Root)
+		if err := m.subQueryErr(); err != nil {
+			return false, err
+		} else if !ok || v == nil {
+			v = NewNilValue()
+		}
+		set[col.As] = v
+	}
+	return true, writeContext.Update(existing, set)
+}
+
+// copy of an insert row with its expressions, and bound params, evaluated
+func (m *SqlVm) evalRow(ri int, row []Value) ([]Value, error) {
+	var evaluated []Value
+	var s *State
+	for _, ie := range m.ins.exprs {
+		if ie.row != ri {
+			continue
+		}
+		if evaluated == nil {
+			evaluated = make([]Value, len(row))
+			copy(evaluated, row)
+			s = m.newState(NewContextSimple())
+		}
+		v, ok := s.Walk(ie.node)
+		if err := m.subQueryErr(); err != nil {
+			return nil, err
+		} else if !ok || v == nil {
+			v = NewNilValue()
+		}
+		evaluated[ie.col] = v
+	}
+	if evaluated == nil {
+		return row, nil
+	}
+	return evaluated, nil
+}
+
+// ExecuteShow writes a row to the writer for each table, column, function
+//  or variable of a SHOW or DESCRIBE.  Tables, columns and variables are from
+//  the Catalog of the vm, functions are the registered funcs and aggregates.
+//
+//     SHOW TABLES                  Table
+//     SHOW COLUMNS FROM users      Field, Type, Null, Key, Default
+//     DESCRIBE users               Field, Type, Null, Key, Default
+//     SHOW FUNCTIONS               Function, Type, Returns
+//     SHOW VARIABLES               Variable_name, Value
+//
+func (m *SqlVm) ExecuteShow(writeContext RowWriter) error {
+
+	what, table := "columns", ""
+	if m.desc != nil {
+		table = m.desc.Identity
+	} else {
+		what, table = strings.ToLower(m.show.Identity), m.show.From
+	}
+	var names []string
+	put := func(vals ...Value) {
+		for i, v := range vals {
+			writeContext.Put(&Column{As: names[i]}, nil, v)
 		}
 		writeContext.Commit(nil, writeContext)
+	}
 
+	switch what {
+	case "tables":
+		if m.Catalog == nil {
+			return fmt.Errorf("no catalog to show tables of")
+		}
+		names = []string{"Table"}
+		for _, tbl := range m.Catalog.Tables() {
+			put(NewStringValue(tbl.Name))
+		}
+	case "columns":
+		if m.Catalog == nil {
+			return fmt.Errorf("no catalog to show columns of")
+		}
+		tbl, err := m.Catalog.table(table)
+		if err != nil {
+			return err
+		}
+		names = []string{"Field", "Type", "Null", "Key", "Default"}
+		for _, col := range tbl.Columns {
+			null, key := "YES", ""
+			if col.NotNull {
+				null = "NO"
+			}
+			for _, pk := range tbl.PrimaryKey {
+				if strings.EqualFold(pk, col.Name) {
+					null, key = "NO", "PRI"
+				}
+			}
+			var def Value = NewNilValue()
+			if col.Default != nil {
+				def = NewStringValue(col.Default.Root.StringAST())
+			}
+			put(NewStringValue(col.Name), NewStringValue(col.TypeString()),
+				NewStringValue(null), NewStringValue(key), def)
+		}
+	case "functions":
+		names = []string{"Function", "Type", "Returns"}
+		funcMu.RLock()
+		fnNames := make([]string, 0, len(funcs)+len(aggFuncs))
+		returns := make(map[string]Value, len(funcs)+len(aggFuncs))
+		for name, fn := range funcs {
+			fnNames = append(fnNames, name)
+			returns[name] = NewStringValue(fn.ReturnValueType.String())
+		}
+		for name := range aggFuncs {
+			// a func of the same name takes precedence over the aggregate
+			if _, exists := returns[name]; !exists {
+				fnNames = append(fnNames, name)
+				returns[name] = nil
+			}
+		}
+		funcMu.RUnlock()
+		sort.Strings(fnNames)
+		for _, name := range fnNames {
+			if returns[name] == nil {
+				put(NewStringValue(name), NewStringValue("aggregate"), NewNilValue())
+			} else {
+				put(NewStringValue(name), NewStringValue("function"), returns[name])
+			}
+		}
+	case "variables":
+		if m.Catalog == nil {
+			return fmt.Errorf("no catalog to show variables of")
+		}
+		names = []string{"Variable_name", "Value"}
+		vars := m.Catalog.Variables()
+		varNames := make([]string, 0, len(vars))
+		for name := range vars {
+			varNames = append(varNames, name)
+		}
+		sort.Strings(varNames)
+		for _, name := range varNames {
+			put(NewStringValue(name), vars[name])
+		}
+	default:
+		return fmt.Errorf("cannot show %s", m.show.Identity)
 	}
-	return
+	return nil
 }
 
 func (m *SqlVm) ExecuteDelete(writeContext ContextWriter, readContext ContextReader) (err error) {
-	//defer errRecover(&err)
+	defer errRecover(&err)
 	scanner, ok := readContext.(RowScanner)
 	if !ok {
 		return fmt.Errorf("Must implement RowScanner: %T", writeContext)
 	}
-	s := &State{
-		ExprVm: m,
-		Reader: readContext,
+	if err := m.checkParams(); err != nil {
+		return err
 	}
-	s.rv = reflect.ValueOf(s)
+	s := m.newState(readContext)
 
 	// Check and see if we are where Guarded
 	if m.del.Where != nil {
@@ -186,3 +618,49 @@ func (m *SqlVm) ExecuteDelete(writeContext ContextWriter, readContext ContextRea
 
 	return
 }
+
+// ExecuteUpdate scans the rows of readContext, which must be a RowScanner,
+//  setting the new values of each row matching the where, up to the limit
+//
+//     UPDATE users SET visits = visits + 1 WHERE user_id = 5
+//
+func (m *SqlVm) ExecuteUpdate(writeContext RowUpdater, readContext ContextReader) (err error) {
+	defer errRecover(&err)
+	scanner, ok := readContext.(RowScanner)
+	if !ok {
+		return fmt.Errorf("Must implement RowScanner: %T", readContext)
+	}
+	if err := m.checkParams(); err != nil {
+		return err
+	}
+
+	updated := 0
+	for row := scanner.Next(); row != nil; row = scanner.Next() {
+		if m.upd.Limit > 0 && updated >= m.upd.Limit {
+			break
+		}
+		s := m.newState(NewContextSimpleTs(row, readContext.Ts()))
+		if matched, err := m.matchWhere(s, m.upd.Where); err != nil {
+			return err
+		} else if !matched {
+			continue
+		}
+		// evaluate all values against the row before setting any
+		set := make(map[string]Value, len(m.upd.Columns))
+		for _, col := range m.upd.Columns {
+			// a missing column is null, as it is in the WHERE
+			v, ok := s.Walk(col.Tree.Root)
+			if err := m.subQueryErr(); err != nil {
+				return err
+			} else if !ok || v == nil {
+				v = NewNilValue()
+			}
+			set[col.As] = v
+		}
+		if err := writeContext.Update(row, set); err != nil {
+			return err
+		}
+		updated++
+	}
+	return nil
+}
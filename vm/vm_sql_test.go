@@ -2,7 +2,9 @@ package vm
 
 import (
 	u "github.com/araddon/gou"
+	ql "github.com/araddon/qlbridge/lex"
 	"github.com/bmizerany/assert"
+	"strings"
 	"testing"
 	"time"
 )
@@ -140,6 +142,1072 @@ func TestSqlDelete(t *testing.T) {
 	assert.Tf(t, db.Rows[0]["name"].ToString() == "allison", "%v", db.Rows)
 }
 
+func TestSqlUpdate(t *testing.T) {
+
+	stmt, err := ParseSql(`UPDATE users SET name = 'bob', visits = toint(visits) + 1 WHERE user_id > 5 LIMIT 2`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	upd := stmt.(*SqlUpdate)
+	assert.Tf(t, upd.Table == "users" && upd.Limit == 2 && upd.Where != nil, "%#v", upd)
+	assert.Tf(t, len(upd.Columns) == 2 && upd.Columns[1].As == "visits", "%v", upd.Columns)
+	assert.Tf(t, upd.Columns[1].Tree.Root.StringAST() == "toint(visits) + 1", "%v", upd.Columns[1].Tree.Root.StringAST())
+
+	_, err = ParseSql(`UPDATE users name = 'bob'`)
+	assert.Tf(t, err != nil, "missing SET should err")
+
+	db := NewContextSimple()
+	for i := int64(4); i < 9; i++ {
+		db.Insert(map[string]Value{
+			"user_id": NewIntValue(i),
+			"name":    NewStringValue("user"),
+			"visits":  NewIntValue(i * 10),
+		})
+	}
+	sqlVm, err := NewSqlVm(`UPDATE users SET name = 'bob', visits = visits + 1 WHERE user_id > 5 LIMIT 2`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	err = sqlVm.Execute(db, db)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+
+	names := make([]string, 0)
+	for _, row := range db.Rows {
+		names = append(names, row["name"].ToString())
+	}
+	assert.Equalf(t, names, []string{"user", "user", "bob", "bob", "user"}, "updated 2: %v", names)
+	assert.Tf(t, db.Rows[2]["visits"].Value().(int64) == 61, "%v", db.Rows[2])
+	assert.Tf(t, db.Rows[4]["visits"].Value().(int64) == 80, "limit 2 %v", db.Rows[4])
+
+	// a sparse column is null in the rows missing it
+	db.Rows[4]["email"] = NewStringValue("joe@example.com")
+	db.Rewind()
+	sqlVm, err = NewSqlVm(`UPDATE users SET contact = email WHERE user_id > 6`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	err = sqlVm.Execute(db, db)
+	assert.Tf(t, err == nil, "Should not err on a missing column %v", err)
+	assert.Tf(t, db.Rows[3]["contact"].Type() == NilType, "%v", db.Rows[3])
+	assert.Tf(t, db.Rows[4]["contact"].Value() == "joe@example.com", "%v", db.Rows[4])
+
+	err = sqlVm.Execute(db, NewContextUrlValues(nil))
+	assert.Tf(t, err != nil, "must be a RowScanner")
+}
+
+func TestSqlUpsert(t *testing.T) {
+
+	stmt, err := ParseSql(`INSERT INTO profiles (user_id, ct) VALUES (5, 1) ON DUPLICATE KEY UPDATE ct = ct + 1, seen = true`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	ins := stmt.(*SqlInsert)
+	assert.Tf(t, ins.Keyword() == ql.TokenInsert && len(ins.OnDuplicate) == 2, "%#v", ins)
+	assert.Tf(t, ins.OnDuplicate[0].As == "ct" && ins.OnDuplicate[0].Tree.Root.StringAST() == "ct + 1", "%v", ins.OnDuplicate)
+
+	stmt, err = ParseSql(`UPSERT INTO profiles (user_id, name) VALUES (5, "bob")`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	assert.Tf(t, stmt.Keyword() == ql.TokenUpsert, "%v", stmt.Keyword())
+
+	db := NewContextSimple()
+	db.Key = []string{"user_id"}
+	exec := func(sql string) {
+		sqlVm, err := NewSqlVm(sql)
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		assert.Tf(t, sqlVm.Execute(db, db) == nil, "execute %v", sql)
+	}
+
+	// each event increments the count of the profile
+	for _, userId := range []string{"5", "6", "5", "5"} {
+		exec(`INSERT INTO profiles (user_id, ct) VALUES (` + userId + `, 1) ON DUPLICATE KEY UPDATE ct = ct + 1`)
+	}
+	assert.Tf(t, len(db.Rows) == 2, "2 profiles %v", db.Rows)
+	assert.Tf(t, db.Rows[0]["ct"].Value().(int64) == 3, "%v", db.Rows[0])
+	assert.Tf(t, db.Rows[1]["ct"].Value().(int64) == 1, "%v", db.Rows[1])
+
+	exec(`UPSERT INTO profiles (user_id, name) VALUES (6, "bob"), (7, "alice")`)
+	assert.Tf(t, len(db.Rows) == 3, "3 profiles %v", db.Rows)
+	assert.Tf(t, db.Rows[1]["name"].ToString() == "bob" && db.Rows[1]["ct"].Value().(int64) == 1, "merged %v", db.Rows[1])
+	assert.Tf(t, db.Rows[2]["name"].ToString() == "alice", "%v", db.Rows[2])
+
+	// keys compare by value, "7" is the same key as 7
+	exec(`UPSERT INTO profiles (user_id, name) VALUES ("7", "al")`)
+	assert.Tf(t, len(db.Rows) == 3 && db.Rows[2]["name"].ToString() == "al", "%v", db.Rows)
+
+	// writer that doesn't know keys
+	sqlVm, _ := NewSqlVm(`UPSERT INTO profiles (user_id, name) VALUES (6, "bob")`)
+	err = sqlVm.Execute(NewContextUrlValues(nil), db)
+	assert.Tf(t, err != nil, "must be a RowKeyWriter")
+}
+
+func TestSqlInsertSelect(t *testing.T) {
+
+	// values are expressions, evaluated at execution
+	sqlVm, err := NewSqlVm(`INSERT INTO users (user_id, ct, score) VALUES (toint("5") + 1, (1 + 2) * 3, -1.5), (?, 2, "x")`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	assert.Tf(t, sqlVm.Bind(NewIntValue(7)) == nil, "bind")
+	users := NewContextSimple()
+	err = sqlVm.Execute(users, nil)
+	assert.Tf(t, err == nil, "non nil err: %v", err)
+	assert.Tf(t, len(users.Rows) == 2, "must have 2 rows: %v", users.Rows)
+	assert.Tf(t, users.Rows[0]["user_id"].Value() == int64(6), "%v", users.Rows[0])
+	assert.Tf(t, users.Rows[0]["ct"].Value() == int64(9), "%v", users.Rows[0])
+	assert.Tf(t, users.Rows[0]["score"].Value() == float64(-1.5), "%v", users.Rows[0])
+	assert.Tf(t, users.Rows[1]["user_id"].Value() == int64(7), "%v", users.Rows[1])
+
+	_, err = ParseSql(`INSERT INTO users (user_id, ct) VALUES (1, 2 +)`)
+	assert.Tf(t, err != nil, "must err on bad value expression")
+
+	stmt, err := ParseSql(`INSERT INTO archive (id, total) SELECT user_id, ct * 2 FROM users WHERE ct > 5`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	ins := stmt.(*SqlInsert)
+	assert.Tf(t, ins.Select != nil && ins.Select.Where != nil && len(ins.Rows) == 0, "%#v", ins)
+
+	_, err = ParseSql(`INSERT INTO archive (id, total) SELECT user_id FROM users`)
+	assert.Tf(t, err != nil, "must err on column count mismatch")
+
+	// output rows are inserted into the writer
+	sqlVm, _ = NewSqlVm(`INSERT INTO archive (id, total) SELECT user_id, ct * ? AS total FROM users WHERE ct > 5`)
+	assert.Tf(t, sqlVm.Bind(NewIntValue(2)) == nil, "bind")
+	archive := NewContextSimple()
+	err = sqlVm.Execute(archive, users)
+	assert.Tf(t, err == nil, "non nil err: %v", err)
+	assert.Tf(t, len(archive.Rows) == 1, "must have 1 row: %v", archive.Rows)
+	assert.Tf(t, archive.Rows[0]["id"].Value() == int64(6), "%v", archive.Rows[0])
+	assert.Tf(t, archive.Rows[0]["total"].Value() == int64(18), "%v", archive.Rows[0])
+
+	// grouped selects insert a row per group
+	fresh := func() *ContextSimple {
+		cs := NewContextSimple()
+		for _, row := range users.Rows {
+			cs.Insert(row)
+		}
+		return cs
+	}
+	sqlVm, _ = NewSqlVm(`INSERT INTO totals (n, ct) SELECT count(*), sum(ct) FROM users`)
+	totals := NewContextSimple()
+	err = sqlVm.Execute(totals, fresh())
+	assert.Tf(t, err == nil, "non nil err: %v", err)
+	assert.Tf(t, len(totals.Rows) == 1, "must have 1 row: %v", totals.Rows)
+	assert.Tf(t, totals.Rows[0]["n"].Value() == int64(2) && totals.Rows[0]["ct"].Value() == int64(11), "%v", totals.Rows[0])
+
+	err = sqlVm.Execute(totals, NewContextUrlValues(nil))
+	assert.Tf(t, err != nil, "must be a RowScanner")
+
+	// inserting into the table being selected from only sees the original rows
+	self := fresh()
+	sqlVm, _ = NewSqlVm(`INSERT INTO users (user_id, ct) SELECT user_id + 100, ct FROM users`)
+	err = sqlVm.Execute(self, self)
+	assert.Tf(t, err == nil, "non nil err: %v", err)
+	assert.Tf(t, len(self.Rows) == 4, "must have 4 rows: %v", self.Rows)
+	assert.Tf(t, self.Rows[2]["user_id"].Value() == int64(106), "%v", self.Rows[2])
+}
+
+func TestSqlCreateTable(t *testing.T) {
+
+	stmt, err := ParseSql(`CREATE TABLE users (
+		user_id BIGINT NOT NULL,
+		name VARCHAR(255) DEFAULT "anon" NOT NULL,
+		ct BIGINT DEFAULT 0,
+		bio TEXT,
+		PRIMARY KEY (user_id)
+	)`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	create := stmt.(*SqlCreate)
+	assert.Tf(t, create.Keyword() == ql.TokenCreate && create.Table == "users", "%#v", create)
+	assert.Tf(t, len(create.Columns) == 4 && len(create.PrimaryKey) == 1, "%#v", create)
+	assert.Tf(t, create.Columns[1].String() == `name VARCHAR(255) NOT NULL DEFAULT "anon"`, "%v", create.Columns[1])
+	assert.Tf(t, create.Columns[0].ValueType() == IntType, "%v", create.Columns[0])
+
+	for _, sql := range []string{
+		`CREATE TABLE t (a BIGINT, a TEXT)`,
+		`CREATE TABLE t (a BIGINT, PRIMARY KEY (b))`,
+		`CREATE TABLE t (a BIGINT DEFAULT "x")`,
+		`CREATE TABLE t (a VARCHAR(0))`,
+		`CREATE TABLE t (a)`,
+		`DROP users`,
+	} {
+		_, err = ParseSql(sql)
+		assert.Tf(t, err != nil, "must err %v", sql)
+	}
+
+	catalog := NewCatalog()
+	assert.Tf(t, catalog.Execute(create) == nil, "create")
+	assert.Tf(t, catalog.Execute(create) != nil, "already exists")
+	tbl, ok := catalog.Table("USERS")
+	assert.Tf(t, ok && tbl.Name == "users" && len(catalog.Tables()) == 1, "%v", tbl)
+
+	validate := func(sql string) error {
+		stmt, err := ParseSql(sql)
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		return catalog.Validate(stmt)
+	}
+	for _, sql := range []string{
+		`INSERT INTO users (user_id, name) VALUES (1, "bob")`,
+		`INSERT INTO users (user_id) VALUES (toint("5"))`,
+		`SELECT name, count(*) AS ct FROM users WHERE ct > 1 GROUP BY name HAVING ct > 2 ORDER BY ct`,
+		`UPDATE users SET ct = ct + 1 WHERE user_id = 5`,
+		`DELETE FROM users WHERE user_id = 5`,
+		`SELECT u.name, f.name FROM users u JOIN users f ON u.ct = f.user_id WHERE bio = "x"`,
+		`SELECT t.name FROM (SELECT name, count(*) AS ct FROM users GROUP BY name) AS t WHERE t.ct > 1`,
+		`SELECT name FROM users u WHERE EXISTS (SELECT ct FROM users f WHERE f.user_id = u.ct)`,
+	} {
+		err = validate(sql)
+		assert.Tf(t, err == nil, "must be valid %v: %v", sql, err)
+	}
+	for _, sql := range []string{
+		`INSERT INTO users (user_id, nme) VALUES (1, "bob")`,
+		`INSERT INTO users (name) VALUES ("bob")`,
+		`INSERT INTO users (user_id) VALUES ("bob")`,
+		`INSERT INTO profiles (user_id) VALUES (1)`,
+		`SELECT email FROM users`,
+		`SELECT name FROM users WHERE email = "x"`,
+		`UPDATE users SET email = "x"`,
+		`SELECT u.email FROM users u JOIN users f ON u.ct = f.user_id`,
+		`SELECT u.name FROM users u JOIN users f ON u.ct = x.user_id`,
+		`SELECT t.bio FROM (SELECT name FROM users) AS t`,
+		`SELECT name FROM users WHERE user_id IN (SELECT email FROM users)`,
+	} {
+		err = validate(sql)
+		assert.Tf(t, err != nil, "must be invalid %v", sql)
+	}
+
+	stmt, err = ParseSql(`DROP TABLE users`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	assert.Tf(t, stmt.Keyword() == ql.TokenDrop && stmt.(*SqlDrop).Table == "users", "%#v", stmt)
+	assert.Tf(t, catalog.Execute(stmt) == nil, "drop")
+	assert.Tf(t, catalog.Execute(stmt) != nil, "no table to drop")
+	_, ok = catalog.Table("users")
+	assert.Tf(t, !ok, "dropped")
+}
+
+func TestSqlAlterTable(t *testing.T) {
+
+	stmt, err := ParseSql(`ALTER TABLE users ADD email VARCHAR(5) DEFAULT "none" FIRST,
+		CHANGE COLUMN ct visits BIGINT NOT NULL AFTER name CHARACTER SET utf8, DROP bio`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	alter := stmt.(*SqlAlter)
+	assert.Tf(t, alter.Keyword() == ql.TokenAlter && alter.Table == "users" && len(alter.Changes) == 3, "%#v", alter)
+	assert.Tf(t, alter.Changes[0].Op == ql.TokenAdd && alter.Changes[0].First, "%#v", alter.Changes[0])
+	change := alter.Changes[1]
+	assert.Tf(t, change.Name == "ct" && change.Column.Name == "visits" && change.After == "name", "%#v", change)
+	assert.Tf(t, change.Column.String() == "visits BIGINT NOT NULL CHARACTER SET utf8", "%v", change.Column)
+	assert.Tf(t, alter.Changes[2].Op == ql.TokenDrop && alter.Changes[2].Name == "bio", "%#v", alter.Changes[2])
+
+	_, err = ParseSql(`ALTER TABLE users RENAME bio`)
+	assert.Tf(t, err != nil, "must err on unknown alteration")
+
+	catalog := NewCatalog()
+	create, _ := ParseSql(`CREATE TABLE users (user_id BIGINT, ct TEXT, name TEXT, bio TEXT, PRIMARY KEY (user_id))`)
+	assert.Tf(t, catalog.Execute(create) == nil, "create")
+
+	users := NewContextSimple()
+	users.Rows = []map[string]Value{
+		{"user_id": NewIntValue(1), "ct": NewStringValue("10"), "name": NewStringValue("bob"), "bio": NewStringValue("x")},
+		{"user_id": NewIntValue(2), "ct": NewStringValue("3"), "name": NewStringValue("alice")},
+	}
+	err = catalog.Alter(alter, users)
+	assert.Tf(t, err == nil, "non nil err: %v", err)
+	tbl, _ := catalog.Table("users")
+	names := make([]string, 0)
+	for _, col := range tbl.Columns {
+		names = append(names, col.Name)
+	}
+	assert.Tf(t, strings.Join(names, ",") == "email,user_id,name,visits", "columns in order %v", names)
+	assert.Tf(t, len(users.Rows[0]) == 4, "migrated %v", users.Rows[0])
+	assert.Tf(t, users.Rows[0]["email"].ToString() == "none", "default %v", users.Rows[0])
+	assert.Tf(t, users.Rows[0]["visits"].Value() == int64(10), "converted %v", users.Rows[0])
+	_, hasBio := users.Rows[0]["bio"]
+	assert.Tf(t, !hasBio, "dropped %v", users.Rows[0])
+
+	// nothing changes when a row cannot be migrated, users is rewound
+	users.Rows[1]["name"] = NewStringValue("not a number")
+	stmt, _ = ParseSql(`ALTER TABLE users CHANGE name name BIGINT`)
+	err = catalog.Alter(stmt.(*SqlAlter), users)
+	assert.Tf(t, err != nil, "must err on conversion")
+	assert.Tf(t, users.Rows[0]["name"].ToString() == "bob", "row unchanged %v", users.Rows[0])
+	tbl, _ = catalog.Table("users")
+	assert.Tf(t, tbl.Columns[2].Type == ql.TokenText, "schema unchanged %v", tbl.Columns[2])
+
+	for _, sql := range []string{
+		`ALTER TABLE users ADD name TEXT`,
+		`ALTER TABLE users DROP nope`,
+		`ALTER TABLE users DROP user_id`,
+		`ALTER TABLE users ADD x TEXT AFTER nope`,
+		`ALTER TABLE profiles ADD x TEXT`,
+	} {
+		stmt, err = ParseSql(sql)
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		assert.Tf(t, catalog.Validate(stmt) != nil, "must be invalid %v", sql)
+		assert.Tf(t, catalog.Execute(stmt) != nil, "must not execute %v", sql)
+	}
+}
+
+func TestSqlShow(t *testing.T) {
+
+	stmt, err := ParseSql(`SHOW COLUMNS FROM users`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	show := stmt.(*SqlShow)
+	assert.Tf(t, show.Identity == "COLUMNS" && show.From == "users", "%#v", show)
+
+	catalog := NewCatalog()
+	create, _ := ParseSql(`CREATE TABLE users (user_id BIGINT, name VARCHAR(20) NOT NULL DEFAULT "anon", PRIMARY KEY (user_id))`)
+	assert.Tf(t, catalog.Execute(create) == nil, "create")
+	create, _ = ParseSql(`CREATE TABLE events (ts BIGINT)`)
+	assert.Tf(t, catalog.Execute(create) == nil, "create")
+	catalog.SetVariable("version", NewStringValue("1.0"))
+
+	exec := func(sql string) []map[string]Value {
+		sqlVm, err := NewSqlVm(sql)
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		sqlVm.Catalog = catalog
+		wc := NewContextSimple()
+		err = sqlVm.Execute(wc, nil)
+		assert.Tf(t, err == nil, "non nil err %v: %v", sql, err)
+		return wc.Rows
+	}
+
+	rows := exec(`SHOW TABLES`)
+	assert.Tf(t, len(rows) == 2 && rows[0]["Table"].ToString() == "events", "sorted tables %v", rows)
+
+	for _, sql := range []string{`SHOW COLUMNS FROM users`, `DESCRIBE users`} {
+		rows = exec(sql)
+		assert.Tf(t, len(rows) == 2, "2 columns %v", rows)
+		assert.Tf(t, rows[0]["Field"].ToString() == "user_id" && rows[0]["Key"].ToString() == "PRI", "%v", rows[0])
+		assert.Tf(t, rows[0]["Null"].ToString() == "NO" && rows[0]["Default"].Nil(), "%v", rows[0])
+		assert.Tf(t, rows[1]["Type"].ToString() == "VARCHAR(20)" && rows[1]["Default"].ToString() == `"anon"`, "%v", rows[1])
+	}
+
+	rows = exec(`SHOW FUNCTIONS`)
+	found := make(map[string]string)
+	for _, row := range rows {
+		found[row["Function"].ToString()] = row["Type"].ToString()
+	}
+	assert.Tf(t, found["toint"] == "function" && found["sum"] == "aggregate", "funcs %v", found)
+
+	rows = exec(`SHOW VARIABLES`)
+	assert.Tf(t, len(rows) == 1 && rows[0]["Value"].ToString() == "1.0", "%v", rows)
+
+	sqlVm, _ := NewSqlVm(`DESCRIBE profiles`)
+	sqlVm.Catalog = catalog
+	assert.Tf(t, sqlVm.Execute(NewContextSimple(), nil) != nil, "no table profiles")
+	sqlVm, _ = NewSqlVm(`SHOW indexes`)
+	assert.Tf(t, sqlVm.Execute(NewContextSimple(), nil) != nil, "cannot show indexes")
+	for _, sql := range []string{`SHOW TABLES`, `SHOW VARIABLES`} {
+		sqlVm, _ = NewSqlVm(sql)
+		assert.Tf(t, sqlVm.Execute(NewContextSimple(), nil) != nil, "no catalog %v", sql)
+	}
+}
+
+func TestSqlBindParams(t *testing.T) {
+
+	sqlVm, err := NewSqlVm(`select user_id, int5 * ? AS x FROM stdio WHERE int5 > ?`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+
+	// un-bound params are an error
+	err = sqlVm.Execute(NewContextSimple(), rows[0])
+	assert.Tf(t, err != nil, "must err on unbound params")
+	err = sqlVm.Bind(NewIntValue(2))
+	assert.Tf(t, err != nil, "must err on wrong param count")
+
+	// parse once, execute with different params
+	assert.Tf(t, sqlVm.Bind(NewIntValue(2), NewIntValue(4)) == nil, "bind")
+	wc := NewContextSimple()
+	err = sqlVm.Execute(wc, rows[0])
+	assert.Tf(t, err == nil, "non nil err: %v", err)
+	assert.Tf(t, len(wc.Data) == 2, "must have 2 cols: %v", wc.Data)
+	assert.Tf(t, wc.Data["x"].Value() == int64(10), "must have bound val: %v", wc.Data)
+
+	assert.Tf(t, sqlVm.Bind(NewIntValue(2), NewIntValue(5)) == nil, "bind")
+	wc = NewContextSimple()
+	err = sqlVm.Execute(wc, rows[0])
+	assert.Tf(t, err == nil, "non nil err: %v", err)
+	assert.Tf(t, len(wc.Data) == 0, "must be filtered out: %v", wc.Data)
+
+	// values are never parsed as sql
+	sqlVm, err = NewSqlVm(`insert into mytable (a, b) VALUES (:a, "b1"), ("a2", :a)`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	err = sqlVm.BindNamed(map[string]Value{"b": NewStringValue("x")})
+	assert.Tf(t, err != nil, "must err on missing named param")
+	err = sqlVm.BindNamed(map[string]Value{"a": NewStringValue(`"); drop table x; --`)})
+	assert.Tf(t, err == nil, "bind %v", err)
+	wc = NewContextSimple()
+	err = sqlVm.ExecuteInsert(wc)
+	assert.Tf(t, err == nil, "non nil err: %v", err)
+	assert.Tf(t, len(wc.Rows) == 2, "must have 2 rows: %v", wc.Rows)
+	assert.Tf(t, wc.Rows[1]["b"].ToString() == `"); drop table x; --`, "must have bound val: %v", wc.Rows)
+	assert.Tf(t, sqlVm.ins.Rows[0][0].Nil(), "statement rows are not modified: %v", sqlVm.ins.Rows)
+
+	_, err = ParseSql(`select a FROM t WHERE a > ? AND b > $1`)
+	assert.Tf(t, err != nil, "must err on mixed ? and $1")
+}
+
+func TestSqlOrderBy(t *testing.T) {
+
+	stmt, err := ParseSql(`select user_id, ct FROM stdio WHERE ct > 0 ORDER BY ct * 2 DESC NULLS FIRST, user_id LIMIT 3`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	sel := stmt.(*SqlSelect)
+	assert.Tf(t, len(sel.OrderBy) == 2 && sel.Limit == 3, "has order by, limit: %v", sel)
+	assert.Tf(t, sel.OrderBy[0].Desc && sel.OrderBy[0].NullsFirst, "desc nulls first: %v", sel.OrderBy[0])
+	assert.Tf(t, !sel.OrderBy[1].Desc && sel.OrderBy[1].NullsFirst, "asc default nulls first: %v", sel.OrderBy[1])
+	assert.Tf(t, sel.OrderBy[0].String() == "ct * 2 DESC NULLS FIRST", "%v", sel.OrderBy[0])
+
+	input := make([]ContextReader, 0)
+	for i, ct := range []int64{5, 9, 1, 7, 9, 3, 0} {
+		input = append(input, NewContextSimpleData(map[string]Value{
+			"user_id": NewIntValue(int64(i)),
+			"ct":      NewIntValue(ct),
+		}))
+	}
+	// a row with no ct sorts as null
+	input = append(input, NewContextSimpleData(map[string]Value{"user_id": NewIntValue(20)}))
+
+	userIds := func(sql string) []int64 {
+		sqlVm, err := NewSqlVm(sql)
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		results, err := sqlVm.NewResults()
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		for _, row := range input {
+			assert.Tf(t, results.Add(row) == nil, "add row")
+		}
+		ids := make([]int64, 0)
+		for _, row := range results.Rows() {
+			ids = append(ids, row["user_id"].Value().(int64))
+		}
+		return ids
+	}
+
+	ids := userIds(`select user_id, ct FROM stdio ORDER BY ct DESC, user_id`)
+	assert.Equalf(t, ids, []int64{1, 4, 3, 0, 5, 2, 6, 20}, "sorted by ct desc: %v", ids)
+
+	// alias of a column, nulls last
+	ids = userIds(`select user_id, ct * 2 AS x FROM stdio ORDER BY x NULLS LAST`)
+	assert.Equalf(t, ids, []int64{6, 2, 5, 0, 3, 1, 4, 20}, "sorted by x: %v", ids)
+
+	// top-k keeps the same rows as a full sort
+	ids = userIds(`select user_id FROM stdio WHERE ct > 0 ORDER BY ct DESC, user_id DESC LIMIT 3`)
+	assert.Equalf(t, ids, []int64{4, 1, 3}, "top 3: %v", ids)
+
+	// DESC as the last word of the query, the null ct of 20 is not > 4
+	ids = userIds(`select user_id FROM stdio WHERE ct > 4 ORDER BY user_id DESC`)
+	assert.Equalf(t, ids, []int64{4, 3, 1, 0}, "sorted by user_id desc: %v", ids)
+
+	ids = userIds(`select user_id FROM stdio LIMIT 2`)
+	assert.Equalf(t, ids, []int64{0, 1}, "first 2: %v", ids)
+}
+
+func TestSqlGroupBy(t *testing.T) {
+
+	stmt, err := ParseSql(`select country, count(*) AS ct FROM stdio WHERE x > 0 GROUP BY country HAVING ct > 1`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	sel := stmt.(*SqlSelect)
+	assert.Tf(t, len(sel.GroupBy) == 1 && sel.Having != nil, "has group by, having: %v", sel)
+	assert.Tf(t, sel.Columns[1].Tree.Root.StringAST() == "count(*)", "%v", sel.Columns[1].Tree.Root.StringAST())
+
+	_, err = ParseSql(`select country FROM stdio WHERE count(*) > 1`)
+	assert.Tf(t, err != nil, "aggregate in where should err")
+
+	input := make([]ContextReader, 0)
+	for _, r := range []struct {
+		country string
+		x       int64
+	}{{"us", 5}, {"de", 2}, {"us", 1}, {"fr", 7}, {"us", 3}, {"de", 4}} {
+		input = append(input, NewContextSimpleData(map[string]Value{
+			"country": NewStringValue(r.country),
+			"x":       NewIntValue(r.x),
+		}))
+	}
+	// a row with no x is not counted by count(x)
+	input = append(input, NewContextSimpleData(map[string]Value{"country": NewStringValue("fr")}))
+
+	rows := func(sql string, in []ContextReader) []map[string]Value {
+		sqlVm, err := NewSqlVm(sql)
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		results, err := sqlVm.NewResults()
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		for _, row := range in {
+			assert.Tf(t, results.Add(row) == nil, "add row")
+		}
+		return results.Rows()
+	}
+
+	out := rows(`select country, count(*) AS ct, count(x) AS xct FROM stdio GROUP BY country`, input)
+	assert.Tf(t, len(out) == 3, "3 groups: %v", out)
+	assert.Tf(t, out[0]["country"].ToString() == "us" && out[0]["ct"].Value().(int64) == 3, "%v", out[0])
+	assert.Tf(t, out[2]["country"].ToString() == "fr" && out[2]["ct"].Value().(int64) == 2, "%v", out[2])
+	assert.Tf(t, out[2]["xct"].Value().(int64) == 1, "count(x) skips nulls %v", out[2])
+
+	out = rows(`select country, sum(x) AS s, avg(x) AS a, min(x) AS lo, max(x) AS hi
+		FROM stdio GROUP BY country HAVING sum(x) > 6 ORDER BY s DESC`, input)
+	assert.Tf(t, len(out) == 2, "2 groups with sum > 6: %v", out)
+	assert.Tf(t, out[0]["country"].ToString() == "us" && out[0]["s"].Value().(int64) == 9, "%v", out[0])
+	assert.Tf(t, out[0]["a"].Value().(float64) == 3, "avg %v", out[0])
+	assert.Tf(t, out[0]["lo"].Value().(int64) == 1 && out[0]["hi"].Value().(int64) == 5, "min, max %v", out[0])
+	assert.Tf(t, out[1]["country"].ToString() == "fr" && out[1]["s"].Value().(int64) == 7, "%v", out[1])
+
+	out = rows(`select count(*) AS ct, sum(x) AS s FROM stdio WHERE x > 2`, input[:6])
+	assert.Tf(t, len(out) == 1 && out[0]["ct"].Value().(int64) == 4 && out[0]["s"].Value().(int64) == 19, "%v", out)
+
+	// aggregates over no rows, still one row
+	out = rows(`select count(*) AS ct, sum(x) AS s FROM stdio`, nil)
+	assert.Tf(t, len(out) == 1 && out[0]["ct"].Value().(int64) == 0, "%v", out)
+	assert.Tf(t, out[0]["s"].Type() == NilType, "sum of no rows is null %v", out)
+
+	// aggregates are only evaluated over the groups of results
+	sqlVm, err := NewSqlVm(`select count(*) AS ct FROM stdio`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	err = sqlVm.ExecuteSelect(NewContextSimple(), input[0])
+	assert.Tf(t, err == ErrAggregate, "aggregate outside of group should err %v", err)
+
+	// a scalar func of the same name takes precedence over the aggregate
+	FuncAdd("min", func(e *State, a, b Value) (IntValue, bool) {
+		if a.Value().(int64) < b.Value().(int64) {
+			return a.(IntValue), true
+		}
+		return b.(IntValue), true
+	})
+	defer delete(funcs, "min")
+	out = rows(`select min(x, 3) AS m FROM stdio`, input[:2])
+	assert.Tf(t, len(out) == 2 && out[0]["m"].Value().(int64) == 3 && out[1]["m"].Value().(int64) == 2, "%v", out)
+}
+
+// an aggregate of the number of distinct values
+type uniqueAgg struct {
+	seen map[string]bool
+}
+
+func (m *uniqueAgg) Init()              { m.seen = make(map[string]bool) }
+func (m *uniqueAgg) Accumulate(v Value) { m.seen[v.ToString()] = true }
+func (m *uniqueAgg) Result() Value      { return NewIntValue(int64(len(m.seen))) }
+func (m *uniqueAgg) Merge(other Aggregator) {
+	for k := range other.(*uniqueAgg).seen {
+		m.seen[k] = true
+	}
+}
+
+func TestSqlAggAdd(t *testing.T) {
+
+	err := AggAdd("unique_users", func() Aggregator { return &uniqueAgg{} })
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	err = AggAdd("toint", func() Aggregator { return &uniqueAgg{} })
+	assert.Tf(t, err != nil, "should not shadow the toint func")
+	assert.Tf(t, !isAggregate("toint"), "toint is not an aggregate")
+	err = AggAdd("COUNT", func() Aggregator { return &uniqueAgg{} })
+	assert.Tf(t, err != nil, "should not replace the count aggregate")
+	err = AggAdd("unique_users", func() Aggregator { return &uniqueAgg{} })
+	assert.Tf(t, err != nil, "should not replace an added aggregate")
+	err = AggAdd("nothing", nil)
+	assert.Tf(t, err != nil && !isAggregate("nothing"), "should not add a nil factory")
+	aggs := AggsGet()
+	delete(aggs, "count")
+	assert.Tf(t, isAggregate("count"), "AggsGet must return a copy")
+
+	sql := `select country, unique_users(user_id) AS users, count(*) AS ct FROM stdio GROUP BY country ORDER BY country`
+	stmt, err := ParseSql(sql)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	fn, ok := stmt.(*SqlSelect).Columns[1].Tree.Root.(*FuncNode)
+	assert.Tf(t, ok && fn.Agg, "parser marks aggregate %#v", stmt.(*SqlSelect).Columns[1].Tree.Root)
+
+	row := func(country string, userId int64) ContextReader {
+		return NewContextSimpleData(map[string]Value{
+			"country": NewStringValue(country),
+			"user_id": NewIntValue(userId),
+		})
+	}
+	// two partitions of rows, each added to their own results then merged
+	parts := [][]ContextReader{
+		{row("us", 1), row("us", 2), row("de", 1), row("us", 1)},
+		{row("us", 3), row("us", 2), row("fr", 9)},
+	}
+	sqlVm, err := NewSqlVm(sql)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	results := make([]*SqlResults, len(parts))
+	for i, part := range parts {
+		results[i], err = sqlVm.NewResults()
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		for _, r := range part {
+			assert.Tf(t, results[i].Add(r) == nil, "add row")
+		}
+	}
+	assert.Tf(t, results[0].Merge(results[1]) == nil, "merge")
+
+	out := results[0].Rows()
+	assert.Tf(t, len(out) == 3, "3 groups: %v", out)
+	assert.Tf(t, out[2]["country"].ToString() == "us", "%v", out[2])
+	assert.Tf(t, out[2]["users"].Value().(int64) == 3, "unique users %v", out[2])
+	assert.Tf(t, out[2]["ct"].Value().(int64) == 5, "merged count %v", out[2])
+
+	other, _ := NewSqlVm(`select count(*) FROM stdio`)
+	otherResults, _ := other.NewResults()
+	assert.Tf(t, results[0].Merge(otherResults) != nil, "different selects should not merge")
+}
+
+func TestSqlJoin(t *testing.T) {
+
+	stmt, err := ParseSql(`SELECT u.name, o.total FROM users AS u LEFT JOIN orders o ON u.id = o.user_id CROSS JOIN days`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	sel := stmt.(*SqlSelect)
+	assert.Tf(t, sel.From == "users" && sel.Alias == "u" && len(sel.Joins) == 2, "%#v", sel)
+	assert.Tf(t, sel.Joins[0].Kind == ql.TokenLeft && sel.Joins[0].Name() == "o" && sel.Joins[0].On != nil, "%#v", sel.Joins[0])
+	assert.Tf(t, sel.Joins[1].Kind == ql.TokenCross && sel.Joins[1].Name() == "days" && sel.Joins[1].On == nil, "%#v", sel.Joins[1])
+
+	_, err = ParseSql(`SELECT a FROM users JOIN orders`)
+	assert.Tf(t, err != nil, "must err on join without ON")
+	_, err = ParseSql(`SELECT a FROM users CROSS JOIN orders ON a = b`)
+	assert.Tf(t, err != nil, "must err on cross join with ON")
+	_, err = ParseSql(`SELECT a FROM users JOIN users ON a = b`)
+	assert.Tf(t, err != nil, "must err on self join without alias")
+
+	newSources := func() map[string]RowScanner {
+		users := NewContextSimple()
+		for i, name := range []string{"bob", "ann", "joe"} {
+			users.Insert(map[string]Value{"id": NewIntValue(int64(i + 1)), "name": NewStringValue(name)})
+		}
+		orders := NewContextSimple()
+		for _, o := range [][2]int64{{1, 10}, {2, 5}, {1, 20}, {9, 1}} {
+			orders.Insert(map[string]Value{"user_id": NewIntValue(o[0]), "total": NewIntValue(o[1])})
+		}
+		days := NewContextSimple()
+		days.Insert(map[string]Value{"day": NewStringValue("mon")})
+		days.Insert(map[string]Value{"day": NewStringValue("tue")})
+		return map[string]RowScanner{"users": users, "orders": orders, "days": days}
+	}
+	rows := func(sql string) []map[string]Value {
+		sqlVm, err := NewSqlVm(sql)
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		results, err := sqlVm.NewResults()
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		err = sqlVm.ExecuteJoin(results, newSources())
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		return results.Rows()
+	}
+
+	// equality is a hash join
+	out := rows(`SELECT u.name, o.total FROM users u JOIN orders o ON u.id = o.user_id ORDER BY o.total`)
+	assert.Tf(t, len(out) == 3, "must have 3 rows: %v", out)
+	assert.Tf(t, out[0]["u.name"].Value() == "ann" && out[0]["o.total"].Value() == int64(5), "%v", out[0])
+	assert.Tf(t, out[2]["u.name"].Value() == "bob" && out[2]["o.total"].Value() == int64(20), "%v", out[2])
+
+	// unqualified columns, and the sides of the equality swapped
+	out = rows(`SELECT name, total FROM users u INNER JOIN orders o ON o.user_id = u.id WHERE total > 5`)
+	assert.Tf(t, len(out) == 2, "must have 2 rows: %v", out)
+
+	// left outer join keeps users without orders, with null order columns
+	out = rows(`SELECT u.name, o.total FROM users u LEFT OUTER JOIN orders o ON u.id = o.user_id ORDER BY o.total`)
+	assert.Tf(t, len(out) == 4, "must have 4 rows: %v", out)
+	assert.Tf(t, out[0]["u.name"].Value() == "joe" && out[0]["o.total"].Type() == NilType, "%v", out[0])
+
+	// non equality is a nested loop
+	out = rows(`SELECT u.name, o.total FROM users u JOIN orders o ON u.id < o.user_id`)
+	assert.Tf(t, len(out) == 4, "must have 4 rows: %v", out)
+	out = rows(`SELECT u.name, o.total FROM users u JOIN orders o ON u.id < o.user_id AND o.total > 1`)
+	assert.Tf(t, len(out) == 1 && out[0]["o.total"].Value() == int64(5), "must have 1 row: %v", out)
+
+	out = rows(`SELECT count(*) AS ct FROM users CROSS JOIN days`)
+	assert.Tf(t, len(out) == 1 && out[0]["ct"].Value() == int64(6), "must have 6 rows: %v", out)
+
+	// grouped by a qualified column of a joined table
+	out = rows(`SELECT u.name, sum(o.total) AS total FROM users u JOIN orders o ON u.id = o.user_id GROUP BY u.name ORDER BY total DESC`)
+	assert.Tf(t, len(out) == 2 && out[0]["u.name"].Value() == "bob" && out[0]["total"].Value() == int64(30), "%v", out)
+
+	sqlVm, _ := NewSqlVm(`SELECT a FROM users JOIN missing m ON users.id = m.id`)
+	results, _ := sqlVm.NewResults()
+	assert.Tf(t, sqlVm.ExecuteJoin(results, newSources()) != nil, "must err on table without a source")
+
+	// joins are not silently ignored by Execute
+	sqlVm, _ = NewSqlVm(`SELECT u.name FROM users u JOIN orders o ON u.id = o.user_id`)
+	err = sqlVm.Execute(NewContextSimple(), newSources()["users"].(*ContextSimple))
+	assert.Tf(t, err != nil, "must err on a join outside of ExecuteJoin")
+	sqlVm, _ = NewSqlVm(`INSERT INTO names (name) SELECT u.name FROM users u JOIN orders o ON u.id = o.user_id`)
+	err = sqlVm.Execute(NewContextSimple(), newSources()["users"].(*ContextSimple))
+	assert.Tf(t, err != nil, "must err on insert of a join")
+
+	// the hash join and nested loop join both compare in the dialect collation
+	nocase := &ql.Dialect{Statements: ql.SqlDialect.Statements, Collation: ql.CollateNoCase}
+	for _, sql := range []string{
+		`SELECT u.id FROM users u JOIN admins a ON u.name = a.name`,
+		`SELECT u.id FROM users u JOIN admins a ON u.name = a.name OR false`,
+	} {
+		sqlVm, _ = NewSqlVmDialect(sql, nocase)
+		results, _ = sqlVm.NewResults()
+		sources := newSources()
+		admins := NewContextSimple()
+		admins.Insert(map[string]Value{"name": NewStringValue("BOB")})
+		admins.Insert(map[string]Value{"name": NewStringValue("Joe")})
+		sources["admins"] = admins
+		err = sqlVm.ExecuteJoin(results, sources)
+		assert.Tf(t, err == nil && len(results.Rows()) == 2, "%s: must join ignoring case %v %v", sql, err, results.Rows())
+	}
+}
+
+func TestSqlSubQuery(t *testing.T) {
+
+	stmt, err := ParseSql(`SELECT t.name FROM (SELECT name FROM users) AS t WHERE t.name IN (SELECT name FROM orders)`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	sel := stmt.(*SqlSelect)
+	assert.Tf(t, sel.SubQuery != nil && sel.From == "t" && sel.Alias == "t", "%#v", sel)
+	_, isSub := sel.Where.Root.(*BinaryNode).Args[1].(*SubQueryNode)
+	assert.Tf(t, isSub, "must be an IN subquery %v", sel.Where.Root)
+
+	_, err = ParseSql(`SELECT name FROM (SELECT name FROM users)`)
+	assert.Tf(t, err != nil, "must err on derived table without alias")
+	_, err = ParseSql(`SELECT name FROM users WHERE id IN (SELECT id, name FROM orders)`)
+	assert.Tf(t, err != nil, "must err on subquery of more than one column")
+	_, err = ParseSql(`SELECT name FROM users WHERE EXISTS (SELECT * FROM orders`)
+	assert.Tf(t, err != nil, "must err on subquery without closing paren")
+
+	newSources := func() map[string]RowScanner {
+		users := NewContextSimple()
+		for i, name := range []string{"bob", "ann", "joe"} {
+			users.Insert(map[string]Value{"id": NewIntValue(int64(i + 1)), "name": NewStringValue(name)})
+		}
+		orders := NewContextSimple()
+		for _, o := range [][2]int64{{1, 10}, {2, 5}, {1, 20}, {9, 1}} {
+			orders.Insert(map[string]Value{"user_id": NewIntValue(o[0]), "total": NewIntValue(o[1])})
+		}
+		return map[string]RowScanner{"users": users, "orders": orders}
+	}
+	var catalog *Catalog
+	rows := func(sql string) []map[string]Value {
+		sqlVm, err := NewSqlVm(sql)
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		sqlVm.Catalog = catalog
+		results, err := sqlVm.NewResults()
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		err = sqlVm.ExecuteJoin(results, newSources())
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		return results.Rows()
+	}
+
+	out := rows(`SELECT name FROM users WHERE id IN (SELECT user_id FROM orders WHERE total > 5) ORDER BY name`)
+	assert.Tf(t, len(out) == 1 && out[0]["name"].Value() == "bob", "must have 1 row: %v", out)
+	out = rows(`SELECT name FROM users WHERE id NOT IN (SELECT user_id FROM orders WHERE total > 5) ORDER BY name`)
+	assert.Tf(t, len(out) == 2 && out[0]["name"].Value() == "ann" && out[1]["name"].Value() == "joe", "%v", out)
+
+	// scalar subquery, reading the same table as the outer query
+	out = rows(`SELECT name, (SELECT max(id) FROM users) AS top FROM users WHERE id = (SELECT min(user_id) FROM orders)`)
+	assert.Tf(t, len(out) == 1 && out[0]["name"].Value() == "bob" && out[0]["top"].Value() == int64(3), "%v", out)
+
+	// correlated, evaluated for each row of the outer query
+	out = rows(`SELECT u.name FROM users u WHERE EXISTS (SELECT user_id FROM orders o WHERE o.user_id = u.id) ORDER BY u.name`)
+	assert.Tf(t, len(out) == 2 && out[0]["u.name"].Value() == "ann" && out[1]["u.name"].Value() == "bob", "%v", out)
+	out = rows(`SELECT u.name FROM users u WHERE u.id NOT IN (SELECT user_id FROM orders o WHERE o.user_id = u.id)`)
+	assert.Tf(t, len(out) == 1 && out[0]["u.name"].Value() == "joe", "%v", out)
+	out = rows(`SELECT u.name, (SELECT sum(total) FROM orders o WHERE o.user_id = u.id) AS total FROM users u ORDER BY u.name`)
+	assert.Tf(t, len(out) == 3 && out[1]["total"].Value() == int64(30) && out[2]["total"].Type() == NilType, "%v", out)
+	// without a Catalog an unqualified column is one of the subquery's tables,
+	// even if missing from its rows, so name is the null name of orders
+	out = rows(`SELECT name FROM users WHERE EXISTS (SELECT total FROM orders WHERE name = "bob")`)
+	assert.Tf(t, len(out) == 0, "%v", out)
+
+	// derived table
+	out = rows(`SELECT t.user_id, t.ct FROM (SELECT user_id, count(*) AS ct FROM orders GROUP BY user_id) AS t WHERE t.ct > 1`)
+	assert.Tf(t, len(out) == 1 && out[0]["t.user_id"].Value() == int64(1) && out[0]["t.ct"].Value() == int64(2), "%v", out)
+	out = rows(`SELECT u.name, t.ct FROM (SELECT user_id, count(*) AS ct FROM orders GROUP BY user_id) t
+		JOIN users u ON u.id = t.user_id ORDER BY u.name`)
+	assert.Tf(t, len(out) == 2 && out[0]["u.name"].Value() == "ann" && out[0]["t.ct"].Value() == int64(1), "%v", out)
+
+	sqlVm, _ := NewSqlVm(`SELECT name, (SELECT total FROM orders) AS total FROM users`)
+	results, _ := sqlVm.NewResults()
+	err = sqlVm.ExecuteJoin(results, newSources())
+	assert.Tf(t, err == SqlSubQueryRowsError, "must err on scalar subquery of more than 1 row: %v", err)
+
+	// in a dialect which ignores case, as are correlated subqueries
+	nocase := &ql.Dialect{Statements: ql.SqlDialect.Statements, Collation: ql.CollateNoCase}
+	for _, sql := range []string{
+		`SELECT id FROM users WHERE "BOB" IN (SELECT name FROM users)`,
+		`SELECT u.id FROM users u WHERE "BOB" IN (SELECT name FROM users WHERE id = u.id)`,
+	} {
+		sqlVm, _ = NewSqlVmDialect(sql, nocase)
+		results, _ = sqlVm.NewResults()
+		err = sqlVm.ExecuteJoin(results, newSources())
+		assert.Tf(t, err == nil && len(results.Rows()) > 0, "%s: must match ignoring case %v %v", sql, err, results.Rows())
+	}
+
+	// an uncorrelated subquery is evaluated once per execution, from Sources
+	sqlVm, _ = NewSqlVm(`SELECT name FROM users WHERE id IN (SELECT user_id FROM orders)`)
+	sqlVm.Sources = newSources()
+	results, _ = sqlVm.NewResults()
+	for _, row := range newSources()["users"].(*ContextSimple).Rows {
+		err = results.Add(NewContextSimpleData(row))
+		assert.Tf(t, err == nil, "Should not err %v", err)
+	}
+	assert.Tf(t, len(results.Rows()) == 2, "must have 2 rows: %v", results.Rows())
+
+	// with a Catalog, an unqualified column which is not declared by orders
+	// is one of users, and one not declared by either is an error
+	catalog = NewCatalog()
+	for _, sql := range []string{
+		`CREATE TABLE users (id BIGINT, name TEXT)`,
+		`CREATE TABLE orders (user_id BIGINT, total BIGINT, name TEXT)`,
+	} {
+		stmt, _ := ParseSql(sql)
+		assert.Tf(t, catalog.Execute(stmt) == nil, "create %s", sql)
+	}
+	out = rows(`SELECT name FROM users WHERE EXISTS (SELECT user_id FROM orders WHERE user_id = id AND total > 5)`)
+	assert.Tf(t, len(out) == 1 && out[0]["name"].Value() == "bob", "%v", out)
+	out = rows(`SELECT name FROM users WHERE EXISTS (SELECT total FROM orders WHERE name = "bob")`)
+	assert.Tf(t, len(out) == 0, "%v", out)
+	sqlVm, _ = NewSqlVm(`SELECT name FROM users WHERE EXISTS (SELECT total FROM orders WHERE user_id = idd)`)
+	sqlVm.Catalog = catalog
+	results, _ = sqlVm.NewResults()
+	err = sqlVm.ExecuteJoin(results, newSources())
+	assert.Tf(t, err != nil, "must err on a misspelled column")
+
+	// correlation is from the declared columns, not the rows of an empty table
+	for _, c := range []*Catalog{nil, catalog} {
+		subs := newSubQueries(map[string]RowScanner{"orders": NewContextSimple()}, c)
+		stmt, _ := ParseSql(`SELECT total FROM orders WHERE user_id = 1`)
+		assert.Tf(t, !subs.isCorrelated(stmt.(*SqlSelect)), "must not be correlated")
+		stmt, _ = ParseSql(`SELECT total FROM orders WHERE user_id = id`)
+		assert.Tf(t, subs.isCorrelated(stmt.(*SqlSelect)) == (c != nil), "id is only of users with a catalog")
+	}
+	catalog = nil
+
+	// derived tables are not silently ignored by Execute
+	sqlVm, _ = NewSqlVm(`SELECT t.user_id FROM (SELECT user_id FROM orders) AS t`)
+	err = sqlVm.Execute(NewContextSimple(), newSources()["orders"].(*ContextSimple))
+	assert.Tf(t, err != nil, "must err on a derived table outside of ExecuteJoin")
+}
+
+func TestSqlPredicates(t *testing.T) {
+
+	stmt, err := ParseSql(`SELECT name FROM users WHERE id NOT IN (1, 2) AND ct BETWEEN 1 AND 5 AND email IS NOT NULL`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	where := stmt.(*SqlSelect).Where
+	assert.Tf(t, where.String() == "id NOT IN (1, 2) AND ct BETWEEN 1 AND 5 AND email IS NOT NULL", "%v", where)
+
+	for _, sql := range []string{
+		`SELECT name FROM users WHERE id NOT = 5`,
+		`SELECT name FROM users WHERE id IS 5`,
+		`SELECT name FROM users WHERE ct BETWEEN 1 OR 5`,
+		`SELECT name FROM users WHERE name LIKE "a%" ESCAPE "ab"`,
+	} {
+		_, err = ParseSql(sql)
+		assert.Tf(t, err != nil, "must err %v", sql)
+	}
+
+	readrows := []ContextReader{
+		NewContextSimpleData(map[string]Value{"id": NewIntValue(1), "name": NewStringValue("bob"), "ct": NewIntValue(3)}),
+		NewContextSimpleData(map[string]Value{"id": NewIntValue(2), "name": NewStringValue("ann_b"), "ct": NewIntValue(9), "email": NewNilValue()}),
+		NewContextSimpleData(map[string]Value{"id": NewIntValue(3), "name": NewStringValue("joe"), "ct": NewIntValue(12), "email": NewStringValue("j@x.io")}),
+	}
+	dialect := ql.SqlDialect
+	names := func(sql string) []string {
+		sqlVm, err := NewSqlVmDialect(sql, dialect)
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		results, err := sqlVm.NewResults()
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		for _, row := range readrows {
+			assert.Tf(t, results.Add(row) == nil, "add row")
+		}
+		out := make([]string, 0)
+		for _, row := range results.Rows() {
+			out = append(out, row["name"].ToString())
+		}
+		return out
+	}
+	for sql, want := range map[string]string{
+		`SELECT name FROM users WHERE id IN (1, 3)`:                 "bob,joe",
+		`SELECT name FROM users WHERE id NOT IN (1, 3)`:             "ann_b",
+		`SELECT name FROM users WHERE ct BETWEEN 3 AND 9`:           "bob,ann_b",
+		`SELECT name FROM users WHERE ct NOT BETWEEN 4 AND 10`:      "bob,joe",
+		`SELECT name FROM users WHERE email IS NULL`:                "bob,ann_b",
+		`SELECT name FROM users WHERE email IS NOT NULL`:            "joe",
+		`SELECT name FROM users WHERE name LIKE "%b"`:               "bob,ann_b",
+		`SELECT name FROM users WHERE name LIKE "ann\_%"`:           "ann_b",
+		`SELECT name FROM users WHERE name NOT LIKE "_o%"`:          "ann_b",
+		`SELECT name FROM users WHERE NOT id = 2 AND email IS NULL`: "bob",
+		`SELECT name FROM users WHERE id IN (1, 2) AND NOT ct > 5`:  "bob",
+		`SELECT name FROM users WHERE name = "joe"`:                 "joe",
+		`SELECT name FROM users WHERE name > "b"`:                   "bob,joe",
+		`SELECT name FROM users WHERE name <> "bob" AND ct < 10`:    "ann_b",
+		`SELECT name FROM users WHERE name IN ("BOB", "joe")`:       "joe",
+	} {
+		got := strings.Join(names(sql), ",")
+		assert.Tf(t, got == want, "%s: want %s but got %s", sql, want, got)
+	}
+
+	// strings compared ignoring case, by every operator
+	dialect = &ql.Dialect{Statements: ql.SqlDialect.Statements, Collation: ql.CollateNoCase}
+	for sql, want := range map[string]string{
+		`SELECT name FROM users WHERE name = "JOE"`:                                  "joe",
+		`SELECT name FROM users WHERE name IN ("BOB", "joe")`:                        "bob,joe",
+		`SELECT name FROM users WHERE name BETWEEN "Ann" AND "BOB"`:                  "bob,ann_b",
+		`SELECT name FROM users WHERE CASE name WHEN "JOE" THEN true ELSE false END`: "joe",
+	} {
+		got := strings.Join(names(sql), ",")
+		assert.Tf(t, got == want, "%s: want %s but got %s", sql, want, got)
+	}
+	readrows = []ContextReader{
+		NewContextSimpleData(map[string]Value{"name": NewStringValue("Zed")}),
+		NewContextSimpleData(map[string]Value{"name": NewStringValue("ann")}),
+		NewContextSimpleData(map[string]Value{"name": NewStringValue("Bob")}),
+	}
+	got := strings.Join(names(`SELECT name FROM users ORDER BY name`), ",")
+	assert.Tf(t, got == "ann,Bob,Zed", "must order ignoring case: %s", got)
+	for sql, want := range map[string]string{
+		`SELECT max(name) AS name FROM users`:               "Zed",
+		`SELECT name FROM users ORDER BY name DESC LIMIT 1`: "Zed",
+		`SELECT min(name) AS name FROM users`:               "ann",
+		`SELECT name FROM users ORDER BY name LIMIT 1`:      "ann",
+	} {
+		got = strings.Join(names(sql), ",")
+		assert.Tf(t, got == want, "%s: want %s but got %s", sql, want, got)
+	}
+	dialect = ql.SqlDialect
+	got = strings.Join(names(`SELECT name FROM users ORDER BY name`), ",")
+	assert.Tf(t, got == "Bob,Zed,ann", "must order byte wise: %s", got)
+
+	// || concatenates in a dialect which does, otherwise it is a logical or
+	got = strings.Join(names(`SELECT name FROM users WHERE name = "ann" || name = "Bob"`), ",")
+	assert.Tf(t, got == "ann,Bob", "must or: %s", got)
+	dialect = &ql.Dialect{Statements: ql.SqlDialect.Statements, Concat: ql.ConcatPipes}
+	got = strings.Join(names(`SELECT name || "!" AS name FROM users WHERE name || "b" = "Bobb"`), ",")
+	assert.Tf(t, got == "Bob!", "must concatenate: %s", got)
+
+	// a string operator which isn't a comparison is an error, once
+	sqlVm, _ := NewSqlVm(`UPDATE users SET name = name - "b"`)
+	users := NewContextSimple()
+	users.Insert(map[string]Value{"name": NewStringValue("bob")})
+	users.Insert(map[string]Value{"name": NewStringValue("ann")})
+	err = sqlVm.Execute(users, users)
+	assert.Tf(t, err == ErrStringOp, "must err on - of strings: %v", err)
+
+	for pattern, matches := range map[string]bool{
+		"abc": true, "a%": true, "%c": true, "%b%": true, "a_c": true, "%": true,
+		"ab": false, "a_": false, "_": false, "abcd%": false, "a!%": false,
+	} {
+		assert.Tf(t, likeMatch("abc", pattern, '!') == matches, "%s like %s", "abc", pattern)
+	}
+	assert.T(t, likeMatch("a%c", "a!%c", '!') && !likeMatch("abc", "a!%c", '!'))
+}
+
+func TestSqlCase(t *testing.T) {
+
+	stmt, err := ParseSql(`SELECT CASE WHEN ct > 5 THEN "many" ELSE "few" END AS size FROM users`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	col := stmt.(*SqlSelect).Columns[0]
+	assert.Tf(t, col.As == "size", "%v", col.As)
+	assert.Tf(t, col.Tree.String() == `CASE WHEN ct > 5 THEN "many" ELSE "few" END`, "%v", col.Tree)
+
+	for _, sql := range []string{
+		`SELECT CASE ELSE 1 END FROM users`,
+		`SELECT CASE WHEN ct > 5 THEN 1 FROM users`,
+		`SELECT CASE WHEN ct > 5 1 END FROM users`,
+	} {
+		_, err = ParseSql(sql)
+		assert.Tf(t, err != nil, "must err %v", sql)
+	}
+
+	readrows := []ContextReader{
+		NewContextSimpleData(map[string]Value{"name": NewStringValue("bob"), "ct": NewIntValue(3), "kind": NewStringValue("a")}),
+		NewContextSimpleData(map[string]Value{"name": NewStringValue("ann"), "ct": NewIntValue(9), "kind": NewStringValue("b")}),
+		NewContextSimpleData(map[string]Value{"name": NewStringValue("joe"), "ct": NewIntValue(12)}),
+	}
+	rows := func(sql string) []map[string]Value {
+		sqlVm, err := NewSqlVm(sql)
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		results, err := sqlVm.NewResults()
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		for _, row := range readrows {
+			assert.Tf(t, results.Add(row) == nil, "add row")
+		}
+		return results.Rows()
+	}
+	column := func(sql, name string) string {
+		out := make([]string, 0)
+		for _, row := range rows(sql) {
+			if v, ok := row[name]; ok && !isNullValue(v) {
+				out = append(out, v.ToString())
+			} else {
+				out = append(out, "null")
+			}
+		}
+		return strings.Join(out, ",")
+	}
+	for sql, want := range map[string]string{
+		`SELECT CASE WHEN ct > 5 THEN "many" ELSE "few" END AS size FROM users`:                   "few,many,many",
+		`SELECT CASE WHEN ct > 10 THEN "lots" WHEN ct > 5 THEN "many" END AS size FROM users`:     "null,many,lots",
+		`SELECT CASE kind WHEN "a" THEN "ay" WHEN "b" THEN "bee" ELSE "?" END AS size FROM users`: "ay,bee,?",
+	} {
+		got := column(sql, "size")
+		assert.Tf(t, got == want, "%s: want %s but got %s", sql, want, got)
+	}
+	for sql, want := range map[string]string{
+		`SELECT name FROM users WHERE CASE WHEN ct < 5 THEN ct = 3 ELSE ct > 10 END`: "bob,joe",
+		`SELECT name FROM users ORDER BY CASE kind WHEN "b" THEN 0 ELSE 1 END, name`: "ann,bob,joe",
+	} {
+		got := column(sql, "name")
+		assert.Tf(t, got == want, "%s: want %s but got %s", sql, want, got)
+	}
+}
+
+func TestSqlColumnGuard(t *testing.T) {
+
+	stmt, err := ParseSql(`SELECT email AS e IF eq(kind, 5), score * 2 AS s IF score > 0, name FROM users WHERE id > 1`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	sel := stmt.(*SqlSelect)
+	assert.Tf(t, len(sel.Columns) == 3, "has 3 cols: %v", sel.Columns)
+	assert.Tf(t, sel.Columns[0].As == "e" && sel.Columns[0].Guard.String() == "eq(kind, 5)", "%v", sel.Columns[0].Guard)
+	assert.Tf(t, sel.Columns[1].As == "s" && sel.Columns[1].Guard.String() == "score > 0", "%v", sel.Columns[1].Guard)
+	assert.Tf(t, sel.Columns[2].Guard == nil, "no guard: %v", sel.Columns[2].Guard)
+	assert.Tf(t, sel.Where.String() == "id > 1", "%v", sel.Where)
+
+	readrows := []ContextReader{
+		NewContextSimpleData(map[string]Value{"name": NewStringValue("bob"), "score": NewIntValue(3), "email": NewStringValue("bob@x.io")}),
+		NewContextSimpleData(map[string]Value{"name": NewStringValue("ann"), "score": NewIntValue(-1)}),
+	}
+	sqlVm, err := NewSqlVm(`SELECT name, email AS e IF email IS NOT NULL, score * 2 AS s IF score > 0 FROM users`)
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	results, err := sqlVm.NewResults()
+	assert.Tf(t, err == nil, "Should not err %v", err)
+	for _, row := range readrows {
+		assert.Tf(t, results.Add(row) == nil, "add row")
+	}
+	rows := results.Rows()
+	assert.Tf(t, len(rows) == 2, "has 2 rows: %v", rows)
+	assert.Tf(t, len(rows[0]) == 3, "bob has all cols: %v", rows[0])
+	assert.Tf(t, rows[0]["e"].ToString() == "bob@x.io" && rows[0]["s"].ToString() == "6", "%v", rows[0])
+	_, hasE := rows[1]["e"]
+	_, hasS := rows[1]["s"]
+	assert.Tf(t, rows[1]["name"].ToString() == "ann" && !hasE && !hasS, "ann has only name: %v", rows[1])
+}
+
+func TestSqlNullLogic(t *testing.T) {
+
+	newSources := func() map[string]RowScanner {
+		users := NewContextSimple()
+		users.Insert(map[string]Value{"id": NewIntValue(1), "name": NewStringValue("bob"), "ct": NewIntValue(3), "email": NewStringValue("bob@x.io")})
+		users.Insert(map[string]Value{"id": NewIntValue(2), "name": NewStringValue("ann"), "ct": NewIntValue(9), "email": NewNilValue()})
+		users.Insert(map[string]Value{"id": NewIntValue(3), "name": NewStringValue("joe"), "ct": NewIntValue(12)})
+		orders := NewContextSimple()
+		orders.Insert(map[string]Value{"user_id": NewIntValue(1)})
+		orders.Insert(map[string]Value{"user_id": NewNilValue()})
+		return map[string]RowScanner{"users": users, "orders": orders}
+	}
+	names := func(sql string) string {
+		sqlVm, err := NewSqlVm(sql)
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		results, err := sqlVm.NewResults()
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		err = sqlVm.ExecuteJoin(results, newSources())
+		assert.Tf(t, err == nil, "Should not err %v", err)
+		out := make([]string, 0)
+		for _, row := range results.Rows() {
+			out = append(out, row["name"].ToString())
+		}
+		return strings.Join(out, ",")
+	}
+	for sql, want := range map[string]string{
+		`SELECT name FROM users WHERE missing > 5`:                                              "",
+		`SELECT name FROM users WHERE NOT missing > 5`:                                          "",
+		`SELECT name FROM users WHERE missing > 5 OR ct > 5`:                                    "ann,joe",
+		`SELECT name FROM users WHERE NOT (email = "x" AND ct > 5)`:                             "bob",
+		`SELECT name FROM users WHERE email NOT IN ("x")`:                                       "bob",
+		`SELECT name FROM users WHERE email IS NULL`:                                            "ann,joe",
+		`SELECT name FROM users WHERE id IN (SELECT user_id FROM orders)`:                       "bob",
+		`SELECT name FROM users WHERE id NOT IN (SELECT user_id FROM orders)`:                   "",
+		`SELECT name FROM users WHERE id NOT IN (SELECT user_id FROM orders WHERE user_id > 0)`: "ann,joe",
+		`SELECT name FROM users WHERE CASE WHEN missing > 5 THEN true ELSE ct < 5 END`:          "bob",
+	} {
+		got := names(sql)
+		assert.Tf(t, got == want, "%s: want %q but got %q", sql, want, got)
+	}
+}
+
 func verifySql(t *testing.T, sql string, readrows []ContextReader) *ContextSimple {
 
 	sqlVm, err := NewSqlVm(sql)
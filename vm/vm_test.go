@@ -2,6 +2,7 @@ package vm
 
 import (
 	u "github.com/araddon/gou"
+	ql "github.com/araddon/qlbridge/lex"
 	"github.com/bmizerany/assert"
 	"testing"
 )
@@ -34,6 +35,8 @@ var (
 		"bvalt":   NewBoolValue(true),
 		"bvalf":   NewBoolValue(false),
 		"user_id": NewStringValue("abc"),
+		"user-id": NewStringValue("def"),
+		"true":    NewIntValue(3),
 	})
 
 	// list of tests
@@ -43,6 +46,9 @@ var (
 
 		vmt("general int addition", `5 + 4`, int64(9), noError),
 		vmt("general float addition", `5.2 + 4`, float64(9.2), noError),
+		vmt("hex and binary addition", `0x1F + 0b1010`, int64(41), noError),
+		vmt("digit separator", `1_000_000 + 1`, int64(1000001), noError),
+		vmt("scientific notation", `1.5e-3 * 1000`, float64(1.5), noError),
 		vmt("associative math", `(4 + 5) / 2`, int64(4), noError),
 		vmt("boolean ?", `6 > 5`, true, noError),
 		vmt("boolean ?", `6 > 5.5`, true, noError),
@@ -68,6 +74,62 @@ var (
 
 		// context lookups? simple
 		vmt("ctx lookup ", `user_id`, "abc", noError),
+		vmt("ctx lookup quoted", "`user-id`", "def", noError),
+		vmt("ctx lookup bracket quoted", "[user-id]", "def", noError),
+		vmt("ctx lookup quoted keyword", "`true` + 2", int64(5), noError),
+
+		// string escapes
+		vmt("string doubled quote escape", `'it''s'`, "it's", noError),
+		vmt("string backslash escape", `"it\'s\ttab"`, "it's\ttab", noError),
+		vmt("string unicode escape", `"caf\u00e9"`, "café", noError),
+
+		// predicates
+		vmt("in list", `int5 IN (1, 5)`, true, noError),
+		vmt("not in list", `int5 NOT IN (1, 5)`, false, noError),
+		vmt("in list numeric string", `str5 IN (4 + 1, 6)`, true, noError),
+		vmt("in list of null", `notreal IN (1, 5)`, nil, noError),
+		vmt("between inclusive", `int5 BETWEEN 1 AND 5`, true, noError),
+		vmt("not between", `int5 NOT BETWEEN 6 AND 10`, true, noError),
+		vmt("like prefix", `user_id LIKE "a%"`, true, noError),
+		vmt("like one char", `user_id LIKE "a_"`, false, noError),
+		vmt("not like", `user_id NOT LIKE "%c"`, false, noError),
+		vmt("like escape", `"10%" LIKE "10!%" ESCAPE "!"`, true, noError),
+		vmt("like backslash escape", `"10x" LIKE "10\%"`, false, noError),
+		vmt("is null of missing", `notreal IS NULL`, true, noError),
+		vmt("is not null", `user_id IS NOT NULL`, true, noError),
+		vmt("not of comparison", `NOT int5 > 6`, true, noError),
+
+		// null, three valued logic where null is UNKNOWN
+		vmt("compare null", `notreal > 5`, nil, noError),
+		vmt("math on null", `notreal + 1`, nil, noError),
+		vmt("not null", `NOT notreal > 5`, nil, noError),
+		vmt("false and null", `bvalf && notreal > 5`, false, noError),
+		vmt("true and null", `bvalt AND notreal > 5`, nil, noError),
+		vmt("true or null", `notreal > 5 || bvalt`, true, noError),
+		vmt("false or null", `bvalf OR notreal > 5`, nil, noError),
+		vmt("in list with null", `int5 IN (1, notreal)`, nil, noError),
+		vmt("in list with null match", `int5 IN (5, notreal)`, true, noError),
+		vmt("between null", `notreal BETWEEN 1 AND 5`, nil, noError),
+		vmt("like null", `notreal LIKE "a%"`, nil, noError),
+		vmt("empty string is not null", `"" IS NULL`, false, noError),
+
+		// strings
+		vmt("string equal", `user_id == "abc"`, true, noError),
+		vmt("string sql equal", `user_id = "abc"`, true, noError),
+		vmt("string not equal", `user_id != "abd"`, true, noError),
+		vmt("string less", `user_id < "abd"`, true, noError),
+		vmt("string greater equal", `user_id >= "abc"`, true, noError),
+		vmt("string greater", `"b" > user_id`, true, noError),
+		vmt("string case sensitive", `user_id <= "ABC"`, false, noError),
+		vmt("numeric string equal int", `str5 == 5`, true, noError),
+		vmt("numeric strings compare as numbers", `"10" > "9"`, true, noError),
+
+		// case
+		vmt("case when", `CASE WHEN int5 > 1 THEN "big" ELSE "small" END`, "big", noError),
+		vmt("case when no match", `CASE WHEN int5 > 9 THEN "big" END`, nil, noError),
+		vmt("case simple", `CASE int5 WHEN 4 THEN 0 WHEN 5 THEN 1 ELSE 2 END`, int64(1), noError),
+		vmt("case when literal", `CASE WHEN 1 THEN 2 END`, nil, hasError),
+		vmt("case mixed results", `CASE WHEN int5 > 1 THEN 2 ELSE "x" END`, nil, hasError),
 
 		// functional syntax
 		vmt("eq/toint types", `eq(toint(int5),5)`, true, noError),
@@ -122,3 +184,37 @@ func TestRunExpr(t *testing.T) {
 		}
 	}
 }
+
+func TestRunExprDialect(t *testing.T) {
+	pipes := &ql.Dialect{Statements: ql.LogicalExpressionDialect.Statements, Concat: ql.ConcatPipes}
+	plus := &ql.Dialect{Statements: ql.LogicalExpressionDialect.Statements, Concat: ql.ConcatPlus}
+	nocase := &ql.Dialect{Statements: ql.LogicalExpressionDialect.Statements, Collation: ql.CollateNoCase}
+	tests := []struct {
+		dialect *ql.Dialect
+		qlText  string
+		result  interface{}
+	}{
+		{pipes, `user_id || "-" || int5`, "abc-5"},
+		{pipes, `user_id || "d" == "abcd"`, true},
+		{ql.LogicalExpressionDialect, `bvalf || bvalt`, true},
+		{plus, `user_id + "-" + int5`, "abc-5"},
+		{plus, `int5 + 1`, int64(6)},
+		{nocase, `user_id == "ABC"`, true},
+		{nocase, `user_id < "ABD"`, true},
+		{ql.LogicalExpressionDialect, `user_id == "ABC"`, false},
+	}
+	for _, test := range tests {
+		tree, err := ParseExpressionDialect(test.qlText, test.dialect)
+		assert.Tf(t, err == nil, "%s: parse err %v", test.qlText, err)
+		writeContext := NewContextSimple()
+		err = (&Vm{Tree: tree}).Execute(writeContext, msgContext)
+		assert.Tf(t, err == nil, "%s: exec err %v", test.qlText, err)
+		results, ok := writeContext.Get("")
+		assert.Tf(t, ok && results.Value() == test.result, "%s: want %v but got %v", test.qlText, test.result, results)
+	}
+
+	// + of strings is an error, not a warning for each row, if it doesn't concatenate
+	tree, _ := ParseExpressionDialect(`user_id + "d"`, ql.LogicalExpressionDialect)
+	err := (&Vm{Tree: tree}).Execute(NewContextSimple(), msgContext)
+	assert.Tf(t, err == ErrStringOp, "must err on + of strings: %v", err)
+}